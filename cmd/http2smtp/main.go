@@ -7,7 +7,9 @@ import (
 
 	"github.com/eexit/http2smtp/internal/api"
 	"github.com/eexit/http2smtp/internal/converter"
+	"github.com/eexit/http2smtp/internal/dkim"
 	"github.com/eexit/http2smtp/internal/env"
+	"github.com/eexit/http2smtp/internal/queue"
 	"github.com/eexit/http2smtp/internal/smtp"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/rs/zerolog"
@@ -31,14 +33,134 @@ func main() {
 		Str("version", api.Version).
 		Logger()
 
-	smtpClient := smtp.New(e.SMTPAddr, logger)
+	var keyring *dkim.Keyring
+	if e.DKIMKeysFile != "" {
+		if keyring, err = dkim.LoadKeyring(e.DKIMKeysFile); err != nil {
+			panic(err)
+		}
+	}
+
+	tlsMode, err := smtp.ParseTLSMode(e.SMTPTLS)
+	if err != nil {
+		panic(err)
+	}
+
+	tlsConfig, err := smtp.NewTLSConfig(smtp.TLSFiles{
+		CAFile:   e.SMTPTLSCAFile,
+		CertFile: e.SMTPTLSCertFile,
+		KeyFile:  e.SMTPTLSKeyFile,
+	}, e.SMTPTLSInsecureSkipVerify)
+	if err != nil {
+		panic(err)
+	}
+
+	password, err := smtp.ReadSecret(e.SMTPPassword, e.SMTPPasswordFile)
+	if err != nil {
+		panic(err)
+	}
+
+	token, err := smtp.ReadSecret(e.SMTPOAuth2Token, e.SMTPOAuth2TokenFile)
+	if err != nil {
+		panic(err)
+	}
+
+	upstreams, err := smtp.ParseUpstreams(e.SMTPAddr)
+	if err != nil {
+		panic(err)
+	}
 
-	converterProvider := converter.NewProvider(
-		converter.NewRFC5322(),
-		converter.NewSparkPost(),
+	strategy, err := smtp.ParseStrategy(e.SMTPStrategy)
+	if err != nil {
+		panic(err)
+	}
+
+	retryPolicy := smtp.RetryPolicy{
+		Attempts: e.SMTPRetryAttempts,
+		Backoff: smtp.Backoff{
+			Min: time.Duration(e.SMTPRetryBackoff) * time.Second,
+			Max: time.Duration(e.SMTPRetryBackoffMax) * time.Second,
+		},
+	}
+	// The queue retries the failed deliveries itself
+	if e.QueueDir != "" {
+		retryPolicy = smtp.NoRetry
+	}
+
+	var smtpClient smtp.Client = smtp.NewRelay(
+		upstreams,
+		smtp.RelayConfig{
+			Strategy:         strategy,
+			FailureThreshold: e.SMTPCircuitFailures,
+			Cooldown:         time.Duration(e.SMTPCircuitCooldown) * time.Second,
+		},
+		logger,
+		smtp.WithBounceAddress(e.BounceAddress),
+		smtp.WithVERP(e.VERP),
+		smtp.WithDKIM(keyring),
+		smtp.WithTimeout(time.Duration(e.SMTPTimeout)*time.Second),
+		smtp.WithTLS(tlsMode, tlsConfig),
+		smtp.WithAuth(smtp.Credentials{
+			Username:  e.SMTPUsername,
+			Password:  password,
+			Token:     token,
+			Mechanism: e.SMTPAuthMechanism,
+		}),
+		smtp.WithPool(smtp.PoolConfig{
+			MaxActive:   e.SMTPPoolMaxActive,
+			MaxIdle:     e.SMTPPoolMaxIdle,
+			IdleTimeout: time.Duration(e.SMTPPoolIdleTimeout) * time.Second,
+			MaxMessages: e.SMTPPoolMaxMessages,
+		}),
+		smtp.WithRetry(retryPolicy),
 	)
 
-	app := api.New(e, logger, smtpClient, converterProvider)
+	if e.QueueDir != "" {
+		if smtpClient, err = queue.New(
+			e.QueueDir,
+			smtpClient,
+			logger,
+			queue.WithWorkers(e.QueueWorkers),
+			queue.WithDeadLetterDir(e.QueueDeadLetterDir),
+			queue.WithRetry(smtp.RetryPolicy{
+				Attempts: e.QueueRetryAttempts,
+				Backoff: smtp.Backoff{
+					Min: time.Duration(e.QueueRetryBackoff) * time.Second,
+					Max: time.Duration(e.QueueRetryBackoffMax) * time.Second,
+				},
+			}),
+		); err != nil {
+			panic(err)
+		}
+	}
+
+	spooler := converter.NewSpooler(e.SpoolDir, e.SpoolThreshold)
+
+	converters := []converter.Converter{
+		converter.NewRFC5322(spooler),
+		converter.NewSparkPost(spooler),
+	}
+
+	if e.JSONMappingsFile != "" {
+		mappings, err := converter.LoadJSONMappings(e.JSONMappingsFile, spooler)
+		if err != nil {
+			panic(err)
+		}
+		converters = append(converters, mappings...)
+	}
+
+	converterProvider, err := converter.Enable(converter.NewProvider(converters...), e.EnabledConverters)
+	if err != nil {
+		panic(err)
+	}
+
+	var filters converter.FilterChain
+	if e.FiltersFile != "" {
+		if filters, err = converter.LoadFilters(e.FiltersFile); err != nil {
+			panic(err)
+		}
+	}
+
+	app := api.New(e, logger, smtpClient, converterProvider, filters)
 	if err := app.Serve(); err != nil {
 		panic(err)
 	}
@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/eexit/http2smtp/internal/queue"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/hlog"
+)
+
+// DeadLetters exposes the messages whose delivery failed permanently
+type DeadLetters interface {
+	DeadLetters() ([]queue.DeadLetter, error)
+	DeadLetter(id string) (*queue.DeadLetter, error)
+	DeadLetterMessage(id string) (io.ReadCloser, error)
+	Requeue(id string) error
+	DeleteDeadLetter(id string) error
+}
+
+// ListDeadLetters handles the listing of the dead letters
+func ListDeadLetters(dl DeadLetters) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		letters, err := dl.DeadLetters()
+		if err != nil {
+			writeDeadLetterError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		(json.NewEncoder(w).Encode(map[string]interface{}{"results": letters}))
+	}
+}
+
+// GetDeadLetter handles the inspection of a dead letter: its delivery
+// error and SMTP transcript
+func GetDeadLetter(dl DeadLetters) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		letter, err := dl.DeadLetter(mux.Vars(r)["id"])
+		if err != nil {
+			writeDeadLetterError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		(json.NewEncoder(w).Encode(map[string]interface{}{"results": letter}))
+	}
+}
+
+// GetDeadLetterMessage handles the download of the raw content of a dead letter
+func GetDeadLetterMessage(dl DeadLetters) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		content, err := dl.DeadLetterMessage(mux.Vars(r)["id"])
+		if err != nil {
+			writeDeadLetterError(w, r, err)
+			return
+		}
+		defer content.Close()
+
+		w.Header().Set("Content-Type", "message/rfc822")
+		w.WriteHeader(http.StatusOK)
+		(io.Copy(w, content))
+	}
+}
+
+// RequeueDeadLetter handles the requeuing of a dead letter
+func RequeueDeadLetter(dl DeadLetters) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if err := dl.Requeue(id); err != nil {
+			writeDeadLetterError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		(json.NewEncoder(w).Encode(map[string]interface{}{"results": map[string]string{"id": id}}))
+	}
+}
+
+// DeleteDeadLetter handles the deletion of a dead letter
+func DeleteDeadLetter(dl DeadLetters) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := dl.DeleteDeadLetter(mux.Vars(r)["id"]); err != nil {
+			writeDeadLetterError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeDeadLetterError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, queue.ErrNotFound) {
+		status = http.StatusNotFound
+	} else {
+		hlog.FromRequest(r).Error().Err(err).Msg("failed to handle dead letter")
+	}
+
+	w.WriteHeader(status)
+	(json.NewEncoder(w).Encode(map[string]string{"error": err.Error()}))
+}
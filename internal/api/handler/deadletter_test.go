@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eexit/http2smtp/internal/queue"
+	"github.com/gorilla/mux"
+)
+
+// fakeDeadLetters holds a single dead letter
+type fakeDeadLetters struct {
+	err      error
+	requeued string
+	deleted  string
+}
+
+func (f *fakeDeadLetters) DeadLetters() ([]queue.DeadLetter, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return []queue.DeadLetter{{ID: "id", Error: "550 mailbox unavailable"}}, nil
+}
+
+func (f *fakeDeadLetters) DeadLetter(id string) (*queue.DeadLetter, error) {
+	if id != "id" {
+		return nil, queue.ErrNotFound
+	}
+	return &queue.DeadLetter{ID: "id", Error: "550 mailbox unavailable", Transcript: "C: DATA\r\n"}, nil
+}
+
+func (f *fakeDeadLetters) DeadLetterMessage(id string) (io.ReadCloser, error) {
+	if id != "id" {
+		return nil, queue.ErrNotFound
+	}
+	return io.NopCloser(strings.NewReader("Subject: test\r\n\r\nbody")), nil
+}
+
+func (f *fakeDeadLetters) Requeue(id string) error {
+	if id != "id" {
+		return queue.ErrNotFound
+	}
+	f.requeued = id
+	return nil
+}
+
+func (f *fakeDeadLetters) DeleteDeadLetter(id string) error {
+	if id != "id" {
+		return queue.ErrNotFound
+	}
+	f.deleted = id
+	return nil
+}
+
+func TestDeadLetters(t *testing.T) {
+	tests := []struct {
+		name     string
+		dl       *fakeDeadLetters
+		method   string
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "dead letters are listed",
+			dl:       &fakeDeadLetters{},
+			method:   http.MethodGet,
+			path:     "/dead-letters",
+			wantCode: http.StatusOK,
+			wantBody: `{"results":[{"id":"id","message_id":"","envelope":{"from":""},"attempts":0,"created_at":"0001-01-01T00:00:00Z","failed_at":"0001-01-01T00:00:00Z","error":"550 mailbox unavailable"}]}`,
+		},
+		{
+			name:     "dead letters listing fails",
+			dl:       &fakeDeadLetters{err: errors.New("permission denied")},
+			method:   http.MethodGet,
+			path:     "/dead-letters",
+			wantCode: http.StatusInternalServerError,
+			wantBody: `{"error":"permission denied"}`,
+		},
+		{
+			name:     "dead letter is returned with its transcript",
+			dl:       &fakeDeadLetters{},
+			method:   http.MethodGet,
+			path:     "/dead-letters/id",
+			wantCode: http.StatusOK,
+			wantBody: `{"results":{"id":"id","message_id":"","envelope":{"from":""},"attempts":0,"created_at":"0001-01-01T00:00:00Z","failed_at":"0001-01-01T00:00:00Z","error":"550 mailbox unavailable","transcript":"C: DATA\r\n"}}`,
+		},
+		{
+			name:     "unknown dead letter",
+			dl:       &fakeDeadLetters{},
+			method:   http.MethodGet,
+			path:     "/dead-letters/ghost",
+			wantCode: http.StatusNotFound,
+			wantBody: `{"error":"dead letter not found"}`,
+		},
+		{
+			name:     "dead letter message is returned",
+			dl:       &fakeDeadLetters{},
+			method:   http.MethodGet,
+			path:     "/dead-letters/id/message",
+			wantCode: http.StatusOK,
+			wantBody: "Subject: test\r\n\r\nbody",
+		},
+		{
+			name:     "dead letter is requeued",
+			dl:       &fakeDeadLetters{},
+			method:   http.MethodPost,
+			path:     "/dead-letters/id/requeue",
+			wantCode: http.StatusAccepted,
+			wantBody: `{"results":{"id":"id"}}`,
+		},
+		{
+			name:     "unknown dead letter is not requeued",
+			dl:       &fakeDeadLetters{},
+			method:   http.MethodPost,
+			path:     "/dead-letters/ghost/requeue",
+			wantCode: http.StatusNotFound,
+			wantBody: `{"error":"dead letter not found"}`,
+		},
+		{
+			name:     "dead letter is deleted",
+			dl:       &fakeDeadLetters{},
+			method:   http.MethodDelete,
+			path:     "/dead-letters/id",
+			wantCode: http.StatusNoContent,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mux.NewRouter()
+			r.Handle("/dead-letters", ListDeadLetters(tt.dl)).Methods(http.MethodGet)
+			r.Handle("/dead-letters/{id}", GetDeadLetter(tt.dl)).Methods(http.MethodGet)
+			r.Handle("/dead-letters/{id}", DeleteDeadLetter(tt.dl)).Methods(http.MethodDelete)
+			r.Handle("/dead-letters/{id}/message", GetDeadLetterMessage(tt.dl)).Methods(http.MethodGet)
+			r.Handle("/dead-letters/{id}/requeue", RequeueDeadLetter(tt.dl)).Methods(http.MethodPost)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if w.Code != tt.wantCode {
+				t.Errorf("returned status code %v, want %v", w.Code, tt.wantCode)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.wantBody {
+				t.Errorf("returned body %#v, want %#v", got, tt.wantBody)
+			}
+		})
+	}
+}
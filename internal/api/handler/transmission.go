@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/eexit/http2smtp/internal/converter"
+	"github.com/eexit/http2smtp/internal/ctx"
+	"github.com/eexit/http2smtp/internal/smtp"
+	"github.com/rs/zerolog/hlog"
+)
+
+// Transmission handles the calls of a converter routes: the request is
+// converted into a message which is then sent. The responses are rendered
+// by the converter so they match the vendor API it mimics. Converted messages
+// are checked against the given limits, then normalized, stamped with the
+// given domain and filtered before being sent. Dropped messages are reported
+// as sent to no recipient.
+func Transmission(
+	smtpClient smtp.Client,
+	c converter.Converter,
+	limits converter.Limits,
+	normalizer *converter.Normalizer,
+	filter converter.MessageFilter,
+	domain string,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := hlog.FromRequest(r).With().Str("converter", string(c.ID())).Logger()
+
+		message, err := c.Convert(r)
+		if err != nil {
+			logger.Error().Err(err).Msg("failed to convert request")
+			c.WriteError(w, converter.WrapError(converter.KindInvalid, err))
+			return
+		}
+		// The message may be replaced by its normalized version
+		defer func() { (message.Close()) }()
+
+		if err := limits.Check(message); err != nil {
+			logger.Error().Err(err).Msg("message exceeds limits")
+			c.WriteError(w, converter.WrapError(converter.KindInternal, err))
+			return
+		}
+
+		normalized, err := normalizer.Normalize(message)
+		if err != nil {
+			logger.Error().Err(err).Msg("failed to normalize message")
+			c.WriteError(w, converter.WrapError(converter.KindInternal, err))
+			return
+		}
+		message = normalized
+
+		if err := message.Stamp(converter.Submission{
+			Domain:   domain,
+			ClientIP: clientIP(r),
+			TraceID:  ctx.TraceID(r.Context()),
+			Time:     time.Now(),
+		}); err != nil {
+			logger.Error().Err(err).Msg("failed to stamp message")
+			c.WriteError(w, converter.WrapError(converter.KindInternal, err))
+			return
+		}
+
+		if err := filter.Filter(r.Context(), message); err != nil {
+			if errors.Is(err, converter.ErrDropped) {
+				logger.Info().Msg("message dropped by filter")
+				c.WriteResponse(w, &converter.Result{MessageID: message.MessageID()})
+				return
+			}
+
+			logger.Error().Err(err).Msg("message rejected by filter")
+			c.WriteError(w, converter.WrapError(converter.KindUnprocessable, err))
+			return
+		}
+
+		sentCount, err := smtpClient.Send(r.Context(), message)
+		if err != nil {
+			logger.Error().Err(err).Msg("failed to send message")
+			c.WriteError(w, converter.WrapError(converter.KindDelivery, err))
+			return
+		}
+
+		c.WriteResponse(w, &converter.Result{
+			Accepted:  sentCount,
+			MessageID: message.MessageID(),
+		})
+	}
+}
+
+// clientIP returns the IP address of the request client
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,260 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eexit/http2smtp/internal/converter"
+	"github.com/eexit/http2smtp/internal/ctx"
+	"github.com/eexit/http2smtp/internal/smtp"
+)
+
+var normalizer = converter.NewNormalizer(converter.NewSpooler("", converter.DefaultSpoolThreshold), false)
+
+func TestTransmission(t *testing.T) {
+	type args struct {
+		smtpClient smtp.Client
+		converter  converter.Converter
+	}
+	tests := []struct {
+		name     string
+		args     args
+		wantCode int
+		wantBody string
+	}{
+		{
+			name: "conversion failed",
+			args: args{
+				converter: &converter.Stub{Err: errors.New("conversion failed")},
+			},
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"error":"conversion failed"}`,
+		},
+		{
+			name: "conversion failed with a kind",
+			args: args{
+				converter: &converter.Stub{Err: &converter.Error{
+					Kind: converter.KindUnsupportedMediaType,
+					Err:  errors.New("unsupported media type"),
+				}},
+			},
+			wantCode: http.StatusUnsupportedMediaType,
+			wantBody: `{"error":"unsupported media type"}`,
+		},
+		{
+			name: "send error",
+			args: args{
+				converter: &converter.Stub{},
+				smtpClient: &smtp.Stub{
+					SentCount: 0,
+					Err:       errors.New("smtp error"),
+				},
+			},
+			wantCode: http.StatusInternalServerError,
+			wantBody: `{"error":"smtp error"}`,
+		},
+		{
+			name: "send ok",
+			args: args{
+				converter:  &converter.Stub{},
+				smtpClient: &smtp.Stub{SentCount: 42},
+			},
+			wantCode: http.StatusCreated,
+			wantBody: `{"total_accepted_recipients":42}`,
+		},
+		{
+			name: "send ok with message ID",
+			args: args{
+				converter: &converter.Stub{Message: func() *converter.Message {
+					msg, _ := converter.ParseMessage(strings.NewReader("Message-ID: <42@example.com>\r\n\r\nHello"))
+					return msg
+				}()},
+				smtpClient: &smtp.Stub{SentCount: 1},
+			},
+			wantCode: http.StatusCreated,
+			wantBody: `{"total_accepted_recipients":1,"message_id":"42@example.com"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Transmission(tt.args.smtpClient, tt.args.converter, converter.Limits{}, normalizer, converter.FilterChain{}, "example.org")
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(nil))
+
+			handler(w, r)
+
+			resp := w.Result()
+
+			if c := resp.StatusCode; c != tt.wantCode {
+				t.Errorf("Transmission() code = %v, want %v", c, tt.wantCode)
+			}
+
+			rb, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("could not read response body: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if body := strings.TrimSpace(string(rb)); body != tt.wantBody {
+				t.Errorf("Transmission() body = %#v, want %#v", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestTransmission_stamp(t *testing.T) {
+	raw := "From: from@example.com\r\nTo: to@example.com\r\n\r\nHello"
+	msg, err := converter.ParseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ParseMessage() error = %v", err)
+	}
+
+	handler := Transmission(&smtp.Stub{SentCount: 1}, &converter.Stub{Message: msg}, converter.Limits{}, normalizer, converter.FilterChain{}, "example.org")
+
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(nil))
+	r.RemoteAddr = "203.0.113.1:4242"
+	r = r.WithContext(ctx.WithTraceID(r.Context(), "trace"))
+
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	if !strings.HasSuffix(msg.MessageID(), "@example.org") {
+		t.Errorf("MessageID() = %#v, want a generated ID", msg.MessageID())
+	}
+	if msg.Header().Get("Date") == "" {
+		t.Error("Date header is missing")
+	}
+
+	received := msg.Header().Fields()[0]
+	if received.Key != "Received" || !strings.HasPrefix(received.Value, "from [203.0.113.1] by example.org (http2smtp) with HTTP id trace; ") {
+		t.Errorf("first header field = %#v, want a Received one", received)
+	}
+
+	if body := w.Body.String(); !strings.Contains(body, msg.MessageID()) {
+		t.Errorf("Transmission() body = %#v, want the message ID", body)
+	}
+}
+
+func Test_clientIP(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		want       string
+	}{
+		{"203.0.113.1:4242", "203.0.113.1"},
+		{"[::1]:4242", "::1"},
+		{"pipe", "pipe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.remoteAddr, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransmission_normalize(t *testing.T) {
+	msg, err := converter.ParseMessage(strings.NewReader("From: from@example.com\nTo: to@example.com\n\nHello"))
+	if err != nil {
+		t.Fatalf("ParseMessage() error = %v", err)
+	}
+
+	strict := converter.NewNormalizer(converter.NewSpooler("", converter.DefaultSpoolThreshold), true)
+	handler := Transmission(&smtp.Stub{SentCount: 1}, &converter.Stub{Message: msg}, converter.Limits{}, strict, converter.FilterChain{}, "example.org")
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(nil)))
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Transmission() code = %v, want %v", w.Code, http.StatusUnprocessableEntity)
+	}
+	if body, want := strings.TrimSpace(w.Body.String()), `{"error":"message is not normalized: line 1: bare LF"}`; body != want {
+		t.Errorf("Transmission() body = %#v, want %#v", body, want)
+	}
+}
+
+func TestTransmission_filter(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   converter.MessageFilter
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "filtered message",
+			filter:   converter.FilterChain{},
+			wantCode: http.StatusCreated,
+			wantBody: `{"total_accepted_recipients":1,"message_id":"1@example.com"}`,
+		},
+		{
+			name: "dropped message",
+			filter: converter.MessageFilterFunc(func(context.Context, *converter.Message) error {
+				return converter.ErrDropped
+			}),
+			wantCode: http.StatusCreated,
+			wantBody: `{"total_accepted_recipients":0,"message_id":"1@example.com"}`,
+		},
+		{
+			name: "rejected message",
+			filter: converter.MessageFilterFunc(func(context.Context, *converter.Message) error {
+				return errors.New("rejected")
+			}),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `{"error":"rejected"}`,
+		},
+		{
+			name: "rejected message with error kind",
+			filter: converter.MessageFilterFunc(func(context.Context, *converter.Message) error {
+				return &converter.Error{Kind: converter.KindTooLarge, Err: errors.New("too large")}
+			}),
+			wantCode: http.StatusRequestEntityTooLarge,
+			wantBody: `{"error":"too large"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := converter.ParseMessage(strings.NewReader("Message-ID: <1@example.com>\r\nFrom: from@example.com\r\n\r\nHello\r\n"))
+			if err != nil {
+				t.Fatalf("ParseMessage() error = %v", err)
+			}
+
+			handler := Transmission(&smtp.Stub{SentCount: 1}, &converter.Stub{Message: msg}, converter.Limits{}, normalizer, tt.filter, "example.org")
+
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(nil)))
+
+			if w.Code != tt.wantCode {
+				t.Errorf("Transmission() code = %v, want %v", w.Code, tt.wantCode)
+			}
+			if body := strings.TrimSpace(w.Body.String()); body != tt.wantBody {
+				t.Errorf("Transmission() body = %#v, want %#v", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestTransmission_limits(t *testing.T) {
+	msg := converter.NewMessage("from@example.com", []string{"a@example.com", "b@example.com"}, nil, nil, strings.NewReader("Hello\r\n"))
+
+	limits := converter.Limits{Recipients: 1}
+	handler := Transmission(&smtp.Stub{SentCount: 2}, &converter.Stub{Message: msg}, limits, normalizer, converter.FilterChain{}, "example.org")
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(nil)))
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Transmission() code = %v, want %v", w.Code, http.StatusUnprocessableEntity)
+	}
+	if body, want := strings.TrimSpace(w.Body.String()), `{"error":"recipient count 2 exceeds the limit of 1"}`; body != want {
+		t.Errorf("Transmission() body = %#v, want %#v", body, want)
+	}
+}
@@ -1,7 +1,9 @@
 package api
 
 import (
+	"crypto/subtle"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/eexit/http2smtp/internal/ctx"
@@ -69,3 +71,19 @@ func traceIDHeaderHandler(header string) func(next http.Handler) http.Handler {
 		})
 	}
 }
+
+// bearerAuthHandler rejects the requests without the given bearer token
+func bearerAuthHandler(token string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme, got, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+			if token == "" || !ok || !strings.EqualFold(scheme, "Bearer") ||
+				subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
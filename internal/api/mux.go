@@ -2,8 +2,10 @@ package api
 
 import (
 	"net/http"
+	"os"
 
 	"github.com/eexit/http2smtp/internal/api/handler"
+	"github.com/eexit/http2smtp/internal/converter"
 	"github.com/gorilla/mux"
 )
 
@@ -14,8 +16,82 @@ func (a *API) Mux() http.Handler {
 	r.Handle("/healthcheck", handler.Healthcheck(Version)).
 		Methods(http.MethodHead, http.MethodGet)
 
-	r.Handle("/sparkpost/api/v1/transmissions", handler.SparkPost(a.smtpClient, a.converterProvider)).
-		Methods(http.MethodPost)
+	domain := a.messageIDDomain()
+	normalizer := converter.NewNormalizer(
+		converter.NewSpooler(a.env.SpoolDir, a.env.SpoolThreshold),
+		a.env.StrictMode,
+	)
+
+	limits := converter.Limits{
+		MessageSize:    a.env.MaxMessageSize,
+		Attachments:    a.env.MaxAttachments,
+		AttachmentSize: a.env.MaxAttachmentSize,
+		Recipients:     a.env.MaxRecipients,
+	}
+
+	filter := a.filter
+	if filter == nil {
+		filter = converter.FilterChain{}
+	}
+
+	// Mounts the routes declared by each enabled converter
+	for _, c := range a.converters() {
+		for _, route := range c.Routes() {
+			a.logger.Debug().
+				Str("converter", string(c.ID())).
+				Str("path", route.Path).
+				Strs("methods", route.Methods).
+				Msg("mounting converter route")
+
+			r.Handle(route.Path, handler.Transmission(a.smtpClient, c, limits, normalizer, filter, domain)).
+				Methods(route.Methods...)
+		}
+	}
+
+	// The dead letters of the delivery queue are exposed to the admins
+	if dl, ok := a.smtpClient.(handler.DeadLetters); ok && a.env.AdminToken != "" {
+		a.logger.Debug().Msg("mounting dead letter routes")
+
+		admin := r.PathPrefix("/admin/dead-letters").Subrouter()
+		admin.Use(bearerAuthHandler(a.env.AdminToken))
+
+		admin.Handle("", handler.ListDeadLetters(dl)).Methods(http.MethodGet)
+		admin.Handle("/{id}", handler.GetDeadLetter(dl)).Methods(http.MethodGet)
+		admin.Handle("/{id}", handler.DeleteDeadLetter(dl)).Methods(http.MethodDelete)
+		admin.Handle("/{id}/message", handler.GetDeadLetterMessage(dl)).Methods(http.MethodGet)
+		admin.Handle("/{id}/requeue", handler.RequeueDeadLetter(dl)).Methods(http.MethodPost)
+	}
 
 	return r
 }
+
+// messageIDDomain returns the configured Message-ID domain, or the
+// host name when not configured
+func (a *API) messageIDDomain() string {
+	if a.env.MessageIDDomain != "" {
+		return a.env.MessageIDDomain
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		a.logger.Warn().Err(err).Msg("could not get host name, using localhost as message ID domain")
+		return "localhost"
+	}
+	return hostname
+}
+
+// converters returns the provided converters, which are the enabled ones
+func (a *API) converters() []converter.Converter {
+	ids := a.converterProvider.IDs()
+
+	converters := make([]converter.Converter, 0, len(ids))
+	for _, id := range ids {
+		c, err := a.converterProvider.Get(id)
+		if err != nil {
+			a.logger.Warn().Err(err).Msg("converter is not available")
+			continue
+		}
+		converters = append(converters, c)
+	}
+	return converters
+}
@@ -1,13 +1,18 @@
 package api
 
 import (
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 	"time"
 
 	"github.com/eexit/http2smtp/internal/converter"
+	"github.com/eexit/http2smtp/internal/env"
+	"github.com/eexit/http2smtp/internal/queue"
 	"github.com/eexit/http2smtp/internal/smtp"
+	"github.com/rs/zerolog"
 )
 
 func TestAPI_Mux(t *testing.T) {
@@ -15,6 +20,7 @@ func TestAPI_Mux(t *testing.T) {
 		name      string
 		method    string
 		routePath string
+		header    http.Header
 		wantCode  int
 	}{
 		{
@@ -41,12 +47,52 @@ func TestAPI_Mux(t *testing.T) {
 			routePath: "/sparkpost/api/v1/transmissions",
 			wantCode:  http.StatusCreated,
 		},
+		{
+			name:      "GET rfc5322 route returns 405",
+			method:    http.MethodGet,
+			routePath: "/rfc5322",
+			wantCode:  http.StatusMethodNotAllowed,
+		},
+		{
+			name:      "POST rfc5322 route returns 201",
+			method:    http.MethodPost,
+			routePath: "/rfc5322",
+			header:    http.Header{"Content-Type": []string{"message/rfc822"}},
+			wantCode:  http.StatusCreated,
+		},
+		{
+			name:      "POST JSON mapping route is mounted",
+			method:    http.MethodPost,
+			routePath: "/acme/send",
+			wantCode:  http.StatusBadRequest, // the request has no body
+		},
 	}
+
+	mapping, err := converter.NewJSONMapping(converter.JSONMapping{
+		ID:     "acme",
+		Path:   "/acme/send",
+		Fields: converter.JSONFields{From: "$.from"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("could not create JSON mapping converter: %v", err)
+	}
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			s := &API{
-				smtpClient:        &smtp.Stub{},
-				converterProvider: converter.NewProvider(&converter.Stub{StubID: converter.SparkPostID}),
+				logger:     zerolog.New(io.Discard),
+				smtpClient: &smtp.Stub{},
+				converterProvider: converter.NewProvider(
+					&converter.Stub{
+						StubID:     converter.SparkPostID,
+						StubRoutes: converter.NewSparkPost(nil).Routes(),
+					},
+					&converter.Stub{
+						StubID:     converter.RFC5322ID,
+						StubRoutes: converter.NewRFC5322(nil).Routes(),
+					},
+					mapping,
+				),
 			}
 
 			api := httptest.NewServer(s.Mux())
@@ -57,6 +103,10 @@ func TestAPI_Mux(t *testing.T) {
 				t.Fatalf("could not create request: %v", err)
 			}
 
+			if tt.header != nil {
+				req.Header = tt.header
+			}
+
 			client := &http.Client{Timeout: 1 * time.Second}
 
 			resp, err := client.Do(req)
@@ -71,3 +121,95 @@ func TestAPI_Mux(t *testing.T) {
 		})
 	}
 }
+
+func TestAPI_Mux_deadLetters(t *testing.T) {
+	tests := []struct {
+		name          string
+		adminToken    string
+		authorization string
+		wantCode      int
+	}{
+		{
+			name:     "routes are not mounted without admin token",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:       "request without token is rejected",
+			adminToken: "secret",
+			wantCode:   http.StatusUnauthorized,
+		},
+		{
+			name:          "request with wrong token is rejected",
+			adminToken:    "secret",
+			authorization: "Bearer guess",
+			wantCode:      http.StatusUnauthorized,
+		},
+		{
+			name:          "token without bearer scheme is rejected",
+			adminToken:    "secret",
+			authorization: "secret",
+			wantCode:      http.StatusUnauthorized,
+		},
+		{
+			name:          "token of another scheme is rejected",
+			adminToken:    "secret",
+			authorization: "Basic secret",
+			wantCode:      http.StatusUnauthorized,
+		},
+		{
+			name:          "request with admin token is served",
+			adminToken:    "secret",
+			authorization: "Bearer secret",
+			wantCode:      http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := queue.New(t.TempDir(), &smtp.Stub{}, zerolog.Nop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer q.Close()
+
+			s := &API{
+				env:               env.Bag{AdminToken: tt.adminToken},
+				logger:            zerolog.Nop(),
+				smtpClient:        q,
+				converterProvider: converter.NewProvider(),
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/admin/dead-letters", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			w := httptest.NewRecorder()
+			s.Mux().ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("route returned status code %v, want %v", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAPI_messageIDDomain(t *testing.T) {
+	t.Run("configured domain", func(t *testing.T) {
+		s := &API{env: env.Bag{MessageIDDomain: "example.org"}, logger: zerolog.Nop()}
+		if got := s.messageIDDomain(); got != "example.org" {
+			t.Errorf("messageIDDomain() = %#v, want %#v", got, "example.org")
+		}
+	})
+
+	t.Run("host name", func(t *testing.T) {
+		want, err := os.Hostname()
+		if err != nil {
+			t.Skipf("no host name: %v", err)
+		}
+
+		s := &API{logger: zerolog.Nop()}
+		if got := s.messageIDDomain(); got != want {
+			t.Errorf("messageIDDomain() = %#v, want %#v", got, want)
+		}
+	})
+}
@@ -44,6 +44,7 @@ type API struct {
 	cancelFunc        context.CancelFunc
 	smtpClient        smtp.Client
 	converterProvider converter.Provider
+	filter            converter.MessageFilter
 	env               env.Bag
 	sigint            chan os.Signal
 }
@@ -54,6 +55,7 @@ func New(
 	logger zerolog.Logger,
 	smtpClient smtp.Client,
 	converterProvider converter.Provider,
+	filter converter.MessageFilter,
 ) *API {
 	// This context will be used as a base context for all incoming
 	// request. It is cancellable so when the server is shutting down,
@@ -69,6 +71,7 @@ func New(
 		shutdownCtx:       ctx,
 		smtpClient:        smtpClient,
 		converterProvider: converterProvider,
+		filter:            filter,
 		sigint:            make(chan os.Signal, 1),
 	}
 
@@ -90,6 +93,26 @@ func New(
 	return svr
 }
 
+// gracefulClient is an SMTP client with pending work to finish on shutdown,
+// like the delivery queue
+type gracefulClient interface {
+	Shutdown(context.Context) error
+}
+
+// closeSMTPClient closes the SMTP client, within the given context deadline
+// when it has pending work
+func (a *API) closeSMTPClient(ctx context.Context) {
+	var err error
+	if c, ok := a.smtpClient.(gracefulClient); ok {
+		err = c.Shutdown(ctx)
+	} else {
+		err = a.smtpClient.Close()
+	}
+	if err != nil {
+		a.logger.Error().Err(err).Msg("smtp client close error")
+	}
+}
+
 // Serve listens and serves for incoming HTTP request. It also handles
 // graceful shutdown logic
 func (a *API) Serve() error {
@@ -116,14 +139,16 @@ func (a *API) Serve() error {
 			a.logger.Info().Msg("closing server")
 
 			a.cancelFunc()
-			(a.smtpClient.Close()) // closes SMTP connection
 
 			// server shutdown context
 			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Duration(a.env.ServerShutdownTimeout)*time.Second)
 			defer shutdownCancel()
 
-			// We received an interrupt signal, shut down
-			if err := a.svr.Shutdown(shutdownCtx); err != nil {
+			// We received an interrupt signal, shut down. The requests are
+			// not accepted anymore when the SMTP client is closed.
+			err := a.svr.Shutdown(shutdownCtx)
+			a.closeSMTPClient(shutdownCtx)
+			if err != nil {
 				// Error shutting down
 				err = fmt.Errorf("server close error: %w", err)
 				a.logger.Error().Msg(err.Error())
@@ -21,16 +21,17 @@ import (
 func TestNew(t *testing.T) {
 	got := New(
 		env.Bag{
-			SMTPAddr: "smtp:25",
+			SMTPAddr: []string{"smtp:25"},
 			LogLevel: "info",
 		},
 		zerolog.New(io.Discard),
 		&smtp.Stub{},
 		converter.NewProvider(),
+		converter.FilterChain{},
 	)
 	want := &API{
 		env: env.Bag{
-			SMTPAddr: "smtp:25",
+			SMTPAddr: []string{"smtp:25"},
 			LogLevel: "info",
 		},
 		logger:            zerolog.New(io.Discard),
@@ -140,9 +141,50 @@ func TestAPI_Serve(t *testing.T) {
 	}
 }
 
+func TestAPI_Serve_shutdownOrder(t *testing.T) {
+	var events []string
+	shutdownCtx, cancelFunc := context.WithCancel(context.Background())
+
+	a := &API{
+		svr:         &stubServer{serveTimeoutAfter: time.Second, events: &events},
+		env:         env.Bag{ServerShutdownTimeout: 1},
+		shutdownCtx: shutdownCtx,
+		logger:      zerolog.New(io.Discard),
+		cancelFunc:  cancelFunc,
+		smtpClient:  &gracefulStub{events: &events},
+		sigint:      make(chan os.Signal, 1),
+	}
+
+	a.sigint <- syscall.SIGUSR1
+	if err := a.Serve(); err != nil {
+		t.Fatalf("Server.Serve() error = %v", err)
+	}
+
+	// The requests are not accepted anymore when the queue is closed
+	if want := []string{"server shutdown", "smtp client shutdown"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+// gracefulStub is a smtp.Stub shut down within a deadline
+type gracefulStub struct {
+	smtp.Stub
+	events *[]string
+}
+
+func (s *gracefulStub) Shutdown(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return errors.New("no shutdown deadline")
+	}
+	*s.events = append(*s.events, "smtp client shutdown")
+	return nil
+}
+
 type stubServer struct {
 	serveTimeoutAfter     time.Duration
 	serveErr, shutdownErr error
+	// events records the shutdown when not nil
+	events *[]string
 }
 
 func (s *stubServer) ListenAndServe() error {
@@ -154,6 +196,9 @@ func (s *stubServer) ListenAndServe() error {
 }
 
 func (s *stubServer) Shutdown(context.Context) error {
+	if s.events != nil {
+		*s.events = append(*s.events, "server shutdown")
+	}
 	return s.shutdownErr
 }
 
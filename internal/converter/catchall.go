@@ -0,0 +1,114 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// catchAll redirects the envelope recipients to a catch-all address so
+// staging messages never reach real mailboxes
+type catchAll struct {
+	address        string
+	plusAddressing bool
+	domains        map[string]bool
+	patterns       []*regexp.Regexp
+}
+
+// newCatchAll returns a new catch-all filter for the given spec
+func newCatchAll(spec FilterSpec) (MessageFilter, error) {
+	if err := val.Var(spec.Address, "required,email"); err != nil {
+		return nil, errors.New("catch-all requires a valid address")
+	}
+
+	f := &catchAll{
+		address:        spec.Address,
+		plusAddressing: spec.PlusAddressing,
+		domains:        make(map[string]bool, len(spec.AllowDomains)),
+	}
+
+	for _, domain := range spec.AllowDomains {
+		f.domains[strings.ToLower(domain)] = true
+	}
+
+	for _, pattern := range spec.AllowPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.patterns = append(f.patterns, re)
+	}
+
+	return f, nil
+}
+
+// Filter rewrites the recipients which are not allowed and records the
+// original address of the To: and Cc: ones in X-Original-To: fields. The
+// original Bcc: addresses are only logged: the header is seen by all the
+// recipients.
+func (f *catchAll) Filter(ctx context.Context, m *Message) error {
+	var originals, bccOriginals []string
+
+	rewrite := func(rcpts []string, originals *[]string) []string {
+		rewritten := make([]string, 0, len(rcpts))
+		for _, rcpt := range rcpts {
+			if !f.allowed(rcpt) {
+				*originals = append(*originals, rcpt)
+				rcpt = f.redirect(rcpt)
+			}
+			rewritten = appendUnique(rewritten, rcpt)
+		}
+		return rewritten
+	}
+
+	m.to, m.cc, m.bcc = rewrite(m.to, &originals), rewrite(m.cc, &originals), rewrite(m.bcc, &bccOriginals)
+
+	if len(originals) == 0 && len(bccOriginals) == 0 {
+		return nil
+	}
+
+	for _, rcpt := range originals {
+		m.Header().Add("X-Original-To", rcpt)
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Strs("original_recipients", originals).
+		Strs("original_bcc_recipients", bccOriginals).
+		Str("catch_all", f.address).
+		Msg("recipients redirected to catch-all address")
+
+	return nil
+}
+
+// allowed returns true if the recipient domain or address is allowed
+func (f *catchAll) allowed(rcpt string) bool {
+	if f.domains[strings.ToLower(rcpt[strings.LastIndex(rcpt, "@")+1:])] {
+		return true
+	}
+
+	for _, re := range f.patterns {
+		if re.MatchString(rcpt) {
+			return true
+		}
+	}
+	return false
+}
+
+// redirect returns the catch-all address of the recipient, plus-addressed
+// when enabled: qa+bob=example.com@example.org for the catch-all address
+// qa@example.org and the recipient bob@example.com.
+func (f *catchAll) redirect(rcpt string) string {
+	if !f.plusAddressing {
+		return f.address
+	}
+
+	at := strings.LastIndex(f.address, "@")
+	if i := strings.LastIndex(rcpt, "@"); i >= 0 {
+		rcpt = rcpt[:i] + "=" + rcpt[i+1:]
+	}
+	return fmt.Sprintf("%s+%s%s", f.address[:at], rcpt, f.address[at:])
+}
@@ -0,0 +1,140 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func Test_catchAll_Filter(t *testing.T) {
+	tests := []struct {
+		name         string
+		spec         FilterSpec
+		to, cc, bcc  []string
+		wantTo       []string
+		wantCc       []string
+		wantBcc      []string
+		wantOriginal []string
+		// wantBccOriginal are the redirected Bcc recipients, only logged
+		wantBccOriginal []string
+	}{
+		{
+			name:            "all recipients are redirected",
+			spec:            FilterSpec{Type: FilterCatchAll, Address: "qa@example.org"},
+			to:              []string{"bob@example.com", "alice@example.com"},
+			cc:              []string{"carol@example.net"},
+			bcc:             []string{"dan@example.com"},
+			wantTo:          []string{"qa@example.org"},
+			wantCc:          []string{"qa@example.org"},
+			wantBcc:         []string{"qa@example.org"},
+			wantOriginal:    []string{"bob@example.com", "alice@example.com", "carol@example.net"},
+			wantBccOriginal: []string{"dan@example.com"},
+		},
+		{
+			name:         "plus addressing",
+			spec:         FilterSpec{Type: FilterCatchAll, Address: "qa@example.org", PlusAddressing: true},
+			to:           []string{"bob@example.com", "alice@example.com"},
+			cc:           []string{},
+			bcc:          []string{},
+			wantTo:       []string{"qa+bob=example.com@example.org", "qa+alice=example.com@example.org"},
+			wantCc:       []string{},
+			wantBcc:      []string{},
+			wantOriginal: []string{"bob@example.com", "alice@example.com"},
+		},
+		{
+			name: "allowed recipients are left intact",
+			spec: FilterSpec{
+				Type:          FilterCatchAll,
+				Address:       "qa@example.org",
+				AllowDomains:  []string{"Example.ORG"},
+				AllowPatterns: []string{`^qa\+.*@example\.com$`},
+			},
+			to:              []string{"bob@example.com", "team@example.org"},
+			cc:              []string{"qa+1@example.com"},
+			bcc:             []string{"eve@sub.example.org"},
+			wantTo:          []string{"qa@example.org", "team@example.org"},
+			wantCc:          []string{"qa+1@example.com"},
+			wantBcc:         []string{"qa@example.org"},
+			wantOriginal:    []string{"bob@example.com"},
+			wantBccOriginal: []string{"eve@sub.example.org"},
+		},
+		{
+			name:            "redirected Bcc recipients are not disclosed",
+			spec:            FilterSpec{Type: FilterCatchAll, Address: "qa@example.org", AllowDomains: []string{"example.org"}},
+			to:              []string{"team@example.org"},
+			cc:              []string{},
+			bcc:             []string{"dan@example.com"},
+			wantTo:          []string{"team@example.org"},
+			wantCc:          []string{},
+			wantBcc:         []string{"qa@example.org"},
+			wantBccOriginal: []string{"dan@example.com"},
+		},
+		{
+			name:    "no recipient to redirect",
+			spec:    FilterSpec{Type: FilterCatchAll, Address: "qa@example.org", AllowDomains: []string{"example.org"}},
+			to:      []string{"team@example.org"},
+			cc:      []string{},
+			bcc:     []string{},
+			wantTo:  []string{"team@example.org"},
+			wantCc:  []string{},
+			wantBcc: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFilter(tt.spec)
+			if err != nil {
+				t.Fatalf("NewFilter() error = %v", err)
+			}
+
+			m := NewMessage("from@example.com", tt.to, tt.cc, tt.bcc, strings.NewReader(""))
+
+			logs := &bytes.Buffer{}
+			ctx := zerolog.New(logs).With().Str("trace_id", "abc").Logger().WithContext(context.Background())
+
+			if err := f.Filter(ctx, m); err != nil {
+				t.Fatalf("Filter() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(m.To(), tt.wantTo) || !reflect.DeepEqual(m.Cc(), tt.wantCc) || !reflect.DeepEqual(m.Bcc(), tt.wantBcc) {
+				t.Errorf("Filter() recipients = %#v %#v %#v, want %#v %#v %#v", m.To(), m.Cc(), m.Bcc(), tt.wantTo, tt.wantCc, tt.wantBcc)
+			}
+			if got := m.Header().Values("X-Original-To"); !reflect.DeepEqual(got, tt.wantOriginal) {
+				t.Errorf("Filter() X-Original-To = %#v, want %#v", got, tt.wantOriginal)
+			}
+
+			if wantLog := len(tt.wantOriginal)+len(tt.wantBccOriginal) > 0; strings.Contains(logs.String(), `"trace_id":"abc"`) != wantLog {
+				t.Errorf("Filter() logs = %#v, want a log entry %v", logs.String(), wantLog)
+			}
+			for _, rcpt := range tt.wantBccOriginal {
+				if !strings.Contains(logs.String(), rcpt) {
+					t.Errorf("Filter() logs = %#v, want the Bcc recipient %s", logs.String(), rcpt)
+				}
+			}
+		})
+	}
+}
+
+func Test_newCatchAll(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    FilterSpec
+		wantErr bool
+	}{
+		{name: "valid filter", spec: FilterSpec{Address: "qa@example.org", AllowPatterns: []string{`@example\.org$`}}},
+		{name: "missing address", spec: FilterSpec{}, wantErr: true},
+		{name: "invalid address", spec: FilterSpec{Address: "qa"}, wantErr: true},
+		{name: "invalid pattern", spec: FilterSpec{Address: "qa@example.org", AllowPatterns: []string{`(`}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newCatchAll(tt.spec); (err != nil) != tt.wantErr {
+				t.Errorf("newCatchAll() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
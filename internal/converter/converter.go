@@ -1,11 +1,10 @@
 package converter
 
 import (
-	"bytes"
 	"fmt"
-	"io"
 	"net/http"
 	"sort"
+	"strings"
 	"sync"
 
 	validator "github.com/go-playground/validator/v10"
@@ -34,10 +33,20 @@ func (i ids) Less(a, b int) bool {
 	return i[a] < i[b]
 }
 
-// Converter converts an input to a ConvertedMessage
+// Route is a HTTP route a converter is exposed on
+type Route struct {
+	Path    string
+	Methods []string
+}
+
+// Converter converts an input to a ConvertedMessage. It also renders
+// the responses of its routes.
 type Converter interface {
 	ID() ID
+	Routes() []Route
 	Convert(r *http.Request) (*Message, error)
+	WriteResponse(w http.ResponseWriter, res *Result)
+	WriteError(w http.ResponseWriter, err error)
 }
 
 // Provider exposes the provider methods
@@ -92,14 +101,20 @@ func (p *provider) Get(cid ID) (Converter, error) {
 	return nil, fmt.Errorf("converter ID %v not found", cid)
 }
 
-// slurpBody idempotently copies a request's body
-func slurpBody(r *http.Request) (io.ReadSeeker, error) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		return nil, err
+// Enable returns a provider of the converters of the given IDs only, or of all
+// the converters when no ID is given. Every ID must be provided by p.
+func Enable(p Provider, ids []string) (Provider, error) {
+	if len(ids) == 0 {
+		return p, nil
 	}
-	defer r.Body.Close()
 
-	r.Body = io.NopCloser(bytes.NewBuffer(body))
-	return bytes.NewReader(body), nil
+	converters := make([]Converter, 0, len(ids))
+	for _, id := range ids {
+		c, err := p.Get(ID(strings.TrimSpace(id)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid enabled converter: %w", err)
+		}
+		converters = append(converters, c)
+	}
+	return NewProvider(converters...), nil
 }
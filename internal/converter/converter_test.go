@@ -2,22 +2,41 @@ package converter
 
 import (
 	"errors"
-	"io"
-	"net/http"
-	"net/http/httptest"
 	"reflect"
 	"strings"
 	"sync"
 	"testing"
 )
 
-// failingReader implements io.Reader and fails are reading
+// failingReader implements io.Reader and Raw and fails are reading
 type failingReader struct{}
 
 func (*failingReader) Read(p []byte) (n int, err error) {
 	return 0, errors.New("read error")
 }
 
+func (*failingReader) ReadAt(p []byte, off int64) (n int, err error) {
+	return 0, errors.New("read error")
+}
+
+func (*failingReader) Size() int64 {
+	return 1
+}
+
+// testSpooler is the spooler used by the converters under test
+var testSpooler = NewSpooler("", DefaultSpoolThreshold)
+
+// readRaw returns the given message raw content
+func readRaw(t *testing.T, m *Message) string {
+	t.Helper()
+
+	buf := &strings.Builder{}
+	if _, err := m.WriteTo(buf); err != nil {
+		t.Fatalf("message raw read failed: %v", err)
+	}
+	return buf.String()
+}
+
 func TestNewProvider(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -160,64 +179,46 @@ func Test_provider_Get(t *testing.T) {
 	}
 }
 
-func Test_slurpBody(t *testing.T) {
+func TestEnable(t *testing.T) {
+	p := NewProvider(&Stub{}, &rfc5322{})
+
 	tests := []struct {
 		name    string
-		req     *http.Request
-		want    string
+		ids     []string
+		want    []ID
 		wantErr bool
 	}{
 		{
-			name:    "no body",
-			req:     httptest.NewRequest("", "/", nil),
-			want:    "",
-			wantErr: false,
+			name: "all converters are enabled by default",
+			want: []ID{RFC5322ID, StubConverterID},
 		},
 		{
-			name:    "test body",
-			req:     httptest.NewRequest("", "/", strings.NewReader("test body")),
-			want:    "test body",
-			wantErr: false,
+			name: "listed converters are enabled",
+			ids:  []string{string(StubConverterID)},
+			want: []ID{StubConverterID},
 		},
 		{
-			name:    "body read fails",
-			req:     httptest.NewRequest("", "/", &failingReader{}),
-			want:    "",
+			name: "IDs are trimmed",
+			ids:  []string{string(StubConverterID), " " + string(RFC5322ID)},
+			want: []ID{RFC5322ID, StubConverterID},
+		},
+		{
+			name:    "unknown converter",
+			ids:     []string{string(StubConverterID), "ghost"},
 			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := slurpBody(tt.req)
+			got, err := Enable(p, tt.ids)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("slurpBody() error = %v, wantErr %v", err, tt.wantErr)
-				return
+				t.Fatalf("Enable() error = %v, wantErr %v", err, tt.wantErr)
 			}
-
 			if tt.wantErr {
 				return
 			}
-
-			gotAsBytes, err := io.ReadAll(got)
-			if err != nil {
-				t.Fatalf("got read failed: %v", err)
-			}
-
-			gotAsStr := string(gotAsBytes)
-
-			if gotAsStr != tt.want {
-				t.Errorf("slurpBody() = %#v, want %#v", gotAsStr, tt.want)
-			}
-
-			// Ensures the request body is still there after the slurp
-			body, err := io.ReadAll(tt.req.Body)
-			if err != nil {
-				t.Fatalf("request body read failed: %v", err)
-			}
-
-			bodyAsStr := string(body)
-			if bodyAsStr != tt.want {
-				t.Errorf("request body = %#v, want %#v", bodyAsStr, tt.want)
+			if ids := got.IDs(); !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("Enable().IDs() = %#v, want %#v", ids, tt.want)
 			}
 		})
 	}
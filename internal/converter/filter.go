@@ -0,0 +1,262 @@
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ErrDropped is returned by the filters dropping a message: it is not
+// sent but its transmission is not an error either
+var ErrDropped = errors.New("message dropped")
+
+// MessageFilter transforms or rejects the converted messages before they are sent
+type MessageFilter interface {
+	Filter(ctx context.Context, m *Message) error
+}
+
+// MessageFilterFunc is a function implementing MessageFilter
+type MessageFilterFunc func(ctx context.Context, m *Message) error
+
+// Filter calls f(ctx, m)
+func (f MessageFilterFunc) Filter(ctx context.Context, m *Message) error {
+	return f(ctx, m)
+}
+
+// FilterChain is an ordered list of filters. It stops at the first error.
+type FilterChain []MessageFilter
+
+// Filter applies each filter of the chain to the message
+func (c FilterChain) Filter(ctx context.Context, m *Message) error {
+	for _, f := range c {
+		if err := f.Filter(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Filter types
+const (
+	FilterHeaderAdd        = "header_add"
+	FilterHeaderRemove     = "header_remove"
+	FilterSubjectPrefix    = "subject_prefix"
+	FilterRecipientRewrite = "recipient_rewrite"
+	FilterSizeCheck        = "size_check"
+	FilterDrop             = "drop"
+	FilterCatchAll         = "catch_all"
+	FilterSenderDomains    = "sender_domains"
+)
+
+// FilterConfig is the format of the filters config file
+type FilterConfig struct {
+	Filters []FilterSpec `json:"filters" validate:"dive"`
+}
+
+// FilterSpec defines a filter: its type and its parameters
+type FilterSpec struct {
+	Type string `json:"type" validate:"required,oneof=header_add header_remove subject_prefix recipient_rewrite size_check drop catch_all sender_domains"`
+	// Name is the header field name of the header_add and header_remove filters
+	Name string `json:"name"`
+	// Value is the header field value of the header_add filter and the prefix of the
+	// subject_prefix filter
+	Value string `json:"value"`
+	// Pattern is the regular expression matching the recipients of the
+	// recipient_rewrite and drop filters
+	Pattern string `json:"pattern"`
+	// Replacement is the template the recipients are rewritten with by the
+	// recipient_rewrite filter: $1 is replaced by the first pattern submatch.
+	Replacement string `json:"replacement"`
+	// MaxSize is the max message size in bytes of the size_check filter
+	MaxSize int64 `json:"max_size" validate:"gte=0"`
+	// Address is the address the recipients are redirected to by the catch_all filter
+	Address string `json:"address"`
+	// PlusAddressing makes the catch_all filter encode each recipient into the
+	// catch-all address (e.g. qa+bob=example.com@example.org)
+	PlusAddressing bool `json:"plus_addressing"`
+	// AllowDomains lists the recipient domains the catch_all filter leaves intact
+	// and the sending domains of the sender_domains filter
+	AllowDomains []string `json:"allow_domains"`
+	// AllowPatterns lists the regular expressions matching the recipients the
+	// catch_all filter leaves intact
+	AllowPatterns []string `json:"allow_patterns"`
+}
+
+// LoadFilters reads the given config file and returns its filter chain
+func LoadFilters(filename string) (FilterChain, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config := &FilterConfig{}
+	if err := json.NewDecoder(f).Decode(config); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", filename, err)
+	}
+
+	if err := val.Struct(config); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", filename, err)
+	}
+
+	chain := make(FilterChain, 0, len(config.Filters))
+	for i, spec := range config.Filters {
+		filter, err := NewFilter(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config %s: filter %d: %w", filename, i, err)
+		}
+		chain = append(chain, filter)
+	}
+	return chain, nil
+}
+
+// NewFilter returns a new filter for the given spec
+func NewFilter(spec FilterSpec) (MessageFilter, error) {
+	if err := val.Struct(spec); err != nil {
+		return nil, err
+	}
+
+	switch spec.Type {
+	case FilterHeaderAdd:
+		if !validHeaderName(spec.Name) || strings.ContainsAny(spec.Value, "\r\n") {
+			return nil, fmt.Errorf("header %#v is malformed", spec.Name)
+		}
+		return headerAdd(spec.Name, mime.QEncoding.Encode("utf-8", spec.Value)), nil
+	case FilterHeaderRemove:
+		if !validHeaderName(spec.Name) {
+			return nil, fmt.Errorf("header %#v is malformed", spec.Name)
+		}
+		return headerRemove(spec.Name), nil
+	case FilterSubjectPrefix:
+		if spec.Value == "" || strings.ContainsAny(spec.Value, "\r\n") {
+			return nil, errors.New("subject prefix is empty or malformed")
+		}
+		return subjectPrefix(spec.Value), nil
+	case FilterRecipientRewrite:
+		re, err := regexp.Compile(spec.Pattern)
+		if err != nil || spec.Pattern == "" || spec.Replacement == "" {
+			return nil, fmt.Errorf("recipient rewrite requires a valid pattern and a replacement: %v", err)
+		}
+		return recipientRewrite(re, spec.Replacement), nil
+	case FilterSizeCheck:
+		if spec.MaxSize == 0 {
+			return nil, errors.New("size check requires a max size")
+		}
+		return sizeCheck(spec.MaxSize), nil
+	case FilterDrop:
+		re, err := regexp.Compile(spec.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		return drop(re), nil
+	case FilterCatchAll:
+		return newCatchAll(spec)
+	case FilterSenderDomains:
+		if len(spec.AllowDomains) == 0 {
+			return nil, errors.New("sender domains requires a list of domains")
+		}
+		return senderDomains(spec.AllowDomains), nil
+	}
+	return nil, fmt.Errorf("unknown filter type %s", spec.Type)
+}
+
+// headerAdd appends the given field to the message header
+func headerAdd(key, value string) MessageFilter {
+	return MessageFilterFunc(func(_ context.Context, m *Message) error {
+		m.Header().Add(key, value)
+		return nil
+	})
+}
+
+// headerRemove removes the given fields from the message header
+func headerRemove(key string) MessageFilter {
+	return MessageFilterFunc(func(_ context.Context, m *Message) error {
+		m.Header().Del(key)
+		return nil
+	})
+}
+
+// subjectPrefix prefixes the message subject, unless it already is
+func subjectPrefix(prefix string) MessageFilter {
+	encoded := mime.QEncoding.Encode("utf-8", prefix)
+
+	return MessageFilterFunc(func(_ context.Context, m *Message) error {
+		subject := m.Header().Get("Subject")
+		if strings.HasPrefix(subject, encoded) {
+			return nil
+		}
+
+		// An encoded prefix must be separated from the text that follows
+		if encoded != prefix && subject != "" {
+			subject = " " + subject
+		}
+		m.Header().Set("Subject", encoded+subject)
+		return nil
+	})
+}
+
+// recipientRewrite rewrites the envelope recipients matching the given
+// pattern. The message header is left intact.
+func recipientRewrite(re *regexp.Regexp, replacement string) MessageFilter {
+	rewrite := func(rcpts []string) []string {
+		rewritten := make([]string, 0, len(rcpts))
+		for _, rcpt := range rcpts {
+			if re.MatchString(rcpt) {
+				rcpt = re.ReplaceAllString(rcpt, replacement)
+			}
+			rewritten = appendUnique(rewritten, rcpt)
+		}
+		return rewritten
+	}
+
+	return MessageFilterFunc(func(_ context.Context, m *Message) error {
+		m.to, m.cc, m.bcc = rewrite(m.to), rewrite(m.cc), rewrite(m.bcc)
+		return nil
+	})
+}
+
+// sizeCheck rejects the messages bigger than the given size
+func sizeCheck(maxSize int64) MessageFilter {
+	return MessageFilterFunc(func(_ context.Context, m *Message) error {
+		if size := m.Size(); size > maxSize {
+			return limitError(KindTooLarge, "message size", maxSize, size)
+		}
+		return nil
+	})
+}
+
+// drop removes the envelope recipients matching the given pattern. The
+// message is dropped once it has no recipient left.
+func drop(re *regexp.Regexp) MessageFilter {
+	remove := func(rcpts []string) []string {
+		kept := make([]string, 0, len(rcpts))
+		for _, rcpt := range rcpts {
+			if !re.MatchString(rcpt) {
+				kept = append(kept, rcpt)
+			}
+		}
+		return kept
+	}
+
+	return MessageFilterFunc(func(_ context.Context, m *Message) error {
+		m.to, m.cc, m.bcc = remove(m.to), remove(m.cc), remove(m.bcc)
+		if !m.HasRecipients() {
+			return ErrDropped
+		}
+		return nil
+	})
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,270 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantLen int
+		wantErr bool
+	}{
+		{
+			name:    "invalid JSON",
+			config:  `[`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown filter type",
+			config:  `{"filters":[{"type":"ghost"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid filter",
+			config:  `{"filters":[{"type":"header_add","name":"X Env"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "no filter",
+			config:  `{"filters":[]}`,
+			wantLen: 0,
+		},
+		{
+			name:    "several filters",
+			config:  `{"filters":[{"type":"header_remove","name":"X-Mailer"},{"type":"subject_prefix","value":"[STAGING] "}]}`,
+			wantLen: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "filters.json")
+			if err := os.WriteFile(filename, []byte(tt.config), 0o600); err != nil {
+				t.Fatalf("could not write config file: %v", err)
+			}
+
+			got, err := LoadFilters(filename)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadFilters() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && len(got) != tt.wantLen {
+				t.Errorf("LoadFilters() len = %v, want %v", len(got), tt.wantLen)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := LoadFilters(filepath.Join(t.TempDir(), "ghost.json")); err == nil {
+			t.Error("LoadFilters() error = nil, want an error")
+		}
+	})
+}
+
+func TestNewFilter(t *testing.T) {
+	const raw = "From: from@example.com\r\nTo: to@example.com\r\nSubject: Hello\r\nX-Mailer: acme\r\n\r\nHello world!\r\n"
+
+	tests := []struct {
+		name        string
+		spec        FilterSpec
+		wantHeader  string
+		wantTo      []string
+		wantBcc     []string
+		wantKind    ErrorKind
+		wantErr     error
+		wantInvalid bool
+	}{
+		{
+			name:       "header add",
+			spec:       FilterSpec{Type: FilterHeaderAdd, Name: "X-Env", Value: "staging"},
+			wantHeader: "From: from@example.com\r\nTo: to@example.com\r\nSubject: Hello\r\nX-Mailer: acme\r\nX-Env: staging\r\n",
+			wantTo:     []string{"to@example.com"},
+			wantBcc:    []string{"bcc@example.com"},
+		},
+		{
+			name:       "header add with non-ASCII value",
+			spec:       FilterSpec{Type: FilterHeaderAdd, Name: "X-Env", Value: "préprod"},
+			wantHeader: "From: from@example.com\r\nTo: to@example.com\r\nSubject: Hello\r\nX-Mailer: acme\r\nX-Env: =?utf-8?q?pr=C3=A9prod?=\r\n",
+			wantTo:     []string{"to@example.com"},
+			wantBcc:    []string{"bcc@example.com"},
+		},
+		{
+			name:        "header add with malformed name",
+			spec:        FilterSpec{Type: FilterHeaderAdd, Name: "X: Env", Value: "staging"},
+			wantInvalid: true,
+		},
+		{
+			name:        "header add with malformed value",
+			spec:        FilterSpec{Type: FilterHeaderAdd, Name: "X-Env", Value: "staging\r\nBcc: eve@example.com"},
+			wantInvalid: true,
+		},
+		{
+			name:       "header remove",
+			spec:       FilterSpec{Type: FilterHeaderRemove, Name: "x-mailer"},
+			wantHeader: "From: from@example.com\r\nTo: to@example.com\r\nSubject: Hello\r\n",
+			wantTo:     []string{"to@example.com"},
+			wantBcc:    []string{"bcc@example.com"},
+		},
+		{
+			name:        "header remove without name",
+			spec:        FilterSpec{Type: FilterHeaderRemove},
+			wantInvalid: true,
+		},
+		{
+			name:       "subject prefix",
+			spec:       FilterSpec{Type: FilterSubjectPrefix, Value: "[STAGING] "},
+			wantHeader: "From: from@example.com\r\nTo: to@example.com\r\nSubject: [STAGING] Hello\r\nX-Mailer: acme\r\n",
+			wantTo:     []string{"to@example.com"},
+			wantBcc:    []string{"bcc@example.com"},
+		},
+		{
+			name:       "non-ASCII subject prefix",
+			spec:       FilterSpec{Type: FilterSubjectPrefix, Value: "[RECETTE] é"},
+			wantHeader: "From: from@example.com\r\nTo: to@example.com\r\nSubject: =?utf-8?q?[RECETTE]_=C3=A9?= Hello\r\nX-Mailer: acme\r\n",
+			wantTo:     []string{"to@example.com"},
+			wantBcc:    []string{"bcc@example.com"},
+		},
+		{
+			name:        "empty subject prefix",
+			spec:        FilterSpec{Type: FilterSubjectPrefix},
+			wantInvalid: true,
+		},
+		{
+			name:       "recipient rewrite",
+			spec:       FilterSpec{Type: FilterRecipientRewrite, Pattern: `^(.+)@example\.com$`, Replacement: "$1@example.test"},
+			wantHeader: raw[:strings.Index(raw, "\r\n\r\n")+2],
+			wantTo:     []string{"to@example.test"},
+			wantBcc:    []string{"bcc@example.test"},
+		},
+		{
+			name:       "recipient rewrite to a single address",
+			spec:       FilterSpec{Type: FilterRecipientRewrite, Pattern: `.*`, Replacement: "catchall@example.test"},
+			wantHeader: raw[:strings.Index(raw, "\r\n\r\n")+2],
+			wantTo:     []string{"catchall@example.test"},
+			wantBcc:    []string{"catchall@example.test"},
+		},
+		{
+			name:        "recipient rewrite with invalid pattern",
+			spec:        FilterSpec{Type: FilterRecipientRewrite, Pattern: `(`, Replacement: "catchall@example.test"},
+			wantInvalid: true,
+		},
+		{
+			name:        "recipient rewrite without replacement",
+			spec:        FilterSpec{Type: FilterRecipientRewrite, Pattern: `.*`},
+			wantInvalid: true,
+		},
+		{
+			name:       "size check",
+			spec:       FilterSpec{Type: FilterSizeCheck, MaxSize: int64(len(raw))},
+			wantHeader: raw[:strings.Index(raw, "\r\n\r\n")+2],
+			wantTo:     []string{"to@example.com"},
+			wantBcc:    []string{"bcc@example.com"},
+		},
+		{
+			name:     "size check of a too large message",
+			spec:     FilterSpec{Type: FilterSizeCheck, MaxSize: int64(len(raw)) - 1},
+			wantKind: KindTooLarge,
+		},
+		{
+			name:        "size check without max size",
+			spec:        FilterSpec{Type: FilterSizeCheck},
+			wantInvalid: true,
+		},
+		{
+			name:       "drop recipients",
+			spec:       FilterSpec{Type: FilterDrop, Pattern: `^bcc@`},
+			wantHeader: raw[:strings.Index(raw, "\r\n\r\n")+2],
+			wantTo:     []string{"to@example.com"},
+			wantBcc:    []string{},
+		},
+		{
+			name:    "drop message",
+			spec:    FilterSpec{Type: FilterDrop},
+			wantErr: ErrDropped,
+		},
+		{
+			name:        "drop with invalid pattern",
+			spec:        FilterSpec{Type: FilterDrop, Pattern: `(`},
+			wantInvalid: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFilter(tt.spec)
+			if (err != nil) != tt.wantInvalid {
+				t.Fatalf("NewFilter() error = %v, wantInvalid %v", err, tt.wantInvalid)
+			}
+			if tt.wantInvalid {
+				return
+			}
+
+			m, err := ParseMessage(strings.NewReader(raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.to, m.bcc = []string{"to@example.com"}, []string{"bcc@example.com"}
+
+			err = f.Filter(context.Background(), m)
+			if tt.wantErr != nil || tt.wantKind != KindInternal {
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Errorf("Filter() error = %v, want %v", err, tt.wantErr)
+				}
+				if tt.wantKind != KindInternal && KindOf(err) != tt.wantKind {
+					t.Errorf("Filter() error kind = %v, want %v", KindOf(err), tt.wantKind)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Filter() error = %v", err)
+			}
+
+			header := &strings.Builder{}
+			(m.Header().WriteTo(header))
+			if header.String() != tt.wantHeader {
+				t.Errorf("Filter() header = %#v, want %#v", header.String(), tt.wantHeader)
+			}
+			if !reflect.DeepEqual(m.To(), tt.wantTo) || !reflect.DeepEqual(m.Bcc(), tt.wantBcc) {
+				t.Errorf("Filter() recipients = %#v %#v, want %#v %#v", m.To(), m.Bcc(), tt.wantTo, tt.wantBcc)
+			}
+		})
+	}
+}
+
+func TestFilterChain_Filter(t *testing.T) {
+	var calls []string
+	filter := func(name string, err error) MessageFilter {
+		return MessageFilterFunc(func(context.Context, *Message) error {
+			calls = append(calls, name)
+			return err
+		})
+	}
+
+	chain := FilterChain{filter("a", nil), filter("b", ErrDropped), filter("c", nil)}
+	if err := chain.Filter(context.Background(), &Message{}); !errors.Is(err, ErrDropped) {
+		t.Errorf("FilterChain.Filter() error = %v, want %v", err, ErrDropped)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("FilterChain.Filter() calls = %#v, want %#v", calls, want)
+	}
+
+	t.Run("subject prefix is applied once", func(t *testing.T) {
+		m := NewMessage("from@example.com", nil, nil, nil, strings.NewReader(""))
+		m.Header().Set("Subject", "Hello")
+
+		f := subjectPrefix("[STAGING] ")
+		chain := FilterChain{f, f}
+		if err := chain.Filter(context.Background(), m); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := m.Header().Get("Subject"), "[STAGING] Hello"; got != want {
+			t.Errorf("FilterChain.Filter() subject = %#v, want %#v", got, want)
+		}
+	})
+}
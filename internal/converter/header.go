@@ -0,0 +1,201 @@
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/textproto"
+	"strings"
+)
+
+// HeaderField is a message header field
+type HeaderField struct {
+	// Key is the canonical field name
+	Key string
+	// Value is the unfolded field value
+	Value string
+	// raw is the field as it was read, empty for added fields
+	raw string
+}
+
+// Header is the ordered list of a message header fields. Fields that are
+// not modified are written as they were read.
+type Header struct {
+	fields []HeaderField
+}
+
+// parseHeader reads the header section of a message and returns the header
+// and the raw section separator (the empty line), if any
+func parseHeader(r *bufio.Reader) (*Header, string, error) {
+	h := &Header{}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, "", err
+		}
+
+		if line == "" {
+			return h, "", nil
+		}
+
+		if line == "\r\n" || line == "\n" {
+			return h, line, nil
+		}
+
+		// A line starting with a white space is the continuation of a folded field
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(h.fields) == 0 {
+				return nil, "", errors.New("malformed header: unexpected continuation line")
+			}
+
+			f := &h.fields[len(h.fields)-1]
+			f.raw += line
+			f.Value += strings.TrimRight(line, "\r\n")
+		} else {
+			i := strings.IndexByte(line, ':')
+			if i < 1 {
+				return nil, "", errors.New("malformed header: missing colon")
+			}
+
+			h.fields = append(h.fields, HeaderField{
+				Key:   textproto.CanonicalMIMEHeaderKey(strings.TrimRight(line[:i], " \t")),
+				Value: strings.TrimLeft(strings.TrimRight(line[i+1:], "\r\n"), " \t"),
+				raw:   line,
+			})
+		}
+
+		if errors.Is(err, io.EOF) {
+			return h, "", nil
+		}
+	}
+}
+
+// Get returns the trimmed value of the first field with the given name,
+// or an empty string if there is none
+func (h *Header) Get(key string) string {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	for _, f := range h.fields {
+		if f.Key == key {
+			return strings.TrimSpace(f.Value)
+		}
+	}
+	return ""
+}
+
+// Values returns the trimmed values of all the fields with the given name
+func (h *Header) Values(key string) []string {
+	var values []string
+
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	for _, f := range h.fields {
+		if f.Key == key {
+			values = append(values, strings.TrimSpace(f.Value))
+		}
+	}
+	return values
+}
+
+// Has returns true if the header has at least one field with the given name
+func (h *Header) Has(key string) bool {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	for _, f := range h.fields {
+		if f.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Add appends a field to the header
+func (h *Header) Add(key, value string) {
+	h.fields = append(h.fields, HeaderField{
+		Key:   textproto.CanonicalMIMEHeaderKey(key),
+		Value: value,
+	})
+}
+
+// Prepend inserts a field before the other ones. Trace fields such as
+// Received: are prepended.
+func (h *Header) Prepend(key, value string) {
+	h.fields = append([]HeaderField{{
+		Key:   textproto.CanonicalMIMEHeaderKey(key),
+		Value: value,
+	}}, h.fields...)
+}
+
+// Set replaces the first field with the given name and removes the other
+// ones. The field is appended if there is none.
+func (h *Header) Set(key, value string) {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+
+	for i, f := range h.fields {
+		if f.Key == key {
+			h.fields[i] = HeaderField{Key: key, Value: value}
+			h.fields = append(h.fields[:i+1], removeFields(h.fields[i+1:], key)...)
+			return
+		}
+	}
+	h.Add(key, value)
+}
+
+// Del removes all the fields with the given name
+func (h *Header) Del(key string) {
+	h.fields = removeFields(h.fields, textproto.CanonicalMIMEHeaderKey(key))
+}
+
+// Fields returns a copy of the header fields
+func (h *Header) Fields() []HeaderField {
+	return append([]HeaderField(nil), h.fields...)
+}
+
+// Map returns a copy of the header whose field values are replaced by the
+// ones returned by fn. The unchanged fields are written as they were read.
+func (h *Header) Map(fn func(key, value string) (string, error)) (*Header, error) {
+	mapped := &Header{fields: h.Fields()}
+	for i, f := range mapped.fields {
+		value, err := fn(f.Key, f.Value)
+		if err != nil {
+			return nil, err
+		}
+		if value != f.Value {
+			mapped.fields[i] = HeaderField{Key: f.Key, Value: value}
+		}
+	}
+	return mapped, nil
+}
+
+// Len returns the number of fields
+func (h *Header) Len() int {
+	return len(h.fields)
+}
+
+// WriteTo writes the header fields
+func (h *Header) WriteTo(w io.Writer) (int64, error) {
+	buf := &bytes.Buffer{}
+
+	for _, f := range h.fields {
+		if f.raw != "" {
+			buf.WriteString(f.raw)
+			// The last field of a message without body may miss its line break
+			if !strings.HasSuffix(f.raw, "\n") {
+				buf.WriteString("\r\n")
+			}
+			continue
+		}
+		buf.WriteString(f.Key + ": " + f.Value + "\r\n")
+	}
+
+	return buf.WriteTo(w)
+}
+
+func removeFields(fields []HeaderField, key string) []HeaderField {
+	kept := fields[:0]
+	for _, f := range fields {
+		if f.Key != key {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,183 @@
+package converter
+
+import (
+	"bufio"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseHeader(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		wantFields    []HeaderField
+		wantSeparator string
+		wantErr       bool
+	}{
+		{
+			name:  "empty input",
+			input: "",
+		},
+		{
+			name:          "empty header",
+			input:         "\r\nbody",
+			wantSeparator: "\r\n",
+		},
+		{
+			name:  "CRLF fields",
+			input: "From: Test <test@example.com>\r\nsubject:Hello\r\n\r\nbody",
+			wantFields: []HeaderField{
+				{Key: "From", Value: "Test <test@example.com>", raw: "From: Test <test@example.com>\r\n"},
+				{Key: "Subject", Value: "Hello", raw: "subject:Hello\r\n"},
+			},
+			wantSeparator: "\r\n",
+		},
+		{
+			name:  "LF fields",
+			input: "From: test@example.com\n\nbody",
+			wantFields: []HeaderField{
+				{Key: "From", Value: "test@example.com", raw: "From: test@example.com\n"},
+			},
+			wantSeparator: "\n",
+		},
+		{
+			name:  "folded field",
+			input: "To: bob@example.com,\r\n\talice@example.com\r\n\r\n",
+			wantFields: []HeaderField{
+				{Key: "To", Value: "bob@example.com,\talice@example.com", raw: "To: bob@example.com,\r\n\talice@example.com\r\n"},
+			},
+			wantSeparator: "\r\n",
+		},
+		{
+			name:  "no body and no final line break",
+			input: "From: test@example.com",
+			wantFields: []HeaderField{
+				{Key: "From", Value: "test@example.com", raw: "From: test@example.com"},
+			},
+		},
+		{
+			name:    "continuation line first",
+			input:   " From: test@example.com\r\n\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing colon",
+			input:   "From test@example.com\r\n\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "read error",
+			input:   "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tt.input))
+			if tt.name == "read error" {
+				r = bufio.NewReader(&failingReader{})
+			}
+
+			got, sep, err := parseHeader(r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseHeader() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got.fields, tt.wantFields) {
+				t.Errorf("parseHeader() = %#v, want %#v", got.fields, tt.wantFields)
+			}
+			if sep != tt.wantSeparator {
+				t.Errorf("parseHeader() separator = %#v, want %#v", sep, tt.wantSeparator)
+			}
+		})
+	}
+}
+
+func TestHeader(t *testing.T) {
+	h, _, err := parseHeader(bufio.NewReader(strings.NewReader(
+		"Received: from a\r\nTo: bob@example.com\r\nReceived: from b\r\nsubject: Hello\r\n\r\n",
+	)))
+	if err != nil {
+		t.Fatalf("parseHeader() error = %v", err)
+	}
+
+	if got := h.Get("received"); got != "from a" {
+		t.Errorf("Get() = %#v, want %#v", got, "from a")
+	}
+	if got := h.Get("Ghost"); got != "" {
+		t.Errorf("Get() = %#v, want empty", got)
+	}
+	if got := h.Values("Received"); !reflect.DeepEqual(got, []string{"from a", "from b"}) {
+		t.Errorf("Values() = %#v", got)
+	}
+	if !h.Has("SUBJECT") || h.Has("Ghost") {
+		t.Error("Has() returned unexpected results")
+	}
+	if h.Len() != 4 {
+		t.Errorf("Len() = %v, want 4", h.Len())
+	}
+
+	h.Set("Received", "from c")
+	h.Set("X-Mailer", "test")
+	h.Add("x-foo", "bar")
+	h.Prepend("received", "from d")
+	h.Del("To")
+
+	want := "Received: from d\r\nReceived: from c\r\nsubject: Hello\r\nX-Mailer: test\r\nX-Foo: bar\r\n"
+
+	buf := &strings.Builder{}
+	n, err := h.WriteTo(buf)
+	if err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	if buf.String() != want || n != int64(len(want)) {
+		t.Errorf("WriteTo() = %#v (%d), want %#v", buf.String(), n, want)
+	}
+
+	fields := h.Fields()
+	fields[0].Value = "modified"
+	if h.Get("Received") != "from d" {
+		t.Error("Fields() must return a copy")
+	}
+}
+
+func TestHeader_Map(t *testing.T) {
+	h, _, err := parseHeader(bufio.NewReader(strings.NewReader(
+		"Subject: Hello\r\n\tworld\r\nTo: bob@example.com\r\n\r\n",
+	)))
+	if err != nil {
+		t.Fatalf("parseHeader() error = %v", err)
+	}
+
+	mapped, err := h.Map(func(key, value string) (string, error) {
+		if key == "To" {
+			return "alice@example.com", nil
+		}
+		return value, nil
+	})
+	if err != nil {
+		t.Fatalf("Map() error = %v", err)
+	}
+
+	// The unchanged fields are kept as they were read
+	want := "Subject: Hello\r\n\tworld\r\nTo: alice@example.com\r\n"
+	buf := &strings.Builder{}
+	if _, err := mapped.WriteTo(buf); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	if buf.String() != want {
+		t.Errorf("Map() = %#v, want %#v", buf.String(), want)
+	}
+	if h.Get("To") != "bob@example.com" {
+		t.Error("Map() must return a copy")
+	}
+
+	if _, err := h.Map(func(string, string) (string, error) { return "", errors.New("invalid") }); err == nil {
+		t.Error("Map() error = nil, want an error")
+	}
+}
@@ -0,0 +1,431 @@
+package converter
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strings"
+)
+
+// JSONMappingConfig is the format of the JSON mapping converters config file
+type JSONMappingConfig struct {
+	Converters []JSONMapping `json:"converters" validate:"dive"`
+}
+
+// defaultMaxPayloadSize is the max request payload size in bytes of the JSON
+// mapping converters not setting one
+const defaultMaxPayloadSize = 10 << 20
+
+// JSONMapping defines a JSON mapping converter: its ID, the route path it is
+// exposed on and how the email fields are read from the request payload.
+// The payload is decoded in memory to evaluate the JSONPath expressions, so
+// its size is bounded by MaxPayloadSize (default: 10 MiB).
+type JSONMapping struct {
+	ID             ID         `json:"id" validate:"required"`
+	Path           string     `json:"path" validate:"required,startswith=/"`
+	MaxPayloadSize int64      `json:"max_payload_size" validate:"gte=0"`
+	Fields         JSONFields `json:"mapping"`
+}
+
+// JSONFields holds the JSONPath expressions of each email field. Expressions
+// are evaluated against the request payload.
+type JSONFields struct {
+	From        string                `json:"from" validate:"required"`
+	ReturnPath  string                `json:"return_path"`
+	To          string                `json:"to"`
+	Cc          string                `json:"cc"`
+	Bcc         string                `json:"bcc"`
+	Subject     string                `json:"subject"`
+	Text        string                `json:"text"`
+	HTML        string                `json:"html"`
+	Headers     string                `json:"headers"`
+	Attachments *JSONAttachmentFields `json:"attachments"`
+}
+
+// JSONAttachmentFields holds the JSONPath expressions of the attachments. Path
+// selects the attachment items in the payload, the other expressions are
+// evaluated against each item. Content must be base64 encoded.
+type JSONAttachmentFields struct {
+	Path        string `json:"path" validate:"required"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Content     string `json:"content" validate:"required"`
+}
+
+type jsonMapping struct {
+	jsonResponder
+	spooler                    *Spooler
+	id                         ID
+	path                       string
+	maxPayloadSize             int64
+	from, to, cc, bcc, subject *jsonPath
+	returnPath                 *jsonPath
+	text, html, headers        *jsonPath
+	attachments                *attachmentPaths
+}
+
+type attachmentPaths struct {
+	path, filename, contentType, content *jsonPath
+}
+
+// LoadJSONMappings reads the given config file and returns its JSON mapping converters
+func LoadJSONMappings(filename string, spooler *Spooler) ([]Converter, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config := &JSONMappingConfig{}
+	if err := json.NewDecoder(f).Decode(config); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", filename, err)
+	}
+
+	if err := val.Struct(config); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", filename, err)
+	}
+
+	converters := make([]Converter, 0, len(config.Converters))
+	seen := make(map[string]bool, 2*len(config.Converters))
+
+	// The builtin converters can't be overridden nor shadowed
+	builtins := make(map[string]bool)
+	for _, c := range []Converter{NewRFC5322(spooler), NewSparkPost(spooler)} {
+		builtins["id "+string(c.ID())] = true
+		for _, route := range c.Routes() {
+			builtins["path "+route.Path] = true
+		}
+	}
+
+	for _, m := range config.Converters {
+		for _, key := range []string{"id " + string(m.ID), "path " + m.Path} {
+			if builtins[key] {
+				return nil, fmt.Errorf("invalid config %s: %s is reserved by a builtin converter", filename, key)
+			}
+			if seen[key] {
+				return nil, fmt.Errorf("invalid config %s: duplicate %s", filename, key)
+			}
+			seen[key] = true
+		}
+
+		c, err := NewJSONMapping(m, spooler)
+		if err != nil {
+			return nil, err
+		}
+		converters = append(converters, c)
+	}
+	return converters, nil
+}
+
+// NewJSONMapping returns a new converter for the given JSON mapping
+func NewJSONMapping(m JSONMapping, spooler *Spooler) (Converter, error) {
+	if err := val.Struct(m); err != nil {
+		return nil, err
+	}
+
+	c := &jsonMapping{id: m.ID, path: m.Path, maxPayloadSize: m.MaxPayloadSize, spooler: spooler}
+	if c.maxPayloadSize == 0 {
+		c.maxPayloadSize = defaultMaxPayloadSize
+	}
+
+	fields := []struct {
+		dst  **jsonPath
+		expr string
+	}{
+		{&c.from, m.Fields.From},
+		{&c.returnPath, m.Fields.ReturnPath},
+		{&c.to, m.Fields.To},
+		{&c.cc, m.Fields.Cc},
+		{&c.bcc, m.Fields.Bcc},
+		{&c.subject, m.Fields.Subject},
+		{&c.text, m.Fields.Text},
+		{&c.html, m.Fields.HTML},
+		{&c.headers, m.Fields.Headers},
+	}
+
+	if a := m.Fields.Attachments; a != nil {
+		c.attachments = &attachmentPaths{}
+		fields = append(fields, []struct {
+			dst  **jsonPath
+			expr string
+		}{
+			{&c.attachments.path, a.Path},
+			{&c.attachments.filename, a.Filename},
+			{&c.attachments.contentType, a.ContentType},
+			{&c.attachments.content, a.Content},
+		}...)
+	}
+
+	for _, f := range fields {
+		if f.expr == "" {
+			continue
+		}
+
+		p, err := compileJSONPath(f.expr)
+		if err != nil {
+			return nil, fmt.Errorf("converter %s: %w", m.ID, err)
+		}
+		*f.dst = p
+	}
+
+	return c, nil
+}
+
+func (c *jsonMapping) ID() ID {
+	return c.id
+}
+
+func (c *jsonMapping) Routes() []Route {
+	return []Route{{Path: c.path, Methods: []string{http.MethodPost}}}
+}
+
+func (c *jsonMapping) Convert(r *http.Request) (*Message, error) {
+	defer r.Body.Close()
+
+	var doc interface{}
+
+	// Reads one extra byte to know whether the payload exceeds the limit
+	body := &io.LimitedReader{R: r.Body, N: c.maxPayloadSize + 1}
+
+	dec := json.NewDecoder(body)
+	dec.UseNumber()
+
+	err := dec.Decode(&doc)
+	if body.N == 0 {
+		return nil, &Error{Kind: KindTooLarge, Err: fmt.Errorf("payload exceeds the limit of %d bytes", c.maxPayloadSize)}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	header := textproto.MIMEHeader{}
+
+	fromValue, err := c.from.string(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := mail.ParseAddress(fromValue)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address %#v: %w", fromValue, err)
+	}
+	header.Set("From", from.String())
+
+	returnPath, err := c.returnPathAddress(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var to, cc, bcc []string
+	for _, rcpt := range []struct {
+		name string
+		path *jsonPath
+		list *[]string
+	}{
+		{"To", c.to, &to},
+		{"Cc", c.cc, &cc},
+		{"Bcc", c.bcc, &bcc},
+	} {
+		addrs, err := c.addresses(rcpt.path, doc)
+		if err != nil {
+			return nil, err
+		}
+
+		list := make([]string, 0, len(addrs))
+		formatted := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			list = append(list, addr.Address)
+			formatted = append(formatted, addr.String())
+		}
+		*rcpt.list = list
+
+		// Bcc recipients must not be disclosed in the message headers
+		if rcpt.name != "Bcc" && len(formatted) > 0 {
+			header.Set(rcpt.name, strings.Join(formatted, ", "))
+		}
+	}
+
+	if err := c.customHeaders(header, doc); err != nil {
+		return nil, err
+	}
+
+	var (
+		subject string
+		parts   Parts
+	)
+
+	for _, f := range []struct {
+		path *jsonPath
+		dst  *string
+	}{
+		{c.subject, &subject},
+		{c.text, &parts.Text},
+		{c.html, &parts.HTML},
+	} {
+		if f.path == nil {
+			continue
+		}
+		if *f.dst, err = f.path.string(doc); err != nil {
+			return nil, err
+		}
+	}
+
+	if parts.Attachments, err = c.attachmentList(doc); err != nil {
+		return nil, err
+	}
+
+	raw, err := c.spooler.SpoolFunc(func(w io.Writer) error {
+		return buildMessage(w, header, subject, parts)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build message: %w", err)
+	}
+
+	msg, err := ParseMessage(raw)
+	if err != nil {
+		(raw.Close())
+		return nil, fmt.Errorf("failed to parse built message: %w", err)
+	}
+
+	msg.from, msg.to, msg.cc, msg.bcc = from.Address, to, cc, bcc
+	msg.returnPath = returnPath
+
+	return msg, nil
+}
+
+// returnPathAddress returns the envelope sender selected by the return path
+// expression, if any. It is only used for the SMTP transaction.
+func (c *jsonMapping) returnPathAddress(doc interface{}) (string, error) {
+	if c.returnPath == nil {
+		return "", nil
+	}
+
+	v, err := c.returnPath.string(doc)
+	if err != nil || v == "" {
+		return "", err
+	}
+
+	addr, err := mail.ParseAddress(v)
+	if err != nil {
+		return "", fmt.Errorf("invalid return path address %#v: %w", v, err)
+	}
+	return addr.Address, nil
+}
+
+func (c *jsonMapping) addresses(p *jsonPath, doc interface{}) ([]*mail.Address, error) {
+	if p == nil {
+		return nil, nil
+	}
+
+	values, err := p.strings(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]*mail.Address, 0, len(values))
+	for _, v := range values {
+		addr, err := mail.ParseAddress(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %#v: %w", v, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// customHeaders adds the headers selected by the headers expression. The
+// expression must match objects of header names to string values.
+func (c *jsonMapping) customHeaders(header textproto.MIMEHeader, doc interface{}) error {
+	if c.headers == nil {
+		return nil
+	}
+
+	for _, node := range c.headers.eval(doc) {
+		fields, ok := node.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an object, got %T", c.headers, node)
+		}
+
+		for name, v := range fields {
+			value, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("header %s: expected a string value, got %T", name, v)
+			}
+
+			key := textproto.CanonicalMIMEHeaderKey(name)
+			if reservedHeaders[key] {
+				return fmt.Errorf("header %s cannot be overridden", name)
+			}
+
+			if !validHeaderName(name) || strings.ContainsAny(value, "\r\n") {
+				return fmt.Errorf("header %#v is malformed", name)
+			}
+
+			header.Add(key, mime.QEncoding.Encode("utf-8", value))
+		}
+	}
+	return nil
+}
+
+// validHeaderName returns true if the given name is a valid header field name
+// as per RFC 5322 section 3.6.8
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r < '!' || r > '~' || r == ':' {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *jsonMapping) attachmentList(doc interface{}) ([]Attachment, error) {
+	if c.attachments == nil {
+		return nil, nil
+	}
+
+	var list []Attachment
+	for _, item := range c.attachments.path.eval(doc) {
+		a := Attachment{}
+
+		for _, f := range []struct {
+			path *jsonPath
+			dst  *string
+		}{
+			{c.attachments.filename, &a.Filename},
+			{c.attachments.contentType, &a.ContentType},
+		} {
+			if f.path == nil {
+				continue
+			}
+
+			v, err := f.path.string(item)
+			if err != nil {
+				return nil, err
+			}
+			*f.dst = v
+		}
+
+		content, err := c.attachments.content.string(item)
+		if err != nil {
+			return nil, err
+		}
+
+		// The content is decoded as the message is built, so it's not copied
+		if _, err := io.Copy(io.Discard, base64.NewDecoder(base64.StdEncoding, strings.NewReader(content))); err != nil {
+			return nil, fmt.Errorf("attachment %#v: invalid base64 content: %w", a.Filename, err)
+		}
+		a.Content = base64.NewDecoder(base64.StdEncoding, strings.NewReader(content))
+
+		list = append(list, a)
+	}
+	return list, nil
+}
@@ -0,0 +1,371 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var acmeMapping = JSONMapping{
+	ID:   "acme",
+	Path: "/acme/v1/send",
+	Fields: JSONFields{
+		From:       "$.sender",
+		ReturnPath: "$['h:Sender']",
+		To:         "$.to[*].email",
+		Cc:         "$.cc",
+		Bcc:        "$.bcc",
+		Subject:    "$.subject",
+		Text:       "$.body.text",
+		HTML:       "$.body.html",
+		Headers:    "$.headers",
+		Attachments: &JSONAttachmentFields{
+			Path:        "$.files[*]",
+			Filename:    "$.name",
+			ContentType: "$.type",
+			Content:     "$.data",
+		},
+	},
+}
+
+func TestNewJSONMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping JSONMapping
+		wantErr bool
+	}{
+		{
+			name:    "valid mapping",
+			mapping: acmeMapping,
+			wantErr: false,
+		},
+		{
+			name:    "missing ID",
+			mapping: JSONMapping{Path: "/acme", Fields: JSONFields{From: "$.from"}},
+			wantErr: true,
+		},
+		{
+			name:    "path must be absolute",
+			mapping: JSONMapping{ID: "acme", Path: "acme", Fields: JSONFields{From: "$.from"}},
+			wantErr: true,
+		},
+		{
+			name:    "missing from",
+			mapping: JSONMapping{ID: "acme", Path: "/acme"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid expression",
+			mapping: JSONMapping{ID: "acme", Path: "/acme", Fields: JSONFields{From: "$.from", To: "to"}},
+			wantErr: true,
+		},
+		{
+			name:    "negative max payload size",
+			mapping: JSONMapping{ID: "acme", Path: "/acme", MaxPayloadSize: -1, Fields: JSONFields{From: "$.from"}},
+			wantErr: true,
+		},
+		{
+			name: "missing attachment content",
+			mapping: JSONMapping{ID: "acme", Path: "/acme", Fields: JSONFields{
+				From:        "$.from",
+				Attachments: &JSONAttachmentFields{Path: "$.files"},
+			}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewJSONMapping(tt.mapping, testSpooler)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewJSONMapping() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.ID() != tt.mapping.ID {
+				t.Errorf("ID() = %#v, want %#v", got.ID(), tt.mapping.ID)
+			}
+			want := []Route{{Path: tt.mapping.Path, Methods: []string{http.MethodPost}}}
+			if !reflect.DeepEqual(got.Routes(), want) {
+				t.Errorf("Routes() = %#v, want %#v", got.Routes(), want)
+			}
+		})
+	}
+}
+
+func TestLoadJSONMappings(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantIDs []ID
+		wantErr bool
+	}{
+		{
+			name:    "invalid JSON",
+			config:  `[`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid converter config",
+			config:  `{"converters":[{"id":"acme"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid expression",
+			config:  `{"converters":[{"id":"acme","path":"/acme","mapping":{"from":"from"}}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "duplicate ID",
+			config:  `{"converters":[{"id":"acme","path":"/a","mapping":{"from":"$.from"}},{"id":"acme","path":"/b","mapping":{"from":"$.from"}}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "duplicate path",
+			config:  `{"converters":[{"id":"a","path":"/acme","mapping":{"from":"$.from"}},{"id":"b","path":"/acme","mapping":{"from":"$.from"}}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "builtin ID",
+			config:  `{"converters":[{"id":"sparkpost","path":"/acme","mapping":{"from":"$.from"}}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "builtin path",
+			config:  `{"converters":[{"id":"acme","path":"/rfc5322","mapping":{"from":"$.from"}}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "no converter",
+			config:  `{"converters":[]}`,
+			wantIDs: []ID{},
+		},
+		{
+			name:    "several converters",
+			config:  `{"converters":[{"id":"a","path":"/a","mapping":{"from":"$.from"}},{"id":"b","path":"/b","mapping":{"from":"$.sender"}}]}`,
+			wantIDs: []ID{"a", "b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "mappings.json")
+			if err := os.WriteFile(filename, []byte(tt.config), 0o600); err != nil {
+				t.Fatalf("could not write config file: %v", err)
+			}
+
+			got, err := LoadJSONMappings(filename, testSpooler)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadJSONMappings() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			ids := []ID{}
+			for _, c := range got {
+				ids = append(ids, c.ID())
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("LoadJSONMappings() IDs = %#v, want %#v", ids, tt.wantIDs)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := LoadJSONMappings(filepath.Join(t.TempDir(), "ghost.json"), testSpooler); err == nil {
+			t.Error("LoadJSONMappings() error = nil, want an error")
+		}
+	})
+}
+
+func Test_jsonMapping_Convert(t *testing.T) {
+	tests := []struct {
+		name    string
+		reqBody io.Reader
+		wantErr bool
+	}{
+		{name: "body read error", reqBody: &failingReader{}, wantErr: true},
+		{name: "invalid JSON", reqBody: strings.NewReader(`<html>`), wantErr: true},
+		{name: "missing from", reqBody: strings.NewReader(`{"to":[{"email":"bob@example.com"}]}`), wantErr: true},
+		{name: "several from", reqBody: strings.NewReader(`{"sender":["a@example.com","b@example.com"]}`), wantErr: true},
+		{name: "invalid return path", reqBody: strings.NewReader(`{"sender":"a@example.com","h:Sender":"bounce"}`), wantErr: true},
+		{name: "several return paths", reqBody: strings.NewReader(`{"sender":"a@example.com","h:Sender":["a@example.com","b@example.com"]}`), wantErr: true},
+		{name: "invalid recipient", reqBody: strings.NewReader(`{"sender":"a@example.com","cc":"bob"}`), wantErr: true},
+		{name: "recipient is not a scalar", reqBody: strings.NewReader(`{"sender":"a@example.com","cc":{"email":"bob@example.com"}}`), wantErr: true},
+		{name: "several subjects", reqBody: strings.NewReader(`{"sender":"a@example.com","subject":["a","b"]}`), wantErr: true},
+		{name: "headers are not an object", reqBody: strings.NewReader(`{"sender":"a@example.com","headers":"X-Foo: bar"}`), wantErr: true},
+		{name: "header value is not a string", reqBody: strings.NewReader(`{"sender":"a@example.com","headers":{"X-Foo":1}}`), wantErr: true},
+		{name: "reserved header", reqBody: strings.NewReader(`{"sender":"a@example.com","headers":{"bcc":"eve@example.com"}}`), wantErr: true},
+		{name: "header injection", reqBody: strings.NewReader(`{"sender":"a@example.com","headers":{"X-Foo":"bar\r\nBcc: eve@example.com"}}`), wantErr: true},
+		{name: "malformed header name", reqBody: strings.NewReader(`{"sender":"a@example.com","headers":{"X Foo":"bar"}}`), wantErr: true},
+		{name: "invalid attachment content", reqBody: strings.NewReader(`{"sender":"a@example.com","files":[{"name":"a.txt","data":"%%%"}]}`), wantErr: true},
+		{name: "several attachment names", reqBody: strings.NewReader(`{"sender":"a@example.com","files":[{"name":["a","b"],"data":""}]}`), wantErr: true},
+		{name: "several attachment contents", reqBody: strings.NewReader(`{"sender":"a@example.com","files":[{"data":["a","b"]}]}`), wantErr: true},
+		{name: "minimal payload", reqBody: strings.NewReader(`{"sender":"a@example.com"}`), wantErr: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewJSONMapping(acmeMapping, testSpooler)
+			if err != nil {
+				t.Fatalf("NewJSONMapping() error = %v", err)
+			}
+
+			got, err := c.Convert(httptest.NewRequest(http.MethodPost, "/", tt.reqBody))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("jsonMapping.Convert() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got == nil {
+				t.Error("jsonMapping.Convert() = nil, want a message")
+			}
+		})
+	}
+}
+
+func Test_jsonMapping_Convert_payloadSize(t *testing.T) {
+	data := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("a"), 3000))
+	payload := `{"sender":"a@example.com","files":[{"name":"a.txt","data":"` + data + `"}]}`
+
+	tests := []struct {
+		name           string
+		maxPayloadSize int64
+		wantErr        bool
+	}{
+		{name: "default limit", maxPayloadSize: 0, wantErr: false},
+		{name: "payload at the limit", maxPayloadSize: int64(len(payload)), wantErr: false},
+		{name: "payload exceeding the limit", maxPayloadSize: int64(len(payload)) - 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapping := acmeMapping
+			mapping.MaxPayloadSize = tt.maxPayloadSize
+
+			c, err := NewJSONMapping(mapping, testSpooler)
+			if err != nil {
+				t.Fatalf("NewJSONMapping() error = %v", err)
+			}
+
+			got, err := c.Convert(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(payload)))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("jsonMapping.Convert() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if tt.wantErr {
+				var convErr *Error
+				if !errors.As(err, &convErr) || convErr.Kind != KindTooLarge {
+					t.Errorf("jsonMapping.Convert() error = %#v, want a %v error", err, KindTooLarge)
+				}
+				return
+			}
+			defer got.Close()
+
+			if raw := readRaw(t, got); !strings.Contains(raw, data[:base64LineLength]+"\r\n") {
+				t.Errorf("jsonMapping.Convert() message lacks the attachment content: %s", raw)
+			}
+		})
+	}
+}
+
+func Test_jsonMapping_Convert_message(t *testing.T) {
+	c, err := NewJSONMapping(acmeMapping, testSpooler)
+	if err != nil {
+		t.Fatalf("NewJSONMapping() error = %v", err)
+	}
+
+	payload := `{
+		"sender": "Test <test@example.com>",
+		"h:Sender": "Bounces <bounce@example.com>",
+		"to": [{"email": "Bob <bob@example.com>"}, {"email": "carol@example.com"}],
+		"cc": "alice@example.com",
+		"bcc": ["eve@example.com"],
+		"subject": "Héllo world!",
+		"body": {"text": "Hello world!", "html": "<p>Hello world!</p>"},
+		"headers": {"X-Campaign": "welcome"},
+		"files": [{"name": "hello.txt", "type": "text/plain", "data": "aGVsbG8="}]
+	}`
+
+	msg, err := c.Convert(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(payload)))
+	if err != nil {
+		t.Fatalf("jsonMapping.Convert() error = %v", err)
+	}
+
+	if msg.From() != "test@example.com" {
+		t.Errorf("From() = %#v, want %#v", msg.From(), "test@example.com")
+	}
+	if msg.ReturnPath() != "bounce@example.com" {
+		t.Errorf("ReturnPath() = %#v, want %#v", msg.ReturnPath(), "bounce@example.com")
+	}
+	if want := []string{"bob@example.com", "carol@example.com"}; !reflect.DeepEqual(msg.To(), want) {
+		t.Errorf("To() = %#v, want %#v", msg.To(), want)
+	}
+	if want := []string{"alice@example.com"}; !reflect.DeepEqual(msg.Cc(), want) {
+		t.Errorf("Cc() = %#v, want %#v", msg.Cc(), want)
+	}
+	if want := []string{"eve@example.com"}; !reflect.DeepEqual(msg.Bcc(), want) {
+		t.Errorf("Bcc() = %#v, want %#v", msg.Bcc(), want)
+	}
+
+	defer msg.Close()
+
+	m, err := mail.ReadMessage(strings.NewReader(readRaw(t, msg)))
+	if err != nil {
+		t.Fatalf("built message parsing failed: %v", err)
+	}
+
+	for key, want := range map[string]string{
+		"From":       `"Test" <test@example.com>`,
+		"To":         `"Bob" <bob@example.com>, <carol@example.com>`,
+		"Cc":         `<alice@example.com>`,
+		"Bcc":        "",
+		"Subject":    "=?utf-8?q?H=C3=A9llo_world!?=",
+		"X-Campaign": "welcome",
+	} {
+		if got := m.Header.Get(key); got != want {
+			t.Errorf("header %s = %#v, want %#v", key, got, want)
+		}
+	}
+
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type = %#v, want multipart/mixed", m.Header.Get("Content-Type"))
+	}
+
+	var types []string
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("multipart read failed: %v", err)
+		}
+		mt, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		types = append(types, mt)
+
+		if mt == "text/plain" {
+			content, _ := io.ReadAll(p)
+			if got := strings.TrimSpace(string(content)); got != "aGVsbG8=" {
+				t.Errorf("attachment content = %#v, want %#v", got, "aGVsbG8=")
+			}
+		}
+	}
+
+	if want := []string{"multipart/alternative", "text/plain"}; !reflect.DeepEqual(types, want) {
+		t.Errorf("parts = %#v, want %#v", types, want)
+	}
+}
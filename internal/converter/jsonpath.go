@@ -0,0 +1,202 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type stepKind int
+
+const (
+	keyStep stepKind = iota
+	indexStep
+	wildcardStep
+)
+
+// pathStep is a single JSONPath selector
+type pathStep struct {
+	kind  stepKind
+	key   string
+	index int
+}
+
+// jsonPath is a compiled JSONPath expression. Only a subset of the JSONPath
+// syntax is supported: the root ($), child members (.name or ['name']),
+// array indexes ([0], [-1]) and wildcards (.* or [*]).
+type jsonPath struct {
+	expr  string
+	steps []pathStep
+}
+
+// compileJSONPath parses a JSONPath expression
+func compileJSONPath(expr string) (*jsonPath, error) {
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("invalid JSONPath %#v: must start with $", expr)
+	}
+
+	p := &jsonPath{expr: expr}
+	rest := expr[1:]
+
+	for len(rest) > 0 {
+		var (
+			step pathStep
+			err  error
+		)
+
+		switch rest[0] {
+		case '.':
+			step, rest, err = parseMember(rest[1:])
+		case '[':
+			step, rest, err = parseBracket(rest[1:])
+		default:
+			err = fmt.Errorf("unexpected character %q", rest[0])
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSONPath %#v: %w", expr, err)
+		}
+		p.steps = append(p.steps, step)
+	}
+
+	return p, nil
+}
+
+func parseMember(s string) (pathStep, string, error) {
+	if strings.HasPrefix(s, "*") {
+		return pathStep{kind: wildcardStep}, s[1:], nil
+	}
+
+	end := strings.IndexAny(s, ".[")
+	if end == -1 {
+		end = len(s)
+	}
+
+	if end == 0 {
+		return pathStep{}, "", fmt.Errorf("empty member name")
+	}
+	return pathStep{kind: keyStep, key: s[:end]}, s[end:], nil
+}
+
+func parseBracket(s string) (pathStep, string, error) {
+	end := strings.IndexByte(s, ']')
+	if end == -1 {
+		return pathStep{}, "", fmt.Errorf("unterminated bracket")
+	}
+
+	inner, rest := strings.TrimSpace(s[:end]), s[end+1:]
+
+	switch {
+	case inner == "*":
+		return pathStep{kind: wildcardStep}, rest, nil
+	case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+		return pathStep{kind: keyStep, key: inner[1 : len(inner)-1]}, rest, nil
+	}
+
+	index, err := strconv.Atoi(inner)
+	if err != nil {
+		return pathStep{}, "", fmt.Errorf("invalid index %#v", inner)
+	}
+	return pathStep{kind: indexStep, index: index}, rest, nil
+}
+
+// String returns the JSONPath expression
+func (p *jsonPath) String() string {
+	return p.expr
+}
+
+// eval returns all the nodes of the given document matching the path
+func (p *jsonPath) eval(doc interface{}) []interface{} {
+	nodes := []interface{}{doc}
+
+	for _, step := range p.steps {
+		var next []interface{}
+
+		for _, node := range nodes {
+			switch v := node.(type) {
+			case map[string]interface{}:
+				switch step.kind {
+				case keyStep:
+					if child, ok := v[step.key]; ok {
+						next = append(next, child)
+					}
+				case wildcardStep:
+					// Sorts the keys so the results order is predictable
+					keys := make([]string, 0, len(v))
+					for k := range v {
+						keys = append(keys, k)
+					}
+					sort.Strings(keys)
+					for _, k := range keys {
+						next = append(next, v[k])
+					}
+				case indexStep:
+				}
+			case []interface{}:
+				switch step.kind {
+				case indexStep:
+					i := step.index
+					if i < 0 {
+						i += len(v)
+					}
+					if i >= 0 && i < len(v) {
+						next = append(next, v[i])
+					}
+				case wildcardStep:
+					next = append(next, v...)
+				case keyStep:
+				}
+			}
+		}
+		nodes = next
+	}
+
+	return nodes
+}
+
+// strings evaluates the path against the given document and returns the
+// matching scalar values as strings. Matching arrays are flattened.
+func (p *jsonPath) strings(doc interface{}) ([]string, error) {
+	var list []string
+
+	for _, node := range p.eval(doc) {
+		values, ok := node.([]interface{})
+		if !ok {
+			values = []interface{}{node}
+		}
+
+		for _, v := range values {
+			switch s := v.(type) {
+			case nil:
+			case string:
+				list = append(list, s)
+			case json.Number:
+				list = append(list, s.String())
+			case bool:
+				list = append(list, strconv.FormatBool(s))
+			default:
+				return nil, fmt.Errorf("%s: expected a scalar value, got %T", p, v)
+			}
+		}
+	}
+	return list, nil
+}
+
+// string evaluates the path against the given document and returns a single
+// string value. It returns an empty string when nothing matches.
+func (p *jsonPath) string(doc interface{}) (string, error) {
+	list, err := p.strings(doc)
+	if err != nil {
+		return "", err
+	}
+
+	switch len(list) {
+	case 0:
+		return "", nil
+	case 1:
+		return list[0], nil
+	}
+	return "", fmt.Errorf("%s: expected a single value, got %d", p, len(list))
+}
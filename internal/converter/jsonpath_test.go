@@ -0,0 +1,183 @@
+package converter
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_compileJSONPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		want    []pathStep
+		wantErr bool
+	}{
+		{
+			name: "root only",
+			expr: "$",
+			want: nil,
+		},
+		{
+			name: "dot members",
+			expr: "$.foo.bar",
+			want: []pathStep{{kind: keyStep, key: "foo"}, {kind: keyStep, key: "bar"}},
+		},
+		{
+			name: "bracket members, indexes and wildcards",
+			expr: "$['foo'][\"b.ar\"][2][-1][*].*",
+			want: []pathStep{
+				{kind: keyStep, key: "foo"},
+				{kind: keyStep, key: "b.ar"},
+				{kind: indexStep, index: 2},
+				{kind: indexStep, index: -1},
+				{kind: wildcardStep},
+				{kind: wildcardStep},
+			},
+		},
+		{
+			name:    "missing root",
+			expr:    "foo.bar",
+			wantErr: true,
+		},
+		{
+			name:    "empty member",
+			expr:    "$..foo",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated bracket",
+			expr:    "$[0",
+			wantErr: true,
+		},
+		{
+			name:    "invalid index",
+			expr:    "$[foo]",
+			wantErr: true,
+		},
+		{
+			name:    "unexpected character",
+			expr:    "$foo",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := compileJSONPath(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("compileJSONPath() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got.steps, tt.want) {
+				t.Errorf("compileJSONPath() = %#v, want %#v", got.steps, tt.want)
+			}
+			if got.String() != tt.expr {
+				t.Errorf("jsonPath.String() = %#v, want %#v", got.String(), tt.expr)
+			}
+		})
+	}
+}
+
+func Test_jsonPath_strings(t *testing.T) {
+	doc := decodeJSON(t, `{
+		"from": "Test <test@example.com>",
+		"count": 42,
+		"flag": true,
+		"none": null,
+		"object": {"b": "2", "a": "1"},
+		"list": ["x", "y"],
+		"recipients": [
+			{"address": {"email": "bob@example.com"}},
+			{"address": {"email": "alice@example.com"}},
+			{"name": "no address"}
+		]
+	}`)
+
+	tests := []struct {
+		name    string
+		expr    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "string", expr: "$.from", want: []string{"Test <test@example.com>"}},
+		{name: "number", expr: "$.count", want: []string{"42"}},
+		{name: "bool", expr: "$.flag", want: []string{"true"}},
+		{name: "null", expr: "$.none", want: nil},
+		{name: "missing member", expr: "$.ghost", want: nil},
+		{name: "array is flattened", expr: "$.list", want: []string{"x", "y"}},
+		{name: "array index", expr: "$.list[1]", want: []string{"y"}},
+		{name: "negative array index", expr: "$.list[-2]", want: []string{"x"}},
+		{name: "out of range index", expr: "$.list[2]", want: nil},
+		{name: "object wildcard is sorted by key", expr: "$.object.*", want: []string{"1", "2"}},
+		{name: "array wildcard", expr: "$.recipients[*].address.email", want: []string{"bob@example.com", "alice@example.com"}},
+		{name: "key on array", expr: "$.list.foo", want: nil},
+		{name: "index on object", expr: "$.object[0]", want: nil},
+		{name: "object is not a scalar", expr: "$.object", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := compileJSONPath(tt.expr)
+			if err != nil {
+				t.Fatalf("compileJSONPath() error = %v", err)
+			}
+
+			got, err := p.strings(doc)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("jsonPath.strings() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("jsonPath.strings() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_jsonPath_string(t *testing.T) {
+	doc := decodeJSON(t, `{"one": "1", "many": ["1", "2"], "object": {}}`)
+
+	tests := []struct {
+		name    string
+		expr    string
+		want    string
+		wantErr bool
+	}{
+		{name: "no value", expr: "$.ghost", want: ""},
+		{name: "single value", expr: "$.one", want: "1"},
+		{name: "several values", expr: "$.many", wantErr: true},
+		{name: "not a scalar", expr: "$.object", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := compileJSONPath(tt.expr)
+			if err != nil {
+				t.Fatalf("compileJSONPath() error = %v", err)
+			}
+
+			got, err := p.string(doc)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("jsonPath.string() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("jsonPath.string() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func decodeJSON(t *testing.T, data string) interface{} {
+	var doc interface{}
+
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+
+	if err := dec.Decode(&doc); err != nil {
+		t.Fatalf("could not decode JSON: %v", err)
+	}
+	return doc
+}
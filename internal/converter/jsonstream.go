@@ -0,0 +1,260 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// maxKeyLength is the max length of the raw object keys compared to the
+// extracted string path. Longer keys can't match it.
+const maxKeyLength = 128
+
+// extractorBufferSize is the size of the chunks read from the document
+const extractorBufferSize = 32 << 10
+
+// hexDigits is the number of hex digits of a \uXXXX escape sequence
+const hexDigits = 4
+
+// jsonEscapes maps the JSON escape sequences to the characters they stand for
+var jsonEscapes = map[byte]byte{
+	'"':  '"',
+	'\\': '\\',
+	'/':  '/',
+	'b':  '\b',
+	'f':  '\f',
+	'n':  '\n',
+	'r':  '\r',
+	't':  '\t',
+}
+
+// jsonFrame is an object or an array the scanner is in
+type jsonFrame struct {
+	object bool
+	// inKey is true while the next object string is a key
+	inKey bool
+	// key is the raw current object key, quotes included
+	key []byte
+}
+
+// stringExtractor reads a JSON document and streams the decoded value of the
+// string found at the given object key path to w, instead of the reader. The
+// string is read as an empty one by the JSON decoder, so big string values
+// never have to be held in memory. Keys are matched case-insensitively like
+// the JSON decoder does.
+type stringExtractor struct {
+	r    io.Reader
+	w    io.Writer
+	path []string
+	buf  []byte
+	// pending holds the bytes read from r not scanned yet
+	pending []byte
+	err     error
+	stack   []jsonFrame
+
+	inString, escaped, extracting bool
+	// hex holds the digits of the \uXXXX sequence being read
+	hex []byte
+	// surrogate is the pending high surrogate of a UTF-16 pair
+	surrogate rune
+
+	// found is true once the string has been found
+	found bool
+	// size is the length of the decoded string
+	size int64
+}
+
+func newStringExtractor(r io.Reader, w io.Writer, path ...string) *stringExtractor {
+	return &stringExtractor{r: r, w: w, path: path, buf: make([]byte, extractorBufferSize)}
+}
+
+// Read implements io.Reader
+func (e *stringExtractor) Read(p []byte) (int, error) {
+	n := 0
+	for n == 0 && len(p) > 0 {
+		if len(e.pending) == 0 {
+			if errors.Is(e.err, io.EOF) && e.extracting {
+				return 0, io.ErrUnexpectedEOF
+			}
+			if e.err != nil {
+				return 0, e.err
+			}
+
+			var m int
+			m, e.err = e.r.Read(e.buf)
+			e.pending = e.buf[:m]
+			continue
+		}
+
+		for len(e.pending) > 0 && n < len(p) {
+			c := e.pending[0]
+			e.pending = e.pending[1:]
+
+			if e.extracting {
+				end, err := e.extract(c)
+				if err != nil {
+					return n, err
+				}
+				// The string content is skipped, only its closing quote is read
+				if !end {
+					continue
+				}
+			} else if err := e.scan(c); err != nil {
+				return n, err
+			}
+
+			p[n] = c
+			n++
+		}
+	}
+	return n, nil
+}
+
+// scan tracks the position in the document and starts the extraction when
+// the byte opens the string at the extracted path
+func (e *stringExtractor) scan(c byte) error {
+	top := len(e.stack) - 1
+
+	if e.inString {
+		if top >= 0 && e.stack[top].object && e.stack[top].inKey && len(e.stack[top].key) <= maxKeyLength {
+			e.stack[top].key = append(e.stack[top].key, c)
+		}
+
+		switch {
+		case e.escaped:
+			e.escaped = false
+		case c == '\\':
+			e.escaped = true
+		case c == '"':
+			e.inString = false
+		}
+		return nil
+	}
+
+	switch c {
+	case '"':
+		if top >= 0 && e.stack[top].object && e.stack[top].inKey {
+			e.inString = true
+			e.stack[top].key = append(e.stack[top].key[:0], c)
+			return nil
+		}
+		if !e.atPath() {
+			e.inString = true
+			return nil
+		}
+		// The decoder would keep the last value only
+		if e.found {
+			return errors.New("duplicate " + strings.Join(e.path, ".") + " field")
+		}
+		e.found, e.extracting = true, true
+	case '{', '[':
+		e.stack = append(e.stack, jsonFrame{object: c == '{', inKey: c == '{'})
+	case '}', ']':
+		if top >= 0 {
+			e.stack = e.stack[:top]
+		}
+	case ':':
+		if top >= 0 {
+			e.stack[top].inKey = false
+		}
+	case ',':
+		if top >= 0 && e.stack[top].object {
+			e.stack[top].inKey = true
+		}
+	}
+	return nil
+}
+
+// atPath returns true if the scanner is at the value of the extracted path
+func (e *stringExtractor) atPath() bool {
+	if len(e.stack) != len(e.path) {
+		return false
+	}
+
+	for i, f := range e.stack {
+		if !f.object || f.inKey {
+			return false
+		}
+		key, err := strconv.Unquote(string(f.key))
+		if err != nil || !strings.EqualFold(key, e.path[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// extract decodes a byte of the extracted string. It returns true on the
+// closing quote, which is given to the reader.
+func (e *stringExtractor) extract(c byte) (bool, error) {
+	switch {
+	case e.hex != nil:
+		e.hex = append(e.hex, c)
+		if len(e.hex) < hexDigits {
+			return false, nil
+		}
+		n, err := strconv.ParseUint(string(e.hex), 16, 16)
+		if err != nil {
+			return false, fmt.Errorf("invalid escape sequence \\u%s", e.hex)
+		}
+		e.hex = nil
+		return false, e.writeRune(rune(n))
+	case e.escaped:
+		e.escaped = false
+		if c == 'u' {
+			e.hex = make([]byte, 0, hexDigits)
+			return false, nil
+		}
+		unescaped, ok := jsonEscapes[c]
+		if !ok {
+			return false, fmt.Errorf("invalid escape sequence \\%c", c)
+		}
+		return false, e.write([]byte{unescaped})
+	case c == '\\':
+		e.escaped = true
+		return false, nil
+	case c == '"':
+		e.extracting = false
+		return true, e.write(nil)
+	}
+	return false, e.write([]byte{c})
+}
+
+// writeRune writes the rune of a \uXXXX sequence, pairing the UTF-16 surrogates
+func (e *stringExtractor) writeRune(r rune) error {
+	if e.surrogate != 0 {
+		high := e.surrogate
+		e.surrogate = 0
+		if pair := utf16.DecodeRune(high, r); pair != utf8.RuneError {
+			return e.write([]byte(string(pair)))
+		}
+		if err := e.write([]byte(string(utf8.RuneError))); err != nil {
+			return err
+		}
+	}
+
+	if utf16.IsSurrogate(r) {
+		e.surrogate = r
+		return nil
+	}
+	return e.write([]byte(string(r)))
+}
+
+// write writes decoded bytes to w. A pending high surrogate is not followed
+// by its low one anymore and is replaced.
+func (e *stringExtractor) write(b []byte) error {
+	if e.surrogate != 0 {
+		e.surrogate = 0
+		b = append([]byte(string(utf8.RuneError)), b...)
+	}
+	if len(b) == 0 {
+		return nil
+	}
+
+	n, err := e.w.Write(b)
+	e.size += int64(n)
+	return err
+}
@@ -0,0 +1,97 @@
+package converter
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func Test_stringExtractor(t *testing.T) {
+	tests := []struct {
+		name          string
+		doc           string
+		wantDoc       string
+		wantExtracted string
+		wantFound     bool
+		wantErr       bool
+	}{
+		{
+			name:          "string is extracted",
+			doc:           `{"id":1,"content":{"subject":"hi","raw":"a\"b\\c\nd"},"raw":"kept"}`,
+			wantDoc:       `{"id":1,"content":{"subject":"hi","raw":""},"raw":"kept"}`,
+			wantExtracted: "a\"b\\c\nd",
+			wantFound:     true,
+		},
+		{
+			name:          "keys are matched case-insensitively",
+			doc:           `{"Content": {"RaW" : "abc"}}`,
+			wantDoc:       `{"Content": {"RaW" : ""}}`,
+			wantExtracted: "abc",
+			wantFound:     true,
+		},
+		{
+			name:          "unicode escape sequences are decoded",
+			doc:           `{"content":{"raw":"café 😀 \ud83d."}}`,
+			wantDoc:       `{"content":{"raw":""}}`,
+			wantExtracted: "café 😀 �.",
+			wantFound:     true,
+		},
+		{
+			name:    "strings of other paths are kept",
+			doc:     `{"raw":"a","content":[{"raw":"b"}],"other":{"content":{"raw":"c"}},"x":"{\"content\":{\"raw\":\"d\"}}"}`,
+			wantDoc: `{"raw":"a","content":[{"raw":"b"}],"other":{"content":{"raw":"c"}},"x":"{\"content\":{\"raw\":\"d\"}}"}`,
+		},
+		{
+			name:    "non-string value is kept",
+			doc:     `{"content":{"raw":42}}`,
+			wantDoc: `{"content":{"raw":42}}`,
+		},
+		{
+			name:    "duplicate string",
+			doc:     `{"content":{"raw":"a","raw":"b"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid escape sequence",
+			doc:     `{"content":{"raw":"\x"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "unterminated string",
+			doc:     `{"content":{"raw":"abc`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Reading one byte at a time splits the escape sequences
+			for _, r := range []io.Reader{strings.NewReader(tt.doc), iotest.OneByteReader(strings.NewReader(tt.doc))} {
+				extracted := &bytes.Buffer{}
+				e := newStringExtractor(r, extracted, "content", "raw")
+
+				got, err := io.ReadAll(e)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("stringExtractor.Read() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if tt.wantErr {
+					continue
+				}
+
+				if string(got) != tt.wantDoc {
+					t.Errorf("stringExtractor.Read() = %s, want %s", got, tt.wantDoc)
+				}
+				if extracted.String() != tt.wantExtracted {
+					t.Errorf("extracted = %q, want %q", extracted.String(), tt.wantExtracted)
+				}
+				if e.found != tt.wantFound {
+					t.Errorf("found = %v, want %v", e.found, tt.wantFound)
+				}
+				if e.size != int64(len(tt.wantExtracted)) {
+					t.Errorf("size = %v, want %v", e.size, len(tt.wantExtracted))
+				}
+			}
+		})
+	}
+}
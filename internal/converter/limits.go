@@ -0,0 +1,76 @@
+package converter
+
+import "fmt"
+
+// Limits holds the limits the transmissions must comply with. Zero values
+// stand for no limit.
+type Limits struct {
+	// MessageSize is the max message size in bytes
+	MessageSize int64
+	// Attachments is the max number of attachments of a message
+	Attachments int
+	// AttachmentSize is the max encoded size in bytes of each attachment
+	AttachmentSize int64
+	// Recipients is the max number of recipients of a transmission
+	Recipients int
+}
+
+// LimitError is the error of a transmission exceeding one of the limits
+type LimitError struct {
+	// Limit is the name of the exceeded limit
+	Limit string
+	Max   int64
+	Value int64
+}
+
+// Error implements the error interface
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s %d exceeds the limit of %d", e.Limit, e.Value, e.Max)
+}
+
+// Check returns an error if the message exceeds one of the limits.
+// The message body is only parsed when attachments are limited.
+func (l Limits) Check(m *Message) error {
+	if m == nil {
+		return nil
+	}
+
+	if rcpts := len(m.To()) + len(m.Cc()) + len(m.Bcc()); l.Recipients > 0 && rcpts > l.Recipients {
+		return limitError(KindUnprocessable, "recipient count", int64(l.Recipients), int64(rcpts))
+	}
+
+	if size := m.Size(); l.MessageSize > 0 && size > l.MessageSize {
+		return limitError(KindTooLarge, "message size", l.MessageSize, size)
+	}
+
+	if l.Attachments == 0 && l.AttachmentSize == 0 {
+		return nil
+	}
+
+	root, err := m.Parts()
+	if err != nil {
+		return &Error{Kind: KindInvalid, Err: err}
+	}
+
+	var attachments []*Part
+	root.Walk(func(p *Part) {
+		if p.IsAttachment() {
+			attachments = append(attachments, p)
+		}
+	})
+
+	if l.Attachments > 0 && len(attachments) > l.Attachments {
+		return limitError(KindUnprocessable, "attachment count", int64(l.Attachments), int64(len(attachments)))
+	}
+
+	for _, p := range attachments {
+		if l.AttachmentSize > 0 && p.Size > l.AttachmentSize {
+			return limitError(KindTooLarge, "attachment size", l.AttachmentSize, p.Size)
+		}
+	}
+	return nil
+}
+
+func limitError(kind ErrorKind, limit string, max, value int64) error {
+	return &Error{Kind: kind, Err: &LimitError{Limit: limit, Max: max, Value: value}}
+}
@@ -0,0 +1,76 @@
+package converter
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLimits_Check(t *testing.T) {
+	const raw = "From: from@example.com\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Hello\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Content-Disposition: attachment; filename=a.txt\r\n" +
+		"\r\n" +
+		"0123456789\r\n" +
+		"--b\r\n" +
+		"Content-Type: application/pdf; name=b.pdf\r\n" +
+		"\r\n" +
+		"01234\r\n" +
+		"--b--\r\n"
+
+	tests := []struct {
+		name      string
+		limits    Limits
+		raw       string
+		wantLimit string
+		wantKind  ErrorKind
+	}{
+		{name: "no limit", raw: raw},
+		{name: "within limits", limits: Limits{MessageSize: int64(len(raw)), Attachments: 2, AttachmentSize: 10, Recipients: 3}, raw: raw},
+		{name: "too many recipients", limits: Limits{Recipients: 2}, raw: raw, wantLimit: "recipient count", wantKind: KindUnprocessable},
+		{name: "message too large", limits: Limits{MessageSize: int64(len(raw)) - 1}, raw: raw, wantLimit: "message size", wantKind: KindTooLarge},
+		{name: "too many attachments", limits: Limits{Attachments: 1}, raw: raw, wantLimit: "attachment count", wantKind: KindUnprocessable},
+		{name: "attachment too large", limits: Limits{AttachmentSize: 9}, raw: raw, wantLimit: "attachment size", wantKind: KindTooLarge},
+		{name: "malformed body", limits: Limits{Attachments: 1}, raw: "Content-Type: multipart/mixed\r\n\r\n", wantKind: KindInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseMessage(strings.NewReader(tt.raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.to, m.cc, m.bcc = []string{"a@example.com"}, []string{"b@example.com"}, []string{"c@example.com"}
+
+			err = tt.limits.Check(m)
+			if (err != nil) != (tt.wantKind != KindInternal) {
+				t.Fatalf("Limits.Check() error = %v, want kind %v", err, tt.wantKind)
+			}
+			if err == nil {
+				return
+			}
+
+			if KindOf(err) != tt.wantKind {
+				t.Errorf("Limits.Check() error kind = %v, want %v", KindOf(err), tt.wantKind)
+			}
+
+			var limitErr *LimitError
+			if errors.As(err, &limitErr) != (tt.wantLimit != "") || limitErr != nil && limitErr.Limit != tt.wantLimit {
+				t.Errorf("Limits.Check() error = %#v, want limit %#v", err, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestLimitError_Error(t *testing.T) {
+	err := &LimitError{Limit: "message size", Max: 10, Value: 12}
+	if got, want := err.Error(), "message size 12 exceeds the limit of 10"; got != want {
+		t.Errorf("LimitError.Error() = %#v, want %#v", got, want)
+	}
+}
@@ -1,33 +1,130 @@
 package converter
 
-import "io"
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
 
 // RecipientProvider is the common func type for To(), Cc() and Bcc()
 type RecipientProvider func(*Message) []string
 
-// Message represents an email message
+// Raw is a re-readable raw message content
+type Raw interface {
+	io.ReaderAt
+	Size() int64
+}
+
+// Message represents an email message: its envelope, its parsed header and
+// its raw content. The content can be read as many times as needed.
 type Message struct {
 	from        string
+	returnPath  string
 	to, cc, bcc []string
-	raw         io.Reader
+	header      *Header
+	separator   string
+	raw         Raw
+	bodyOffset  int64
+}
+
+// NewMessage returns a new Message instance. The raw content is not parsed
+// and is written as it is, after the fields added to the message header.
+func NewMessage(from string, to, cc, bcc []string, raw Raw) *Message {
+	return &Message{
+		from:   from,
+		to:     to,
+		cc:     cc,
+		bcc:    bcc,
+		header: &Header{},
+		raw:    raw,
+	}
 }
 
-// NewMessage returns a new Message instance
-func NewMessage(from string, to, cc, bcc []string, raw io.Reader) *Message {
+// ParseMessage parses the header of the given raw content and returns
+// a message without envelope
+func ParseMessage(raw Raw) (*Message, error) {
+	header, separator, err := parseHeader(bufio.NewReader(io.NewSectionReader(raw, 0, raw.Size())))
+	if err != nil {
+		return nil, err
+	}
+
+	// The buffered reader may have read past the header section so
+	// the body offset is computed from the raw header fields
+	offset := int64(0)
+	for _, f := range header.fields {
+		offset += int64(len(f.raw))
+	}
+	offset += int64(len(separator))
+
 	return &Message{
-		from: from,
-		to:   to,
-		cc:   cc,
-		bcc:  bcc,
-		raw:  raw,
+		header:     header,
+		separator:  separator,
+		raw:        raw,
+		bodyOffset: offset,
+	}, nil
+}
+
+// Envelope is the SMTP envelope of a message
+type Envelope struct {
+	From       string   `json:"from"`
+	ReturnPath string   `json:"return_path,omitempty"`
+	To         []string `json:"to,omitempty"`
+	Cc         []string `json:"cc,omitempty"`
+	Bcc        []string `json:"bcc,omitempty"`
+}
+
+// Without returns the envelope without the given recipients, e.g. the ones
+// a message was delivered to already
+func (e Envelope) Without(rcpts []string) Envelope {
+	done := make(map[string]bool, len(rcpts))
+	for _, rcpt := range rcpts {
+		done[rcpt] = true
+	}
+
+	filter := func(rcpts []string) []string {
+		var pending []string
+		for _, rcpt := range rcpts {
+			if !done[rcpt] {
+				pending = append(pending, rcpt)
+			}
+		}
+		return pending
+	}
+
+	e.To, e.Cc, e.Bcc = filter(e.To), filter(e.Cc), filter(e.Bcc)
+	return e
+}
+
+// Envelope returns the message envelope
+func (m *Message) Envelope() Envelope {
+	return Envelope{
+		From:       m.from,
+		ReturnPath: m.returnPath,
+		To:         m.to,
+		Cc:         m.cc,
+		Bcc:        m.bcc,
 	}
 }
 
+// SetEnvelope replaces the message envelope, e.g. the one of a message
+// parsed back from its raw content
+func (m *Message) SetEnvelope(e Envelope) {
+	m.from, m.returnPath = e.From, e.ReturnPath
+	m.to, m.cc, m.bcc = e.To, e.Cc, e.Bcc
+}
+
 // From returns the message's From: value
 func (m *Message) From() string {
 	return m.from
 }
 
+// ReturnPath returns the envelope sender requested for the message, if any.
+// Bounces are sent to this address instead of the From: one.
+func (m *Message) ReturnPath() string {
+	return m.returnPath
+}
+
 // To returns the To: recipient(s)
 func (m *Message) To() []string {
 	return m.to
@@ -43,9 +140,75 @@ func (m *Message) Bcc() []string {
 	return m.bcc
 }
 
-// Raw returns the raw message (with its headers) as a byte stream
-func (m *Message) Raw() ([]byte, error) {
-	return io.ReadAll(m.raw)
+// Header returns the message header. Changes made to the header
+// are reflected when the message is read.
+func (m *Message) Header() *Header {
+	if m.header == nil {
+		m.header = &Header{}
+	}
+	return m.header
+}
+
+// WithHeader returns a copy of the message with the given header. The copy
+// shares the raw content of the message.
+func (m *Message) WithHeader(h *Header) *Message {
+	c := *m
+	c.header = h
+	return &c
+}
+
+// MessageID returns the message's Message-ID: value without its angle brackets
+func (m *Message) MessageID() string {
+	if m == nil {
+		return ""
+	}
+	return strings.Trim(m.Header().Get("Message-Id"), "<>")
+}
+
+// Body returns a new reader of the message body
+func (m *Message) Body() io.Reader {
+	if m.raw == nil {
+		return &bytes.Reader{}
+	}
+	return io.NewSectionReader(m.raw, m.bodyOffset, m.raw.Size()-m.bodyOffset)
+}
+
+// Reader returns a new reader of the whole message: its header and body
+func (m *Message) Reader() io.Reader {
+	header := &bytes.Buffer{}
+	(m.Header().WriteTo(header))
+	header.WriteString(m.separator)
+
+	return io.MultiReader(header, m.Body())
+}
+
+// Size returns the message size in bytes
+func (m *Message) Size() int64 {
+	header := &bytes.Buffer{}
+	(m.Header().WriteTo(header))
+
+	size := int64(header.Len() + len(m.separator))
+	if m.raw != nil {
+		size += m.raw.Size() - m.bodyOffset
+	}
+	return size
+}
+
+// WriteTo streams the whole message to the given writer
+func (m *Message) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(w, m.Reader())
+}
+
+// Close releases the resources held by the raw message
+func (m *Message) Close() error {
+	if m == nil {
+		return nil
+	}
+
+	if c, ok := m.raw.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
 }
 
 // HasRecipients returns true if the message contains as least one recipient
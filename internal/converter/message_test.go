@@ -13,7 +13,7 @@ func TestNewMessage(t *testing.T) {
 		to   []string
 		cc   []string
 		bcc  []string
-		raw  io.Reader
+		raw  Raw
 	}
 	tests := []struct {
 		name string
@@ -23,7 +23,7 @@ func TestNewMessage(t *testing.T) {
 		{
 			name: "default values",
 			args: args{},
-			want: &Message{},
+			want: &Message{header: &Header{}},
 		},
 		{
 			name: "nil recipients",
@@ -33,7 +33,7 @@ func TestNewMessage(t *testing.T) {
 				cc:   nil,
 				bcc:  nil,
 			},
-			want: &Message{from: "from@example.com"},
+			want: &Message{from: "from@example.com", header: &Header{}},
 		},
 		{
 			name: "non-nil but empty recipients",
@@ -44,16 +44,17 @@ func TestNewMessage(t *testing.T) {
 				bcc:  []string{},
 			},
 			want: &Message{
-				from: "from@example.com",
-				to:   []string{},
-				cc:   []string{},
-				bcc:  []string{},
+				from:   "from@example.com",
+				to:     []string{},
+				cc:     []string{},
+				bcc:    []string{},
+				header: &Header{},
 			},
 		},
 		{
 			name: "with raw email",
 			args: args{raw: strings.NewReader("foo bar")},
-			want: &Message{raw: strings.NewReader("foo bar")},
+			want: &Message{header: &Header{}, raw: strings.NewReader("foo bar")},
 		},
 	}
 	for _, tt := range tests {
@@ -157,47 +158,89 @@ func TestMessage_Getters(t *testing.T) {
 	}
 }
 
-func TestMessage_Raw(t *testing.T) {
+func TestMessage_WriteTo(t *testing.T) {
 	tests := []struct {
 		name    string
-		raw     io.Reader
-		want    []byte
+		raw     Raw
+		want    string
 		wantErr bool
 	}{
+		{
+			name:    "nil reader",
+			raw:     nil,
+			want:    "",
+			wantErr: false,
+		},
 		{
 			name:    "empty reader",
 			raw:     &strings.Reader{},
-			want:    []byte{},
+			want:    "",
 			wantErr: false,
 		},
 		{
 			name:    "reader with data",
 			raw:     strings.NewReader("foo bar"),
-			want:    []byte(`foo bar`),
+			want:    "foo bar",
 			wantErr: false,
 		},
 		{
 			name:    "read error",
 			raw:     &failingReader{},
-			want:    []byte{},
+			want:    "",
 			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			m := &Message{raw: tt.raw}
-			got, err := m.Raw()
-			if (err != nil) != tt.wantErr {
-				t.Errorf("Message.Raw() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("Message.Raw() = %v, want %v", got, tt.want)
+
+			// Writes the message twice to ensure it can be read several times
+			for i := 0; i < 2; i++ {
+				w := &strings.Builder{}
+				n, err := m.WriteTo(w)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("Message.WriteTo() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if got := w.String(); got != tt.want || n != int64(len(tt.want)) {
+					t.Errorf("Message.WriteTo() = %#v (%d), want %#v", got, n, tt.want)
+				}
 			}
 		})
 	}
 }
 
+func TestMessage_Close(t *testing.T) {
+	t.Run("nil message", func(t *testing.T) {
+		var m *Message
+		if err := m.Close(); err != nil {
+			t.Errorf("Message.Close() = %v, want nil", err)
+		}
+	})
+
+	t.Run("raw is not a closer", func(t *testing.T) {
+		m := &Message{raw: strings.NewReader("foo")}
+		if err := m.Close(); err != nil {
+			t.Errorf("Message.Close() = %v, want nil", err)
+		}
+	})
+
+	t.Run("raw is a closer", func(t *testing.T) {
+		spool, err := NewSpooler(t.TempDir(), 0).Spool(strings.NewReader("foo"))
+		if err != nil {
+			t.Fatalf("Spool() error = %v", err)
+		}
+
+		m := &Message{raw: spool}
+		if err := m.Close(); err != nil {
+			t.Errorf("Message.Close() = %v, want nil", err)
+		}
+		if err := m.Close(); err == nil {
+			t.Error("Message.Close() = nil, want an error as the spool is already closed")
+		}
+	})
+}
+
 func TestMessage_HasRecipients(t *testing.T) {
 	type fields struct {
 		to  []string
@@ -252,3 +295,182 @@ func TestMessage_HasRecipients(t *testing.T) {
 		})
 	}
 }
+
+func TestParseMessage(t *testing.T) {
+	tests := []struct {
+		name          string
+		raw           string
+		wantMessageID string
+		wantBody      string
+		wantErr       bool
+	}{
+		{
+			name:     "message without Message-ID",
+			raw:      simpleMessage,
+			wantBody: "Hello world!",
+		},
+		{
+			name:          "message with Message-ID",
+			raw:           "Message-ID: <1234@example.com>\r\nFrom: test@example.com\r\n\r\nHello\r\n",
+			wantMessageID: "1234@example.com",
+			wantBody:      "Hello\r\n",
+		},
+		{
+			name:     "message without body",
+			raw:      "From: test@example.com\r\n",
+			wantBody: "",
+		},
+		{
+			name:    "malformed header",
+			raw:     "From test@example.com\r\n\r\nHello",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMessage(strings.NewReader(tt.raw))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseMessage() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got.MessageID() != tt.wantMessageID {
+				t.Errorf("MessageID() = %#v, want %#v", got.MessageID(), tt.wantMessageID)
+			}
+
+			body := &strings.Builder{}
+			if _, err := io.Copy(body, got.Body()); err != nil {
+				t.Fatalf("Body() read error = %v", err)
+			}
+			if body.String() != tt.wantBody {
+				t.Errorf("Body() = %#v, want %#v", body.String(), tt.wantBody)
+			}
+
+			// The message is read as it is, twice
+			for i := 0; i < 2; i++ {
+				raw := &strings.Builder{}
+				if _, err := io.Copy(raw, got.Reader()); err != nil {
+					t.Fatalf("Reader() read error = %v", err)
+				}
+				if raw.String() != tt.raw {
+					t.Errorf("Reader() = %#v, want %#v", raw.String(), tt.raw)
+				}
+			}
+
+			if got.Size() != int64(len(tt.raw)) {
+				t.Errorf("Size() = %v, want %v", got.Size(), len(tt.raw))
+			}
+		})
+	}
+}
+
+func TestMessage_Header(t *testing.T) {
+	t.Run("header changes are reflected", func(t *testing.T) {
+		m, err := ParseMessage(strings.NewReader(messageWithBcc))
+		if err != nil {
+			t.Fatalf("ParseMessage() error = %v", err)
+		}
+
+		m.Header().Del("Bcc")
+		m.Header().Add("Message-ID", "<42@example.com>")
+
+		want := "From: Test <test@example.com>\nSubject: Hello world!\nMessage-Id: <42@example.com>\r\n\nHello world!"
+		if got := readRaw(t, m); got != want {
+			t.Errorf("Reader() = %#v, want %#v", got, want)
+		}
+		if m.Size() != int64(len(want)) {
+			t.Errorf("Size() = %v, want %v", m.Size(), len(want))
+		}
+		if m.MessageID() != "42@example.com" {
+			t.Errorf("MessageID() = %#v, want %#v", m.MessageID(), "42@example.com")
+		}
+	})
+
+	t.Run("fields are prepended to an unparsed message", func(t *testing.T) {
+		m := NewMessage("", nil, nil, nil, strings.NewReader(simpleMessage))
+		m.Header().Add("X-Foo", "bar")
+
+		if got, want := readRaw(t, m), "X-Foo: bar\r\n"+simpleMessage; got != want {
+			t.Errorf("Reader() = %#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("message without header", func(t *testing.T) {
+		m := &Message{}
+		if m.Header() == nil || m.Size() != 0 || readRaw(t, m) != "" {
+			t.Error("empty message expected to have an empty header and no content")
+		}
+	})
+}
+
+func TestMessage_Envelope(t *testing.T) {
+	msg := NewMessage("from@example.com", []string{"to@example.com"}, []string{"cc@example.com"}, []string{"bcc@example.com"}, nil)
+	msg.returnPath = "bounces@example.com"
+
+	want := Envelope{
+		From:       "from@example.com",
+		ReturnPath: "bounces@example.com",
+		To:         []string{"to@example.com"},
+		Cc:         []string{"cc@example.com"},
+		Bcc:        []string{"bcc@example.com"},
+	}
+	if got := msg.Envelope(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Message.Envelope() = %#v, want %#v", got, want)
+	}
+
+	parsed, err := ParseMessage(strings.NewReader("Subject: test\r\n\r\nbody"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed.SetEnvelope(want)
+	if got := parsed.Envelope(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Message.SetEnvelope() = %#v, want %#v", got, want)
+	}
+}
+
+func TestEnvelope_Without(t *testing.T) {
+	e := Envelope{
+		From: "from@example.com",
+		To:   []string{"to1@example.com", "to2@example.com"},
+		Cc:   []string{"cc@example.com"},
+		Bcc:  []string{"bcc@example.com"},
+	}
+
+	want := Envelope{
+		From: "from@example.com",
+		To:   []string{"to2@example.com"},
+		Bcc:  []string{"bcc@example.com"},
+	}
+	if got := e.Without([]string{"to1@example.com", "cc@example.com"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Envelope.Without() = %#v, want %#v", got, want)
+	}
+}
+
+func TestMessage_WithHeader(t *testing.T) {
+	msg, err := ParseMessage(strings.NewReader("Subject: test\r\n\r\nbody"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.SetEnvelope(Envelope{From: "from@example.com", To: []string{"to@example.com"}})
+
+	header := &Header{}
+	header.Add("Subject", "other")
+	c := msg.WithHeader(header)
+
+	buf := &strings.Builder{}
+	if _, err := c.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Subject: other\r\n\r\nbody"; buf.String() != want {
+		t.Errorf("Message.WithHeader() = %#v, want %#v", buf.String(), want)
+	}
+	if !reflect.DeepEqual(c.Envelope(), msg.Envelope()) {
+		t.Errorf("Message.WithHeader() envelope = %#v, want %#v", c.Envelope(), msg.Envelope())
+	}
+	if msg.Header().Get("Subject") != "test" {
+		t.Error("Message.WithHeader() must not change the message header")
+	}
+}
@@ -0,0 +1,247 @@
+package converter
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"sort"
+	"time"
+)
+
+// base64LineLength is the maximum encoded line length as per RFC 2045
+const base64LineLength = 76
+
+// Attachment is a file attached to a built message. Its content is read
+// as the message is built.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Content     io.Reader
+}
+
+// Parts holds the content of a message to build
+type Parts struct {
+	Text, HTML  string
+	Attachments []Attachment
+}
+
+// reservedHeaders are the headers managed by the message builder
+var reservedHeaders = map[string]bool{
+	"Bcc":                       true,
+	"Cc":                        true,
+	"Content-Transfer-Encoding": true,
+	"Content-Type":              true,
+	"Date":                      true,
+	"From":                      true,
+	"Mime-Version":              true,
+	"Subject":                   true,
+	"To":                        true,
+}
+
+// buildMessage writes a MIME message built from the given headers and parts.
+// Text and HTML alternatives are quoted-printable encoded while attachments
+// are base64 encoded. The message is streamed to the writer as it is built.
+func buildMessage(w io.Writer, header textproto.MIMEHeader, subject string, parts Parts) error {
+	header = cloneHeader(header)
+	header.Set("Date", time.Now().Format(time.RFC1123Z))
+	header.Set("MIME-Version", "1.0")
+
+	if subject != "" {
+		header.Set("Subject", mime.QEncoding.Encode("utf-8", subject))
+	}
+
+	var textParts []textPart
+
+	if parts.Text != "" || parts.HTML == "" {
+		textParts = append(textParts, textPart{"text/plain", parts.Text})
+	}
+	if parts.HTML != "" {
+		textParts = append(textParts, textPart{"text/html", parts.HTML})
+	}
+
+	// The text content header: either a single text part or alternatives
+	var (
+		content     textproto.MIMEHeader
+		altBoundary string
+	)
+
+	if len(textParts) == 1 {
+		content = textproto.MIMEHeader{
+			"Content-Type":              {textParts[0].contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		}
+	} else {
+		altBoundary = multipart.NewWriter(io.Discard).Boundary()
+		content = textproto.MIMEHeader{
+			"Content-Type": {"multipart/alternative; boundary=" + altBoundary},
+		}
+	}
+
+	if len(parts.Attachments) == 0 {
+		for k, v := range content {
+			header[k] = v
+		}
+
+		if err := writeHeader(w, header); err != nil {
+			return err
+		}
+		return writeText(w, textParts, altBoundary)
+	}
+
+	mixed := multipart.NewWriter(w)
+	header.Set("Content-Type", "multipart/mixed; boundary="+mixed.Boundary())
+
+	if err := writeHeader(w, header); err != nil {
+		return err
+	}
+
+	pw, err := mixed.CreatePart(content)
+	if err != nil {
+		return err
+	}
+
+	if err := writeText(pw, textParts, altBoundary); err != nil {
+		return err
+	}
+
+	for _, a := range parts.Attachments {
+		if err := writeAttachment(mixed, a); err != nil {
+			return err
+		}
+	}
+
+	return mixed.Close()
+}
+
+type textPart struct {
+	contentType, content string
+}
+
+// writeText writes the given text parts: as a single quoted-printable
+// part when there is no boundary, as multipart alternatives otherwise
+func writeText(w io.Writer, parts []textPart, boundary string) error {
+	if boundary == "" {
+		qp := quotedprintable.NewWriter(w)
+		if _, err := io.WriteString(qp, parts[0].content); err != nil {
+			return err
+		}
+		return qp.Close()
+	}
+
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+
+	for _, p := range parts {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return err
+		}
+
+		qp := quotedprintable.NewWriter(pw)
+		if _, err := io.WriteString(qp, p.content); err != nil {
+			return err
+		}
+		if err := qp.Close(); err != nil {
+			return err
+		}
+	}
+
+	return mw.Close()
+}
+
+func writeAttachment(mw *multipart.Writer, a Attachment) error {
+	contentType := a.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	disposition := "attachment"
+	if a.Filename != "" {
+		disposition = mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})
+	}
+
+	pw, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Disposition":       {disposition},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Encodes the content line by line so it's not copied as a whole
+	chunk := make([]byte, base64.StdEncoding.DecodedLen(base64LineLength))
+	line := make([]byte, base64LineLength, base64LineLength+2)
+
+	for {
+		n, err := readChunk(a.Content, chunk)
+		if n > 0 {
+			encoded := line[:base64.StdEncoding.EncodedLen(n)]
+			base64.StdEncoding.Encode(encoded, chunk[:n])
+
+			if _, err := pw.Write(append(encoded, '\r', '\n')); err != nil {
+				return err
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("attachment %#v: %w", a.Filename, err)
+		}
+	}
+}
+
+// readChunk fills the chunk from r unless r ends before. Unlike io.ReadFull,
+// the errors of r are returned as is.
+func readChunk(r io.Reader, chunk []byte) (int, error) {
+	n := 0
+	for n < len(chunk) {
+		m, err := r.Read(chunk[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// writeHeader writes the given header fields sorted by name,
+// followed by the blank line separating them from the body
+func writeHeader(w io.Writer, header textproto.MIMEHeader) error {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		for _, v := range header[k] {
+			if _, err := fmt.Fprintf(w, "%s: %s\r\n", k, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, "\r\n")
+	return err
+}
+
+func cloneHeader(h textproto.MIMEHeader) textproto.MIMEHeader {
+	clone := make(textproto.MIMEHeader, len(h))
+	for k, v := range h {
+		clone[k] = append([]string(nil), v...)
+	}
+	return clone
+}
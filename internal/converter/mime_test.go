@@ -0,0 +1,105 @@
+package converter
+
+import (
+	"bytes"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func Test_buildMessage(t *testing.T) {
+	tests := []struct {
+		name          string
+		subject       string
+		parts         Parts
+		wantType      string
+		wantEncoding  string
+		wantInBody    string
+		wantNoSubject bool
+	}{
+		{
+			name:          "empty message is an empty text part",
+			parts:         Parts{},
+			wantType:      "text/plain",
+			wantEncoding:  "quoted-printable",
+			wantNoSubject: true,
+		},
+		{
+			name:         "text only",
+			subject:      "Hello",
+			parts:        Parts{Text: "Hello world!"},
+			wantType:     "text/plain",
+			wantEncoding: "quoted-printable",
+			wantInBody:   "Hello world!",
+		},
+		{
+			name:         "html only",
+			subject:      "Hello",
+			parts:        Parts{HTML: "<p>Hello world!</p>"},
+			wantType:     "text/html",
+			wantEncoding: "quoted-printable",
+			wantInBody:   "<p>Hello world!</p>",
+		},
+		{
+			name:       "text and html",
+			subject:    "Hello",
+			parts:      Parts{Text: "Hello world!", HTML: "<p>Hello world!</p>"},
+			wantType:   "multipart/alternative",
+			wantInBody: "Content-Type: text/html; charset=utf-8",
+		},
+		{
+			name:    "attachment without name nor type",
+			subject: "Hello",
+			parts: Parts{
+				Text:        "Hello world!",
+				Attachments: []Attachment{{Content: bytes.NewReader(bytes.Repeat([]byte("a"), 100))}},
+			},
+			wantType:   "multipart/mixed",
+			wantInBody: "Content-Disposition: attachment\r\nContent-Transfer-Encoding: base64\r\nContent-Type: application/octet-stream",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &bytes.Buffer{}
+			header := textproto.MIMEHeader{"From": {"test@example.com"}}
+
+			if err := buildMessage(w, header, tt.subject, tt.parts); err != nil {
+				t.Fatalf("buildMessage() error = %v", err)
+			}
+
+			if len(header) != 1 {
+				t.Errorf("buildMessage() modified the given header: %#v", header)
+			}
+
+			m, err := mail.ReadMessage(w)
+			if err != nil {
+				t.Fatalf("built message parsing failed: %v", err)
+			}
+
+			if m.Header.Get("Date") == "" || m.Header.Get("MIME-Version") != "1.0" {
+				t.Errorf("buildMessage() headers = %#v, want Date and MIME-Version", m.Header)
+			}
+
+			if got := m.Header.Get("Subject"); (got == "") != tt.wantNoSubject {
+				t.Errorf("buildMessage() subject = %#v, want %#v", got, tt.subject)
+			}
+
+			if mt, _, _ := mime.ParseMediaType(m.Header.Get("Content-Type")); mt != tt.wantType {
+				t.Errorf("buildMessage() content type = %#v, want %#v", mt, tt.wantType)
+			}
+
+			if got := m.Header.Get("Content-Transfer-Encoding"); got != tt.wantEncoding {
+				t.Errorf("buildMessage() encoding = %#v, want %#v", got, tt.wantEncoding)
+			}
+
+			body := &bytes.Buffer{}
+			(body.ReadFrom(m.Body))
+
+			if !strings.Contains(body.String(), tt.wantInBody) {
+				t.Errorf("buildMessage() body = %#v, want it to contain %#v", body.String(), tt.wantInBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,528 @@
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+)
+
+// MaxLineLength is the max length in octets of a message line, CRLF excluded.
+// See: https://www.rfc-editor.org/rfc/rfc5321#section-4.5.3.1.6
+const MaxLineLength = 998
+
+// Normalizer makes the messages safe to relay over SMTP: lines end with CRLF,
+// are at most MaxLineLength octets long and carry no NUL byte. Long header
+// lines are folded and the body parts with long lines are quoted-printable
+// encoded. In strict mode, the messages that need to be fixed are rejected.
+type Normalizer struct {
+	spooler *Spooler
+	strict  bool
+}
+
+// NewNormalizer returns a new message normalizer
+func NewNormalizer(spooler *Spooler, strict bool) *Normalizer {
+	return &Normalizer{spooler: spooler, strict: strict}
+}
+
+// Normalize returns the normalized message. The given message is returned as
+// it is when it needs no fix, otherwise it is closed and replaced by a new one
+// with the same envelope.
+func (n *Normalizer) Normalize(m *Message) (*Message, error) {
+	if m == nil {
+		return nil, nil
+	}
+
+	// The message is scanned first so the messages that don't need to be
+	// fixed (the common case) are not copied
+	scan := &normalization{lines: newLineReader(m.Reader()), reencode: map[int]bool{}}
+	if _, err := scan.entity(nil); err != nil {
+		return nil, err
+	}
+
+	if scan.issue == "" {
+		return m, nil
+	}
+
+	if n.strict {
+		return nil, &Error{
+			Kind: KindUnprocessable,
+			Err:  fmt.Errorf("message is not normalized: %s", scan.issue),
+		}
+	}
+
+	raw, err := n.spooler.SpoolFunc(func(w io.Writer) error {
+		bw := bufio.NewWriter(w)
+		fix := &normalization{lines: newLineReader(m.Reader()), w: bw, reencode: scan.reencode}
+		if _, err := fix.entity(nil); err != nil {
+			return err
+		}
+		return bw.Flush()
+	})
+	if err != nil {
+		return nil, WrapError(KindUnprocessable, err)
+	}
+
+	normalized, err := ParseMessage(raw)
+	if err != nil {
+		(raw.Close())
+		return nil, fmt.Errorf("failed to parse normalized message: %w", err)
+	}
+
+	normalized.from, normalized.returnPath = m.from, m.returnPath
+	normalized.to, normalized.cc, normalized.bcc = m.to, m.cc, m.bcc
+
+	(m.Close())
+
+	return normalized, nil
+}
+
+// eol is a line ending
+type eol int
+
+const (
+	eolNone eol = iota // end of input
+	eolCRLF
+	eolLF
+	eolCR
+)
+
+type line struct {
+	text []byte
+	eol  eol
+	// n is the line number
+	n int
+}
+
+// lineReader reads lines ending with CRLF, bare LF or bare CR
+type lineReader struct {
+	r     *bufio.Reader
+	queue []*line
+	n     int
+}
+
+func newLineReader(r io.Reader) *lineReader {
+	return &lineReader{r: bufio.NewReader(r)}
+}
+
+// next returns the next line, nil at the end of the input
+func (lr *lineReader) next() (*line, error) {
+	if len(lr.queue) == 0 {
+		b, err := lr.r.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if len(b) == 0 {
+			return nil, nil
+		}
+
+		ending := eolNone
+		switch {
+		case bytes.HasSuffix(b, []byte("\r\n")):
+			b, ending = b[:len(b)-2], eolCRLF
+		case bytes.HasSuffix(b, []byte("\n")):
+			b, ending = b[:len(b)-1], eolLF
+		}
+
+		// Bare CRs end lines too
+		segments := bytes.Split(b, []byte("\r"))
+		for i, s := range segments {
+			l := &line{text: s, eol: eolCR}
+			if i == len(segments)-1 {
+				if len(s) == 0 && ending == eolNone {
+					break
+				}
+				l.eol = ending
+			}
+			lr.queue = append(lr.queue, l)
+		}
+	}
+
+	l := lr.queue[0]
+	lr.queue = lr.queue[1:]
+	lr.n++
+	l.n = lr.n
+	return l, nil
+}
+
+// normalization walks a message MIME tree. It only records the issues found
+// when it has no writer, otherwise it writes the normalized message.
+type normalization struct {
+	lines *lineReader
+	w     io.Writer
+	// leaf is the index of the next leaf part, depth-first
+	leaf int
+	// reencode holds the index of the leaf parts having long lines
+	// or, in 7-bit mode, 8-bit octets
+	reencode map[int]bool
+	// sevenBit enables the encoding of the 8-bit leaf parts
+	sevenBit bool
+	// issue describes the first issue found
+	issue string
+	// eightBitHeader and eightBitBody are true when 8-bit octets
+	// are found in the header fields or the body
+	eightBitHeader, eightBitBody bool
+}
+
+// entity normalizes an entity (the message or one of its parts) delimited by
+// the boundaries of its ancestors. It returns the line that ended it: an
+// ancestor boundary delimiter, or nil at the end of the input.
+func (n *normalization) entity(parents []string) (*line, error) {
+	fields, separator, end, err := n.header(parents)
+	if err != nil || separator == nil {
+		return end, err
+	}
+
+	var contentType, encoding string
+	for _, f := range fields {
+		key, value := f.unfold()
+		switch key {
+		case "Content-Type":
+			contentType = value
+		case "Content-Transfer-Encoding":
+			encoding = strings.ToLower(value)
+		}
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if boundary := params["boundary"]; strings.HasPrefix(mediaType, "multipart/") && boundary != "" {
+		if err := n.writeFields(fields, "", separator); err != nil {
+			return nil, err
+		}
+		return n.multipart(parents, boundary)
+	}
+
+	index := n.leaf
+	n.leaf++
+
+	if n.w == nil || !n.reencode[index] {
+		if err := n.writeFields(fields, "", separator); err != nil {
+			return nil, err
+		}
+		return n.body(parents, index, mediaType, nil)
+	}
+
+	// Long lines can only be fixed by (re-)encoding the part
+	var enc io.WriteCloser
+	switch encoding {
+	case "base64":
+		enc = &base64Wrapper{w: n.w}
+	case "quoted-printable":
+		enc = newQPReencoder(n.w)
+	default:
+		enc = quotedprintable.NewWriter(n.w)
+		encoding = "quoted-printable"
+	}
+
+	if err := n.writeFields(fields, encoding, separator); err != nil {
+		return nil, err
+	}
+	return n.body(parents, index, mediaType, enc)
+}
+
+// field is a header field: its first line and its continuation lines
+type field []*line
+
+func (f field) unfold() (string, string) {
+	var b bytes.Buffer
+	for _, l := range f {
+		b.Write(l.text)
+	}
+
+	key, value, _ := strings.Cut(b.String(), ":")
+	return textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(key)), strings.TrimSpace(value)
+}
+
+// header reads the header fields of an entity and its separator line. The
+// separator is nil if the input or the entity ended before.
+func (n *normalization) header(parents []string) ([]field, *line, *line, error) {
+	var fields []field
+
+	for {
+		l, err := n.lines.next()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if l == nil {
+			return fields, nil, nil, n.writeFields(fields, "", nil)
+		}
+
+		switch {
+		case isDelimiter(l.text, parents...):
+			if err := n.writeFields(fields, "", nil); err != nil {
+				return nil, nil, nil, err
+			}
+			return fields, nil, l, nil
+		case len(l.text) == 0:
+			return fields, l, nil, nil
+		case (l.text[0] == ' ' || l.text[0] == '\t') && len(fields) > 0:
+			fields[len(fields)-1] = append(fields[len(fields)-1], l)
+		default:
+			fields = append(fields, field{l})
+		}
+	}
+}
+
+// writeFields checks and writes the given header fields. The
+// Content-Transfer-Encoding field is replaced when an encoding is given.
+func (n *normalization) writeFields(fields []field, encoding string, separator *line) error {
+	for _, f := range fields {
+		if key, _ := f.unfold(); encoding != "" && key == "Content-Transfer-Encoding" {
+			continue
+		}
+		for _, l := range f {
+			n.check(l)
+			n.eightBitHeader = n.eightBitHeader || is8Bit(l.text)
+			if err := n.writeFolded(l.text); err != nil {
+				return err
+			}
+		}
+	}
+
+	if encoding != "" {
+		n.write([]byte("Content-Transfer-Encoding: " + encoding))
+	}
+
+	if separator != nil {
+		n.check(separator)
+		n.write(nil)
+	}
+	return nil
+}
+
+// multipart normalizes the body of a multipart entity
+func (n *normalization) multipart(parents []string, boundary string) (*line, error) {
+	closed := false
+
+	l, err := n.lines.next()
+	for err == nil && l != nil {
+		if !closed && isDelimiter(l.text, boundary) {
+			n.check(l)
+			n.write(l.text)
+
+			if closed = isCloseDelimiter(l.text, boundary); !closed {
+				// The next part ends with a delimiter that is processed
+				// at the next iteration
+				l, err = n.entity(append(parents, boundary))
+				continue
+			}
+		} else {
+			if isDelimiter(l.text, parents...) {
+				return l, nil
+			}
+
+			// Preamble and epilogue lines are ignored by the readers,
+			// they are split when too long
+			n.check(l)
+			n.eightBitBody = n.eightBitBody || is8Bit(l.text)
+			text := l.text
+			for len(text) > MaxLineLength {
+				n.write(text[:MaxLineLength])
+				text = text[MaxLineLength:]
+			}
+			n.write(text)
+		}
+
+		l, err = n.lines.next()
+	}
+	return nil, err
+}
+
+// body normalizes the body of a leaf part. Its lines are written to the given
+// encoder if any.
+func (n *normalization) body(parents []string, index int, mediaType string, enc io.WriteCloser) (*line, error) {
+	first := true
+
+	l, err := n.lines.next()
+	for ; err == nil && l != nil; l, err = n.lines.next() {
+		if isDelimiter(l.text, parents...) {
+			break
+		}
+
+		eightBit := is8Bit(l.text)
+		n.eightBitBody = n.eightBitBody || eightBit
+
+		if n.w == nil && (len(l.text) > MaxLineLength || (n.sevenBit && eightBit)) {
+			// Composite media types cannot be encoded
+			if strings.HasPrefix(mediaType, "message/") {
+				return nil, &Error{
+					Kind: KindUnprocessable,
+					Err:  fmt.Errorf("line %d: %s part line cannot be encoded", l.n, mediaType),
+				}
+			}
+			n.reencode[index] = true
+		}
+		n.check(l)
+
+		if enc == nil {
+			n.write(l.text)
+			continue
+		}
+
+		if !first {
+			if _, err := enc.Write([]byte("\r\n")); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := enc.Write(bytes.ReplaceAll(l.text, []byte{0}, nil)); err != nil {
+			return nil, err
+		}
+		first = false
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if enc != nil {
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+		// The line break before a delimiter belongs to the delimiter
+		n.write(nil)
+	}
+	return l, nil
+}
+
+// check records the first issue found
+func (n *normalization) check(l *line) {
+	if n.issue != "" {
+		return
+	}
+
+	switch {
+	case l.eol == eolLF:
+		n.issue = fmt.Sprintf("line %d: bare LF", l.n)
+	case l.eol == eolCR:
+		n.issue = fmt.Sprintf("line %d: bare CR", l.n)
+	case bytes.IndexByte(l.text, 0) >= 0:
+		n.issue = fmt.Sprintf("line %d: NUL byte", l.n)
+	case len(l.text) > MaxLineLength:
+		n.issue = fmt.Sprintf("line %d: longer than %d octets", l.n, MaxLineLength)
+	}
+}
+
+// write writes the given line text, without its NUL bytes, and a CRLF
+func (n *normalization) write(text []byte) {
+	if n.w == nil {
+		return
+	}
+	(n.w.Write(bytes.ReplaceAll(text, []byte{0}, nil)))
+	(n.w.Write([]byte("\r\n")))
+}
+
+// writeFolded writes a header line, folded at its whitespaces when too long
+func (n *normalization) writeFolded(text []byte) error {
+	if n.w == nil {
+		return nil
+	}
+
+	for len(text) > MaxLineLength {
+		i := bytes.LastIndexAny(text[:MaxLineLength+1], " \t")
+		if i <= 0 || len(bytes.TrimLeft(text[:i], " \t")) == 0 {
+			return &Error{
+				Kind: KindUnprocessable,
+				Err:  fmt.Errorf("header line %#v... is too long to be folded", string(text[:32])),
+			}
+		}
+		n.write(text[:i])
+		text = text[i:]
+	}
+
+	n.write(text)
+	return nil
+}
+
+// is8Bit returns true if the text has octets above 127
+func is8Bit(text []byte) bool {
+	for _, c := range text {
+		if c > 127 {
+			return true
+		}
+	}
+	return false
+}
+
+// isDelimiter returns true if the text is a delimiter line of one of the
+// given boundaries
+func isDelimiter(text []byte, boundaries ...string) bool {
+	for _, b := range boundaries {
+		if rest, ok := bytes.CutPrefix(text, []byte("--"+b)); ok {
+			rest = bytes.TrimSuffix(bytes.TrimRight(rest, " \t"), []byte("--"))
+			if len(bytes.TrimRight(rest, " \t")) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isCloseDelimiter(text []byte, boundary string) bool {
+	return bytes.HasPrefix(bytes.TrimRight(text, " \t"), []byte("--"+boundary+"--"))
+}
+
+// base64Wrapper rewrites base64 content in 76 characters lines
+type base64Wrapper struct {
+	w   io.Writer
+	col int
+}
+
+func (b *base64Wrapper) Write(p []byte) (int, error) {
+	for _, c := range p {
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			continue
+		}
+		if b.col == 76 {
+			if _, err := b.w.Write([]byte("\r\n")); err != nil {
+				return 0, err
+			}
+			b.col = 0
+		}
+		if _, err := b.w.Write([]byte{c}); err != nil {
+			return 0, err
+		}
+		b.col++
+	}
+	return len(p), nil
+}
+
+func (b *base64Wrapper) Close() error {
+	return nil
+}
+
+// qpReencoder decodes quoted-printable content and encodes it again
+type qpReencoder struct {
+	*io.PipeWriter
+	done chan error
+}
+
+func newQPReencoder(w io.Writer) *qpReencoder {
+	pr, pw := io.Pipe()
+	r := &qpReencoder{PipeWriter: pw, done: make(chan error, 1)}
+
+	go func() {
+		qpw := quotedprintable.NewWriter(w)
+		_, err := io.Copy(qpw, quotedprintable.NewReader(pr))
+		if err == nil {
+			err = qpw.Close()
+		}
+		(pr.CloseWithError(err))
+		r.done <- err
+	}()
+
+	return r
+}
+
+func (r *qpReencoder) Close() error {
+	(r.PipeWriter.Close())
+	return <-r.done
+}
@@ -0,0 +1,280 @@
+package converter
+
+import (
+	"io"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestNormalizer_Normalize(t *testing.T) {
+	long := strings.Repeat("a", 1500)
+
+	// The subject is folded at the last whitespace of its first 998 octets
+	subject := "Subject: " + strings.Repeat("word ", 300)
+	fold := strings.LastIndex(subject[:MaxLineLength+1], " ")
+
+	// The base64 content is written in 76 characters lines
+	b64 := strings.Repeat("QUJD", 300)
+	var b64Lines []string
+	for rest := b64; rest != ""; {
+		n := 76
+		if len(rest) < n {
+			n = len(rest)
+		}
+		b64Lines, rest = append(b64Lines, rest[:n]), rest[n:]
+	}
+
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "LF line endings",
+			raw:  "From: test@example.com\nSubject: Hello\n\nHello\nworld!\n",
+			want: "From: test@example.com\r\nSubject: Hello\r\n\r\nHello\r\nworld!\r\n",
+		},
+		{
+			name: "bare CR and NUL bytes",
+			raw:  "From: test@example.com\r\n\r\nHello\rworld\x00!",
+			want: "From: test@example.com\r\n\r\nHello\r\nworld!\r\n",
+		},
+		{
+			name: "message without body",
+			raw:  "From: test@example.com\nSubject: Hello",
+			want: "From: test@example.com\r\nSubject: Hello\r\n",
+		},
+		{
+			name: "long header line",
+			raw:  "From: test@example.com\r\n" + subject + "\r\n\r\nHello",
+			want: "From: test@example.com\r\n" + subject[:fold] + "\r\n" + subject[fold:] + "\r\n\r\nHello\r\n",
+		},
+		{
+			name:    "long header line without whitespace",
+			raw:     "From: test@example.com\r\nX-Long: " + long + "\r\n\r\nHello",
+			wantErr: true,
+		},
+		{
+			name: "long body line",
+			raw:  "From: test@example.com\r\nContent-Transfer-Encoding: 8bit\r\n\r\n" + long + "\r\nHello",
+			want: "From: test@example.com\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n" + qpEncode(long+"\r\nHello") + "\r\n",
+		},
+		{
+			name: "long base64 body line",
+			raw:  "From: test@example.com\r\nContent-Transfer-Encoding: base64\r\n\r\n" + b64 + "\r\n",
+			want: "From: test@example.com\r\nContent-Transfer-Encoding: base64\r\n\r\n" + strings.Join(b64Lines, "\r\n") + "\r\n",
+		},
+		{
+			name: "long quoted-printable body line",
+			raw:  "From: test@example.com\r\nContent-Transfer-Encoding: Quoted-Printable\r\n\r\n" + strings.Repeat("caf=C3=A9 ", 150) + "\r\n",
+			want: "From: test@example.com\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n" + qpEncode(strings.TrimSpace(strings.Repeat("café ", 150))) + "\r\n",
+		},
+		{
+			name:    "long message/rfc822 part line",
+			raw:     "Content-Type: message/rfc822\r\n\r\n" + long,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseMessage(strings.NewReader(tt.raw))
+			if err != nil {
+				t.Fatalf("ParseMessage() error = %v", err)
+			}
+
+			got, err := NewNormalizer(testSpooler, false).Normalize(m)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Normalize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if KindOf(err) != KindUnprocessable {
+					t.Errorf("Normalize() error kind = %v, want %v", KindOf(err), KindUnprocessable)
+				}
+				return
+			}
+			defer got.Close()
+
+			if raw := readRaw(t, got); raw != tt.want {
+				t.Errorf("Normalize() = %#v, want %#v", raw, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizer_Normalize_multipart(t *testing.T) {
+	html := "<p>" + strings.Repeat("Hello world! ", 100) + "</p>"
+	raw := "From: test@example.com\n" +
+		"Content-Type: multipart/mixed; boundary=outer\n" +
+		"\n" +
+		"preamble " + strings.Repeat("p", 1000) + "\n" +
+		"--outer\n" +
+		"Content-Type: multipart/alternative; boundary=\"inner\"\n" +
+		"\n" +
+		"--inner\n" +
+		"Content-Type: text/plain\n" +
+		"\n" +
+		"Hello world!\n" +
+		"--inner\n" +
+		"Content-Type: text/html\n" +
+		"Content-Transfer-Encoding:\n" +
+		" 8bit\n" +
+		"\n" +
+		html + "\n" +
+		"--inner--\n" +
+		"--outer\n" +
+		"Content-Type: text/plain; name=a.txt\n" +
+		"Content-Transfer-Encoding: base64\n" +
+		"\n" +
+		"aGVsbG8=\n" +
+		"--outer--\n" +
+		"epilogue\n"
+
+	m, err := ParseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ParseMessage() error = %v", err)
+	}
+	m.from, m.returnPath, m.to = "test@example.com", "bounce@example.com", []string{"bob@example.com"}
+
+	got, err := NewNormalizer(testSpooler, false).Normalize(m)
+	if err != nil {
+		t.Fatalf("Normalize() error = %v", err)
+	}
+	defer got.Close()
+
+	if got.From() != m.From() || got.ReturnPath() != m.ReturnPath() || len(got.To()) != 1 {
+		t.Errorf("Normalize() envelope = %#v, want %#v", got, m)
+	}
+
+	normalized := readRaw(t, got)
+	for i, l := range strings.Split(strings.TrimSuffix(normalized, "\r\n"), "\r\n") {
+		if len(l) > MaxLineLength || strings.ContainsAny(l, "\r\n") {
+			t.Errorf("line %d is not normalized: %#v", i+1, l)
+		}
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(normalized))
+	if err != nil {
+		t.Fatalf("mail.ReadMessage() error = %v", err)
+	}
+
+	var contents []string
+	outer := multipart.NewReader(msg.Body, "outer")
+	for {
+		p, err := outer.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("NextPart() error = %v", err)
+		}
+
+		if p.Header.Get("Content-Type") != "text/plain; name=a.txt" {
+			inner := multipart.NewReader(p, "inner")
+			for {
+				ip, err := inner.NextPart()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("NextPart() error = %v", err)
+				}
+				// The multipart reader decodes quoted-printable
+				b, _ := io.ReadAll(ip)
+				contents = append(contents, string(b))
+			}
+			continue
+		}
+
+		b, _ := io.ReadAll(p)
+		contents = append(contents, string(b))
+	}
+
+	want := []string{"Hello world!", html, "aGVsbG8="}
+	if strings.Join(contents, "|") != strings.Join(want, "|") {
+		t.Errorf("Normalize() parts = %#v, want %#v", contents, want)
+	}
+	if !strings.Contains(normalized, "\r\nepilogue\r\n") {
+		t.Errorf("Normalize() lost the epilogue: %#v", normalized)
+	}
+}
+
+func TestNormalizer_Normalize_unchanged(t *testing.T) {
+	m, err := ParseMessage(strings.NewReader("From: test@example.com\r\n\r\nHello\r\n"))
+	if err != nil {
+		t.Fatalf("ParseMessage() error = %v", err)
+	}
+
+	for _, strict := range []bool{false, true} {
+		got, err := NewNormalizer(testSpooler, strict).Normalize(m)
+		if err != nil || got != m {
+			t.Errorf("Normalize() = %v, %v, want the given message", got, err)
+		}
+	}
+
+	if got, err := NewNormalizer(testSpooler, false).Normalize(nil); got != nil || err != nil {
+		t.Errorf("Normalize(nil) = %v, %v, want nil", got, err)
+	}
+}
+
+func TestNormalizer_Normalize_strict(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       string
+		wantIssue string
+	}{
+		{"bare LF", "From: test@example.com\r\n\r\nHello\nworld", "line 3: bare LF"},
+		{"bare CR", "From: test@example.com\r\n\r\nHello\rworld", "line 3: bare CR"},
+		{"NUL byte", "From: test@example.com\r\n\r\nHello\x00", "line 3: NUL byte"},
+		{"long line", "From: test@example.com\r\n\r\n" + strings.Repeat("a", 999), "line 3: longer than 998 octets"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseMessage(strings.NewReader(tt.raw))
+			if err != nil {
+				t.Fatalf("ParseMessage() error = %v", err)
+			}
+
+			_, err = NewNormalizer(testSpooler, true).Normalize(m)
+			if err == nil || KindOf(err) != KindUnprocessable {
+				t.Fatalf("Normalize() error = %v, want an unprocessable error", err)
+			}
+			if !strings.HasSuffix(err.Error(), tt.wantIssue) {
+				t.Errorf("Normalize() error = %v, want %#v", err, tt.wantIssue)
+			}
+		})
+	}
+}
+
+func Test_isDelimiter(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"--b", true},
+		{"--b  ", true},
+		{"--b--", true},
+		{"--b-- \t", true},
+		{"--b2", false},
+		{"-b", false},
+		{"--c", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := isDelimiter([]byte(tt.text), "b", "c"); got != tt.want {
+				t.Errorf("isDelimiter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func qpEncode(s string) string {
+	b := &strings.Builder{}
+	w := quotedprintable.NewWriter(b)
+	(w.Write([]byte(s)))
+	(w.Close())
+	return b.String()
+}
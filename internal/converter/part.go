@@ -0,0 +1,123 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// Part is a node of a message MIME tree
+type Part struct {
+	Header textproto.MIMEHeader
+	// MediaType is the lower-cased media type, text/plain when not specified
+	MediaType string
+	// Params holds the Content-Type parameters
+	Params map[string]string
+	// Disposition is the Content-Disposition value, if any
+	Disposition string
+	// Filename is the part file name, if any
+	Filename string
+	// Size is the part encoded body size in bytes
+	Size int64
+	// Parts holds the sub-parts of a multipart
+	Parts []*Part
+}
+
+// Parts parses the message body and returns the root of its MIME tree
+func (m *Message) Parts() (*Part, error) {
+	header := textproto.MIMEHeader{}
+	for _, f := range m.Header().fields {
+		header.Add(f.Key, strings.TrimSpace(f.Value))
+	}
+
+	return parsePart(header, m.Body())
+}
+
+// Walk calls the given func for the part and each of its descendants, depth-first
+func (p *Part) Walk(fn func(*Part)) {
+	fn(p)
+	for _, child := range p.Parts {
+		child.Walk(fn)
+	}
+}
+
+// IsAttachment returns true if the part is an attachment
+func (p *Part) IsAttachment() bool {
+	return strings.EqualFold(p.Disposition, "attachment") ||
+		(p.Filename != "" && !strings.HasPrefix(p.MediaType, "multipart/"))
+}
+
+func parsePart(header textproto.MIMEHeader, body io.Reader) (*Part, error) {
+	p := &Part{Header: header}
+
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content type %#v: %w", contentType, err)
+	}
+	p.MediaType, p.Params = mediaType, params
+
+	if cd := header.Get("Content-Disposition"); cd != "" {
+		if disposition, dparams, err := mime.ParseMediaType(cd); err == nil {
+			p.Disposition, p.Filename = disposition, dparams["filename"]
+		}
+	}
+
+	if p.Filename == "" {
+		p.Filename = params["name"]
+	}
+
+	cr := &countingReader{r: body}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		boundary := params["boundary"]
+		if boundary == "" {
+			return nil, fmt.Errorf("%s part has no boundary", mediaType)
+		}
+
+		mr := multipart.NewReader(cr, boundary)
+		for {
+			raw, err := mr.NextRawPart()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s part: %w", mediaType, err)
+			}
+
+			child, err := parsePart(raw.Header, raw)
+			if err != nil {
+				return nil, err
+			}
+			p.Parts = append(p.Parts, child)
+		}
+	}
+
+	// Reads what's left (e.g. the multipart epilogue) to know the part size
+	if _, err := io.Copy(io.Discard, cr); err != nil {
+		return nil, err
+	}
+	p.Size = cr.n
+
+	return p, nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,137 @@
+package converter
+
+import (
+	"strings"
+	"testing"
+)
+
+const multipartMessage = "From: test@example.com\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"preamble\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"Hello\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>Hello</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf; name=doc.pdf\r\n" +
+	"Content-Disposition: attachment; filename=\"report.pdf\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"aGVsbG8=\r\n" +
+	"--outer--\r\n"
+
+func TestMessage_Parts(t *testing.T) {
+	t.Run("single part message", func(t *testing.T) {
+		m, err := ParseMessage(strings.NewReader(simpleMessage))
+		if err != nil {
+			t.Fatalf("ParseMessage() error = %v", err)
+		}
+
+		got, err := m.Parts()
+		if err != nil {
+			t.Fatalf("Parts() error = %v", err)
+		}
+		if got.MediaType != "text/plain" || len(got.Parts) != 0 || got.IsAttachment() {
+			t.Errorf("Parts() = %#v, want a single text/plain part", got)
+		}
+		if got.Size != int64(len("Hello world!")) {
+			t.Errorf("Parts() size = %v, want %v", got.Size, len("Hello world!"))
+		}
+	})
+
+	t.Run("nested multipart message", func(t *testing.T) {
+		m, err := ParseMessage(strings.NewReader(multipartMessage))
+		if err != nil {
+			t.Fatalf("ParseMessage() error = %v", err)
+		}
+
+		root, err := m.Parts()
+		if err != nil {
+			t.Fatalf("Parts() error = %v", err)
+		}
+
+		var mediaTypes []string
+		var attachments []*Part
+		root.Walk(func(p *Part) {
+			mediaTypes = append(mediaTypes, p.MediaType)
+			if p.IsAttachment() {
+				attachments = append(attachments, p)
+			}
+		})
+
+		want := "multipart/mixed,multipart/alternative,text/plain,text/html,application/pdf"
+		if got := strings.Join(mediaTypes, ","); got != want {
+			t.Errorf("Walk() = %#v, want %#v", got, want)
+		}
+		if len(attachments) != 1 {
+			t.Fatalf("IsAttachment() matched %d parts, want 1", len(attachments))
+		}
+		if attachments[0].Filename != "report.pdf" || attachments[0].Disposition != "attachment" {
+			t.Errorf("attachment = %#v", attachments[0])
+		}
+		if attachments[0].Size != int64(len("aGVsbG8=")) {
+			t.Errorf("attachment size = %v, want %v", attachments[0].Size, len("aGVsbG8="))
+		}
+		if root.Params["boundary"] != "outer" {
+			t.Errorf("root params = %#v", root.Params)
+		}
+	})
+
+	errorTests := []struct {
+		name string
+		raw  string
+	}{
+		{
+			name: "missing boundary",
+			raw:  "Content-Type: multipart/mixed\r\n\r\nfoo",
+		},
+		{
+			name: "invalid content type",
+			raw:  "Content-Type: text/\r\n\r\nfoo",
+		},
+		{
+			name: "invalid nested part",
+			raw:  "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nContent-Type: ;\r\n\r\nfoo\r\n--b--\r\n",
+		},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseMessage(strings.NewReader(tt.raw))
+			if err != nil {
+				t.Fatalf("ParseMessage() error = %v", err)
+			}
+			if _, err := m.Parts(); err == nil {
+				t.Error("Parts() expected an error")
+			}
+		})
+	}
+}
+
+func TestPart_IsAttachment(t *testing.T) {
+	tests := []struct {
+		name string
+		part *Part
+		want bool
+	}{
+		{"inline text", &Part{MediaType: "text/plain"}, false},
+		{"attachment disposition", &Part{MediaType: "text/plain", Disposition: "ATTACHMENT"}, true},
+		{"named part", &Part{MediaType: "image/png", Disposition: "inline", Filename: "logo.png"}, true},
+		{"named multipart", &Part{MediaType: "multipart/mixed", Filename: "foo"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.part.IsAttachment(); got != tt.want {
+				t.Errorf("IsAttachment() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
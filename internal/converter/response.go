@@ -0,0 +1,117 @@
+package converter
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Result is the outcome of a message sending
+type Result struct {
+	// Accepted is the number of recipients accepted by the SMTP server
+	Accepted int
+	// MessageID is the Message-ID of the sent message, without its angle brackets
+	MessageID string
+}
+
+// ErrorKind classifies the errors so each converter can render them
+// the way the vendor it mimics does
+type ErrorKind int
+
+const (
+	// KindInternal is an unexpected error
+	KindInternal ErrorKind = iota
+	// KindInvalid is an invalid or malformed request
+	KindInvalid
+	// KindUnsupportedMediaType is a request with an unexpected content type
+	KindUnsupportedMediaType
+	// KindDelivery is an error that occurred while sending the message
+	KindDelivery
+	// KindUnprocessable is a well-formed request whose message cannot be relayed
+	KindUnprocessable
+	// KindTooLarge is a message exceeding a size limit
+	KindTooLarge
+	// KindUnconfiguredSender is a message sent from a domain which is not
+	// configured as a sending domain
+	KindUnconfiguredSender
+)
+
+// StatusCode returns the default HTTP status code of the error kind
+func (k ErrorKind) StatusCode() int {
+	switch k {
+	case KindInvalid:
+		return http.StatusBadRequest
+	case KindUnsupportedMediaType:
+		return http.StatusUnsupportedMediaType
+	case KindUnprocessable:
+		return http.StatusUnprocessableEntity
+	case KindTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case KindUnconfiguredSender:
+		return http.StatusForbidden
+	case KindInternal, KindDelivery:
+	}
+	return http.StatusInternalServerError
+}
+
+// Error is an error carrying its kind
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// WrapError returns the given error annotated with the given kind,
+// unless the error already carries a kind
+func WrapError(kind ErrorKind, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// KindOf returns the kind of the given error. Errors that do not
+// carry any kind are internal errors.
+func KindOf(err error) ErrorKind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return KindInternal
+}
+
+// jsonResponder renders the generic JSON responses of the converters
+// which do not mimic any vendor API
+type jsonResponder struct{}
+
+// WriteResponse writes the number of accepted recipients and the message ID
+func (jsonResponder) WriteResponse(w http.ResponseWriter, res *Result) {
+	w.WriteHeader(http.StatusCreated)
+	(json.NewEncoder(w).Encode(struct {
+		TotalAcceptedRecipients int    `json:"total_accepted_recipients"`
+		MessageID               string `json:"message_id,omitempty"`
+	}{
+		TotalAcceptedRecipients: res.Accepted,
+		MessageID:               res.MessageID,
+	}))
+}
+
+// WriteError writes the error message with the error kind status code
+func (jsonResponder) WriteError(w http.ResponseWriter, err error) {
+	w.WriteHeader(KindOf(err).StatusCode())
+	(json.NewEncoder(w).Encode(map[string]string{"error": err.Error()}))
+}
@@ -0,0 +1,107 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorKind_StatusCode(t *testing.T) {
+	tests := []struct {
+		kind ErrorKind
+		want int
+	}{
+		{kind: KindInternal, want: http.StatusInternalServerError},
+		{kind: KindInvalid, want: http.StatusBadRequest},
+		{kind: KindUnsupportedMediaType, want: http.StatusUnsupportedMediaType},
+		{kind: KindUnprocessable, want: http.StatusUnprocessableEntity},
+		{kind: KindDelivery, want: http.StatusInternalServerError},
+		{kind: KindTooLarge, want: http.StatusRequestEntityTooLarge},
+		{kind: KindUnconfiguredSender, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.want), func(t *testing.T) {
+			if got := tt.kind.StatusCode(); got != tt.want {
+				t.Errorf("ErrorKind.StatusCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrapError(t *testing.T) {
+	t.Run("nil error", func(t *testing.T) {
+		if err := WrapError(KindInvalid, nil); err != nil {
+			t.Errorf("WrapError() = %v, want nil", err)
+		}
+	})
+
+	t.Run("error without kind", func(t *testing.T) {
+		cause := errors.New("cause")
+		err := WrapError(KindInvalid, cause)
+
+		if KindOf(err) != KindInvalid {
+			t.Errorf("KindOf() = %v, want %v", KindOf(err), KindInvalid)
+		}
+		if !errors.Is(err, cause) {
+			t.Errorf("WrapError() = %v, want to wrap %v", err, cause)
+		}
+		if err.Error() != "cause" {
+			t.Errorf("Error() = %#v, want %#v", err.Error(), "cause")
+		}
+	})
+
+	t.Run("error with kind keeps its kind", func(t *testing.T) {
+		err := fmt.Errorf("wrapped: %w", &Error{Kind: KindUnsupportedMediaType, Err: errors.New("cause")})
+
+		if got := WrapError(KindInvalid, err); KindOf(got) != KindUnsupportedMediaType {
+			t.Errorf("KindOf() = %v, want %v", KindOf(got), KindUnsupportedMediaType)
+		}
+	})
+}
+
+func TestKindOf(t *testing.T) {
+	if got := KindOf(errors.New("no kind")); got != KindInternal {
+		t.Errorf("KindOf() = %v, want %v", got, KindInternal)
+	}
+	if got := KindOf(&Error{Kind: KindDelivery, Err: errors.New("cause")}); got != KindDelivery {
+		t.Errorf("KindOf() = %v, want %v", got, KindDelivery)
+	}
+}
+
+func Test_jsonResponder(t *testing.T) {
+	t.Run("WriteResponse", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		jsonResponder{}.WriteResponse(w, &Result{Accepted: 3})
+
+		if w.Code != http.StatusCreated {
+			t.Errorf("WriteResponse() code = %v, want %v", w.Code, http.StatusCreated)
+		}
+		if got, want := strings.TrimSpace(w.Body.String()), `{"total_accepted_recipients":3}`; got != want {
+			t.Errorf("WriteResponse() body = %#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("WriteResponse with message ID", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		jsonResponder{}.WriteResponse(w, &Result{Accepted: 3, MessageID: "42@example.org"})
+
+		if got, want := strings.TrimSpace(w.Body.String()), `{"total_accepted_recipients":3,"message_id":"42@example.org"}`; got != want {
+			t.Errorf("WriteResponse() body = %#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("WriteError", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		jsonResponder{}.WriteError(w, &Error{Kind: KindInvalid, Err: errors.New("invalid")})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("WriteError() code = %v, want %v", w.Code, http.StatusBadRequest)
+		}
+		if got, want := strings.TrimSpace(w.Body.String()), `{"error":"invalid"}`; got != want {
+			t.Errorf("WriteError() body = %#v, want %#v", got, want)
+		}
+	})
+}
@@ -2,6 +2,8 @@ package converter
 
 import (
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
 	"net/mail"
 	"strings"
@@ -10,49 +12,245 @@ import (
 // RFC5322ID is the ID for RFC5322 converter
 const RFC5322ID ID = "rfc5322"
 
-type rfc5322 struct{}
+// RFC5322ContentType is the content type expected by the RFC5322 converter
+const RFC5322ContentType = "message/rfc822"
+
+// Envelope overrides can be given either as query parameters or as request headers.
+// When given, they replace the values read from the message headers for the SMTP
+// transaction only: the relayed message is left untouched. The sender override
+// sets the message return path.
+const (
+	MailFromParam  = "mail_from"
+	RcptToParam    = "rcpt_to"
+	MailFromHeader = "X-Mail-From"
+	RcptToHeader   = "X-Rcpt-To"
+)
+
+type rfc5322 struct {
+	jsonResponder
+	spooler *Spooler
+}
 
 // NewRFC5322 returns a new message converter for RFC 5322 format
-func NewRFC5322() Converter {
-	return &rfc5322{}
+func NewRFC5322(spooler *Spooler) Converter {
+	return &rfc5322{spooler: spooler}
 }
 
 func (rfc *rfc5322) ID() ID {
 	return RFC5322ID
 }
 
+func (rfc *rfc5322) Routes() []Route {
+	return []Route{{Path: "/rfc5322", Methods: []string{http.MethodPost}}}
+}
+
 func (rfc *rfc5322) Convert(r *http.Request) (*Message, error) {
-	body, err := slurpBody(r)
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != RFC5322ContentType {
+		return nil, &Error{
+			Kind: KindUnsupportedMediaType,
+			Err:  fmt.Errorf("content type must be %s", RFC5322ContentType),
+		}
+	}
+
+	mailFrom, rcptTo, err := envelopeOverrides(r)
 	if err != nil {
 		return nil, err
 	}
 
-	m, err := mail.ReadMessage(body)
+	// The request body is streamed to the spool: only the headers
+	// are read back to build the envelope
+	body, err := rfc.spooler.Spool(r.Body)
 	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	msg, err := ParseMessage(body)
+	if err != nil {
+		(body.Close())
 		return nil, fmt.Errorf("failed to parse input: %w", err)
 	}
 
-	(body.Seek(0, 0))
+	if err := envelopeFromHeader(msg); err != nil {
+		(body.Close())
+		return nil, err
+	}
+
+	msg.returnPath = mailFrom
+
+	// The recipients override replaces the whole recipient list: they
+	// are all delivered within the same transaction.
+	if len(rcptTo) > 0 {
+		msg.to, msg.cc, msg.bcc = rcptTo, nil, nil
+	}
 
-	return NewMessage(
-		m.Header.Get("From"),
-		parse(m.Header, "To"),
-		parse(m.Header, "Cc"),
-		parse(m.Header, "Bcc"),
-		body,
-	), nil
+	return msg, nil
 }
 
-func parse(h mail.Header, key string) []string {
-	var list []string
-	hv := strings.TrimSpace(h.Get(key))
+// envelopeOverrides returns the envelope sender and recipients given in the
+// request query or headers. Query parameters take precedence over headers.
+func envelopeOverrides(r *http.Request) (string, []string, error) {
+	query := r.URL.Query()
+
+	mailFrom := strings.TrimSpace(query.Get(MailFromParam))
+	if mailFrom == "" {
+		mailFrom = strings.TrimSpace(r.Header.Get(MailFromHeader))
+	}
+
+	if mailFrom != "" {
+		if err := val.Var(mailFrom, "email"); err != nil {
+			return "", nil, fmt.Errorf("invalid %s value %#v", MailFromParam, mailFrom)
+		}
+	}
+
+	values := query[RcptToParam]
+	if len(values) == 0 {
+		values = r.Header.Values(RcptToHeader)
+	}
 
-	if len(hv) == 0 {
-		return list
+	var rcptTo []string
+	// Each value may hold a comma-separated list of addresses
+	for _, v := range values {
+		for _, rcpt := range strings.Split(v, ",") {
+			if rcpt = strings.TrimSpace(rcpt); rcpt == "" {
+				continue
+			}
+			if err := val.Var(rcpt, "email"); err != nil {
+				return "", nil, fmt.Errorf("invalid %s value %#v", RcptToParam, rcpt)
+			}
+			rcptTo = append(rcptTo, rcpt)
+		}
 	}
 
-	for _, v := range strings.Split(hv, ",") {
-		list = append(list, strings.TrimSpace(v))
+	return mailFrom, rcptTo, nil
+}
+
+// addressParser parses address lists with RFC 2047 encoded-words support.
+// Display names are dropped once parsed so an unknown charset is not an
+// error: the encoded text is decoded as it is.
+var addressParser = &mail.AddressParser{
+	WordDecoder: &mime.WordDecoder{
+		CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+			return input, nil
+		},
+	},
+}
+
+// envelopeFromHeader sets the message envelope from its From, To, Cc
+// and Bcc header fields. When the message has been resent, the envelope
+// is built from the latest resent block instead (RFC 5322 section 3.6.6).
+// Bcc fields are removed from the relayed message.
+func envelopeFromHeader(msg *Message) error {
+	if err := senderFromHeader(msg); err != nil {
+		return err
 	}
-	return list
+
+	h, prefix := envelopeFields(msg)
+	for _, rcpt := range []struct {
+		key  string
+		list *[]string
+	}{
+		{"To", &msg.to},
+		{"Cc", &msg.cc},
+		{"Bcc", &msg.bcc},
+	} {
+		var err error
+		if *rcpt.list, err = parseAddressList(h, prefix+rcpt.key); err != nil {
+			return err
+		}
+	}
+
+	hideBcc(msg)
+	return nil
+}
+
+// envelopeFields returns the header fields the envelope is built from and
+// their prefix: the latest resent block when the message has been resent
+func envelopeFields(msg *Message) (*Header, string) {
+	if resent := resentBlock(msg.Header()); resent.Has("Resent-To") || resent.Has("Resent-Cc") || resent.Has("Resent-Bcc") {
+		return resent, "Resent-"
+	}
+	return msg.Header(), ""
+}
+
+// senderFromHeader sets the message envelope sender from its From header
+// field, or Resent-From when the message has been resent
+func senderFromHeader(msg *Message) error {
+	h, prefix := envelopeFields(msg)
+
+	from, err := parseAddressList(h, prefix+"From")
+	if err != nil {
+		return err
+	}
+	// A message may have several authors but only one envelope sender
+	if len(from) > 0 {
+		msg.from = from[0]
+	} else if prefix != "" {
+		// Resent-From is mandatory but the original author is
+		// still a better sender than none
+		if from, err = parseAddressList(msg.Header(), "From"); err != nil {
+			return err
+		}
+		if len(from) > 0 {
+			msg.from = from[0]
+		}
+	}
+	return nil
+}
+
+// hideBcc removes the Bcc fields: blind recipients must not be disclosed
+// to the other ones
+func hideBcc(msg *Message) {
+	msg.Header().Del("Bcc")
+	msg.Header().Del("Resent-Bcc")
+}
+
+// resentBlock returns the latest resent block of the given header. Each
+// time a message is resent, a new block of Resent-* fields is prepended
+// so the latest one is the first contiguous run of Resent-* fields.
+func resentBlock(h *Header) *Header {
+	block := &Header{}
+
+	for _, f := range h.Fields() {
+		if strings.HasPrefix(f.Key, "Resent-") {
+			// Resent-Date and Resent-From appear once per block
+			if (f.Key == "Resent-Date" || f.Key == "Resent-From") && block.Has(f.Key) {
+				break
+			}
+			block.fields = append(block.fields, f)
+			continue
+		}
+		if block.Len() > 0 {
+			break
+		}
+	}
+
+	return block
+}
+
+// parseAddressList parses all the instances of the given address list
+// header field and returns the addr-specs found, in order. Groups are
+// flattened and empty groups (e.g. "undisclosed-recipients:;") are skipped.
+func parseAddressList(h *Header, key string) ([]string, error) {
+	var list []string
+
+	for _, v := range h.Values(key) {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+
+		addrs, err := addressParser.ParseList(v)
+		if err != nil {
+			return nil, &Error{
+				Kind: KindInvalid,
+				Err:  fmt.Errorf("invalid %s address list %#v: %w", key, v, err),
+			}
+		}
+
+		for _, addr := range addrs {
+			list = append(list, addr.Address)
+		}
+	}
+
+	return list, nil
 }
@@ -11,17 +11,29 @@ import (
 
 func Test_NewRFC5322(t *testing.T) {
 	t.Run("constructor returns a converter", func(t *testing.T) {
-		want := &rfc5322{}
+		want := &rfc5322{spooler: testSpooler}
 
-		if got := NewRFC5322(); !reflect.DeepEqual(got, want) {
+		if got := NewRFC5322(testSpooler); !reflect.DeepEqual(got, want) {
 			t.Errorf("NewRFC5322() = %+v, want %+v", got, want)
 		}
 	})
 }
 
+func Test_rfc5322_Routes(t *testing.T) {
+	t.Run("Routes() returns the rfc5322 route", func(t *testing.T) {
+		rfc := &rfc5322{}
+		want := []Route{{Path: "/rfc5322", Methods: []string{http.MethodPost}}}
+		if got := rfc.Routes(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Routes() = %#v, want %#v", got, want)
+		}
+	})
+}
+
 func Test_rfc5322_Convert(t *testing.T) {
 	tests := []struct {
 		name    string
+		target  string
+		header  http.Header
 		reqBody io.Reader
 		want    *Message
 		wantErr bool
@@ -30,8 +42,8 @@ func Test_rfc5322_Convert(t *testing.T) {
 			name:    "simple message",
 			reqBody: strings.NewReader(simpleMessage),
 			want: &Message{
-				from: "Test <test@example.com>",
-				to:   []string{"Bob <bob@example.com>"},
+				from: "test@example.com",
+				to:   []string{"bob@example.com"},
 				raw:  strings.NewReader(simpleMessage),
 			},
 			wantErr: false,
@@ -40,9 +52,9 @@ func Test_rfc5322_Convert(t *testing.T) {
 			name:    "message with cc",
 			reqBody: strings.NewReader(messageWithCc),
 			want: &Message{
-				from: "Test <test@example.com>",
-				to:   []string{"Bob <bob@example.com>"},
-				cc:   []string{"Alice <alice@example.com>", "bob@example.com"},
+				from: "test@example.com",
+				to:   []string{"bob@example.com"},
+				cc:   []string{"alice@example.com", "bob@example.com"},
 				raw:  strings.NewReader(messageWithCc),
 			},
 			wantErr: false,
@@ -51,12 +63,153 @@ func Test_rfc5322_Convert(t *testing.T) {
 			name:    "message with bcc",
 			reqBody: strings.NewReader(messageWithBcc),
 			want: &Message{
-				from: "Test <test@example.com>",
-				bcc:  []string{"Bob <bob@example.com>", "Alice <alice@example.com>"},
-				raw:  strings.NewReader(messageWithBcc),
+				from: "test@example.com",
+				bcc:  []string{"bob@example.com", "alice@example.com"},
+				raw:  strings.NewReader(messageWithoutBcc),
+			},
+			wantErr: false,
+		},
+		{
+			name:    "message with complex address lists",
+			reqBody: strings.NewReader(messageWithAddressLists),
+			want: &Message{
+				from: "john@example.com",
+				to:   []string{"j@example.com", "bob@example.com", "alice@example.com", "carol@example.com"},
+				cc:   []string{"dave@example.com", "eve@example.com"},
+				raw:  strings.NewReader(messageWithAddressLists),
+			},
+			wantErr: false,
+		},
+		{
+			name:    "message with undisclosed recipients",
+			target:  "/?rcpt_to=carol@example.com",
+			reqBody: strings.NewReader("From: test@example.com\nTo: undisclosed-recipients:;\nCc:\n\nHello"),
+			want: &Message{
+				from: "test@example.com",
+				to:   []string{"carol@example.com"},
+				raw:  strings.NewReader("From: test@example.com\nTo: undisclosed-recipients:;\nCc:\n\nHello"),
+			},
+			wantErr: false,
+		},
+		{
+			name:    "invalid to address",
+			reqBody: strings.NewReader("From: test@example.com\nTo: Bob, bob@example.com\n\nHello"),
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "invalid from address",
+			reqBody: strings.NewReader("From: test\nTo: bob@example.com\n\nHello"),
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "resent message",
+			reqBody: strings.NewReader(resentMessage),
+			want: &Message{
+				from: "carol@example.com",
+				to:   []string{"dave@example.com"},
+				cc:   []string{"eve@example.com"},
+				bcc:  []string{"frank@example.com"},
+				raw:  strings.NewReader(resentMessageWithoutBcc),
+			},
+			wantErr: false,
+		},
+		{
+			name:    "resent message without resent from",
+			reqBody: strings.NewReader("From: test@example.com\nResent-Date: Mon, 1 Jan 2024 00:00:00 +0000\nResent-Bcc: carol@example.com\nTo: bob@example.com\n\nHello"),
+			want: &Message{
+				from: "test@example.com",
+				bcc:  []string{"carol@example.com"},
+				raw:  strings.NewReader("From: test@example.com\nResent-Date: Mon, 1 Jan 2024 00:00:00 +0000\nTo: bob@example.com\n\nHello"),
+			},
+			wantErr: false,
+		},
+		{
+			name:    "resent block without recipients",
+			reqBody: strings.NewReader("Resent-From: carol@example.com\nFrom: test@example.com\nTo: bob@example.com\n\nHello"),
+			want: &Message{
+				from: "test@example.com",
+				to:   []string{"bob@example.com"},
+				raw:  strings.NewReader("Resent-From: carol@example.com\nFrom: test@example.com\nTo: bob@example.com\n\nHello"),
+			},
+			wantErr: false,
+		},
+		{
+			name:    "invalid resent to address",
+			reqBody: strings.NewReader("Resent-To: dave\nFrom: test@example.com\nTo: bob@example.com\n\nHello"),
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "mail from query override",
+			target:  "/?mail_from=bounce@example.com",
+			header:  http.Header{MailFromHeader: []string{"ignored@example.com"}},
+			reqBody: strings.NewReader(messageWithCc),
+			want: &Message{
+				from:       "test@example.com",
+				returnPath: "bounce@example.com",
+				to:         []string{"bob@example.com"},
+				cc:         []string{"alice@example.com", "bob@example.com"},
+				raw:        strings.NewReader(messageWithCc),
+			},
+			wantErr: false,
+		},
+		{
+			name:    "mail from header override",
+			header:  http.Header{MailFromHeader: []string{"bounce@example.com"}},
+			reqBody: strings.NewReader(simpleMessage),
+			want: &Message{
+				from:       "test@example.com",
+				returnPath: "bounce@example.com",
+				to:         []string{"bob@example.com"},
+				raw:        strings.NewReader(simpleMessage),
+			},
+			wantErr: false,
+		},
+		{
+			name:    "rcpt to query override replaces all recipients",
+			target:  "/?rcpt_to=carol@example.com,dave@example.com&rcpt_to=eve@example.com",
+			reqBody: strings.NewReader(messageWithBcc),
+			want: &Message{
+				from: "test@example.com",
+				to:   []string{"carol@example.com", "dave@example.com", "eve@example.com"},
+				raw:  strings.NewReader(messageWithoutBcc),
+			},
+			wantErr: false,
+		},
+		{
+			name:    "rcpt to header override",
+			header:  http.Header{RcptToHeader: []string{"carol@example.com", " dave@example.com, "}},
+			reqBody: strings.NewReader(simpleMessage),
+			want: &Message{
+				from: "test@example.com",
+				to:   []string{"carol@example.com", "dave@example.com"},
+				raw:  strings.NewReader(simpleMessage),
 			},
 			wantErr: false,
 		},
+		{
+			name:    "invalid mail from override",
+			target:  "/?mail_from=bounce",
+			reqBody: strings.NewReader(simpleMessage),
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "invalid rcpt to override",
+			header:  http.Header{RcptToHeader: []string{"carol@example.com,Dave <dave@example.com>"}},
+			reqBody: strings.NewReader(simpleMessage),
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "unsupported content type",
+			header:  http.Header{"Content-Type": []string{"text/plain"}},
+			reqBody: strings.NewReader(simpleMessage),
+			want:    nil,
+			wantErr: true,
+		},
 		{
 			name:    "message parsing error",
 			reqBody: strings.NewReader(" From: Test <test@example.com>"),
@@ -72,8 +225,19 @@ func Test_rfc5322_Convert(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			rfc := &rfc5322{}
-			got, err := rfc.Convert(httptest.NewRequest(http.MethodPost, "/", tt.reqBody))
+			target := tt.target
+			if target == "" {
+				target = "/"
+			}
+
+			r := httptest.NewRequest(http.MethodPost, target, tt.reqBody)
+			r.Header.Set("Content-Type", RFC5322ContentType)
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+
+			rfc := &rfc5322{spooler: testSpooler}
+			got, err := rfc.Convert(r)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("rfc5322.Convert() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -85,6 +249,9 @@ func Test_rfc5322_Convert(t *testing.T) {
 			if got.From() != tt.want.From() {
 				t.Errorf("rfc5322.Convert.From() = %#v, want %#v", got.From(), tt.want.From())
 			}
+			if got.ReturnPath() != tt.want.ReturnPath() {
+				t.Errorf("rfc5322.Convert.ReturnPath() = %#v, want %#v", got.ReturnPath(), tt.want.ReturnPath())
+			}
 			if !reflect.DeepEqual(got.To(), tt.want.To()) {
 				t.Errorf("rfc5322.Convert.To() = %#v, want %#v", got.To(), tt.want.To())
 			}
@@ -95,17 +262,10 @@ func Test_rfc5322_Convert(t *testing.T) {
 				t.Errorf("rfc5322.Convert.Bcc() = %#v, want %#v", got.Bcc(), tt.want.Bcc())
 			}
 
-			gotRaw, err := got.Raw()
-			if err != nil {
-				t.Fatalf("got message raw read failed: %v", err)
-			}
-			wantRaw, err := tt.want.Raw()
-			if err != nil {
-				t.Fatalf("want message raw read failed: %v", err)
-			}
+			defer got.Close()
 
-			gotRawString := string(gotRaw)
-			wantRawString := string(wantRaw)
+			gotRawString := readRaw(t, got)
+			wantRawString := readRaw(t, tt.want)
 
 			if gotRawString != wantRawString {
 				t.Errorf("rfc5322.Convert.Raw() = %#v, want %#v", gotRawString, wantRawString)
@@ -132,3 +292,47 @@ Bcc: Bob <bob@example.com>,Alice <alice@example.com>
 Subject: Hello world!
 
 Hello world!`
+
+var messageWithoutBcc = `From: Test <test@example.com>
+Subject: Hello world!
+
+Hello world!`
+
+var resentMessage = `Received: from mx.example.com
+Resent-From: Carol <carol@example.com>
+Resent-Date: Tue, 2 Jan 2024 00:00:00 +0000
+Resent-To: dave@example.com
+Resent-Cc: eve@example.com
+Resent-Bcc: frank@example.com
+Resent-From: alice@example.com
+Resent-Date: Mon, 1 Jan 2024 00:00:00 +0000
+Resent-To: bob@example.com
+Resent-Bcc: grace@example.com
+From: Test <test@example.com>
+To: Bob <bob@example.com>
+Bcc: heidi@example.com
+Subject: Hello world!
+
+Hello world!`
+
+var resentMessageWithoutBcc = `Received: from mx.example.com
+Resent-From: Carol <carol@example.com>
+Resent-Date: Tue, 2 Jan 2024 00:00:00 +0000
+Resent-To: dave@example.com
+Resent-Cc: eve@example.com
+Resent-From: alice@example.com
+Resent-Date: Mon, 1 Jan 2024 00:00:00 +0000
+Resent-To: bob@example.com
+From: Test <test@example.com>
+To: Bob <bob@example.com>
+Subject: Hello world!
+
+Hello world!`
+
+var messageWithAddressLists = `From: "Doe, John" <john@example.com>, Jane <jane@example.com>
+To: "Doe, John" <j@example.com>, =?UTF-8?Q?J=C3=B6rg?= <bob@example.com>
+To: friends: alice@example.com, Carol (work) <carol@example.com>;
+Cc: dave@example.com (Dave), =?ISO-8859-15?Q?Ev=E9?= <eve@example.com>
+Subject: Hello world!
+
+Hello world!`
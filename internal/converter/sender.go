@@ -0,0 +1,40 @@
+package converter
+
+import (
+	"context"
+	"strings"
+)
+
+// SendingDomainError is the error of a message sent from a domain
+// which is not configured as a sending domain
+type SendingDomainError struct {
+	Domain string
+}
+
+// Error implements the error interface
+func (e *SendingDomainError) Error() string {
+	return "unconfigured sending domain " + e.Domain
+}
+
+// senderDomains rejects the messages whose From: address or envelope
+// sender is not in one of the given domains
+func senderDomains(domains []string) MessageFilter {
+	allowed := make(map[string]bool, len(domains))
+	for _, domain := range domains {
+		allowed[strings.ToLower(domain)] = true
+	}
+
+	return MessageFilterFunc(func(_ context.Context, m *Message) error {
+		for _, addr := range []string{m.From(), m.ReturnPath()} {
+			if addr == "" {
+				continue
+			}
+
+			domain := strings.ToLower(addr[strings.LastIndex(addr, "@")+1:])
+			if !allowed[domain] {
+				return &Error{Kind: KindUnconfiguredSender, Err: &SendingDomainError{Domain: domain}}
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,56 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_senderDomains(t *testing.T) {
+	tests := []struct {
+		name       string
+		from       string
+		returnPath string
+		wantDomain string
+	}{
+		{name: "allowed From domain", from: "from@example.com"},
+		{name: "allowed From domain is case insensitive", from: "from@EXAMPLE.com"},
+		{name: "allowed From and envelope sender domains", from: "from@example.com", returnPath: "bounces@example.org"},
+		{name: "unconfigured From domain", from: "from@example.net", wantDomain: "example.net"},
+		{name: "unconfigured envelope sender domain", from: "from@example.com", returnPath: "bounces@example.net", wantDomain: "example.net"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFilter(FilterSpec{Type: FilterSenderDomains, AllowDomains: []string{"example.com", "Example.ORG"}})
+			if err != nil {
+				t.Fatalf("NewFilter() error = %v", err)
+			}
+
+			m := NewMessage(tt.from, []string{"to@example.com"}, nil, nil, strings.NewReader(""))
+			m.returnPath = tt.returnPath
+
+			err = f.Filter(context.Background(), m)
+			if (err != nil) != (tt.wantDomain != "") {
+				t.Fatalf("Filter() error = %v, want domain %#v", err, tt.wantDomain)
+			}
+			if err == nil {
+				return
+			}
+
+			var domainErr *SendingDomainError
+			if !errors.As(err, &domainErr) || domainErr.Domain != tt.wantDomain {
+				t.Errorf("Filter() error = %#v, want domain %#v", err, tt.wantDomain)
+			}
+			if KindOf(err) != KindUnconfiguredSender {
+				t.Errorf("Filter() error kind = %v, want %v", KindOf(err), KindUnconfiguredSender)
+			}
+		})
+	}
+
+	t.Run("missing domains", func(t *testing.T) {
+		if _, err := NewFilter(FilterSpec{Type: FilterSenderDomains}); err == nil {
+			t.Error("NewFilter() error = nil, want an error")
+		}
+	})
+}
@@ -1,13 +1,13 @@
 package converter
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
-	"net/http/httptest"
-	"strings"
+	"strconv"
 
 	validator "github.com/go-playground/validator/v10"
 )
@@ -15,11 +15,14 @@ import (
 // SparkPostID is the ID for SparkPost converter
 const SparkPostID ID = "sparkpost"
 
+const spIDLenght = 10000000000000000
+
 // SparkPostTransmission represents a SparkPost transmission
 // See: https://developers.sparkpost.com/api/transmissions/#transmissions-create-a-transmission
 type SparkPostTransmission struct {
 	Recipients []Address `json:"recipients" validate:"required,min=1,dive,required"`
 	Content    Content   `json:"content" validate:"required"`
+	ReturnPath string    `json:"return_path" validate:"omitempty,email"`
 }
 
 // Address is a SparkPost address
@@ -32,21 +35,37 @@ type AddressItem struct {
 	Email string `json:"email" validate:"required,email"`
 }
 
-// Content is the transmission content
+// Content is the transmission content. EmailRFC822 is streamed to the
+// spool by the converter and is always decoded empty.
 type Content struct {
 	EmailRFC822 string `json:"email_rfc822"`
 }
 
+// SparkPostResults is the SparkPost transmission creation response
+type SparkPostResults struct {
+	ID                      string `json:"id"`
+	TotalAcceptedRecipients int    `json:"total_accepted_recipients"`
+	TotalRejectedRecipients int    `json:"total_rejected_recipients"`
+}
+
+// SparkPostError is a SparkPost API error
+// See: https://developers.sparkpost.com/api/#header-errors
+type SparkPostError struct {
+	Message     string `json:"message"`
+	Code        string `json:"code,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
 type spt10n struct {
-	rfc5322Converter Converter
-	validator        *validator.Validate
+	spooler   *Spooler
+	validator *validator.Validate
 }
 
 // NewSparkPost returns a new SparkPost transmission converter
-func NewSparkPost() Converter {
+func NewSparkPost(spooler *Spooler) Converter {
 	return &spt10n{
-		rfc5322Converter: NewRFC5322(),
-		validator:        val,
+		spooler:   spooler,
+		validator: val,
 	}
 }
 
@@ -54,38 +73,83 @@ func (s *spt10n) ID() ID {
 	return SparkPostID
 }
 
+func (s *spt10n) Routes() []Route {
+	return []Route{{Path: "/sparkpost/api/v1/transmissions", Methods: []string{http.MethodPost}}}
+}
+
 func (s *spt10n) Convert(r *http.Request) (*Message, error) {
-	data, err := io.ReadAll(r.Body)
-	if err != nil {
-		return nil, err
-	}
 	defer r.Body.Close()
 
+	// The raw email is streamed to the spool while the rest of the
+	// payload is decoded, so it is never held in memory
+	pr, pw := io.Pipe()
+	converted := make(chan conversion, 1)
+
+	go func() {
+		msg, err := s.convertRFC822(pr)
+		// Drains what was not read so the decoding goes on
+		(io.Copy(io.Discard, pr))
+		converted <- conversion{msg, err}
+	}()
+
 	t10n := &SparkPostTransmission{}
-	if err := json.NewDecoder(bytes.NewReader(data)).Decode(t10n); err != nil {
-		return nil, err
+	rfc822 := newStringExtractor(r.Body, pw, "content", "email_rfc822")
+
+	err := json.NewDecoder(rfc822).Decode(t10n)
+	(pw.CloseWithError(err))
+	c := <-converted
+
+	if err == nil {
+		err = s.validator.Struct(t10n)
+	}
+	if err == nil && rfc822.size == 0 {
+		err = errors.New("inline content transmission not implemented")
+	}
+	if err == nil {
+		err = c.err
 	}
 
-	if err := s.validator.Struct(t10n); err != nil {
+	if err != nil {
+		if c.msg != nil {
+			(c.msg.Close())
+		}
 		return nil, err
 	}
 
-	if t10n.Content.EmailRFC822 != "" {
-		return s.rfc822ToMessage(t10n)
-	}
+	return s.rfc822ToMessage(t10n, c.msg), nil
+}
 
-	return nil, errors.New("inline content transmission not implemented")
+// conversion is the result of the conversion of the raw email
+type conversion struct {
+	msg *Message
+	err error
 }
 
-func (s *spt10n) rfc822ToMessage(t10n *SparkPostTransmission) (*Message, error) {
-	body := strings.NewReader(t10n.Content.EmailRFC822)
+// convertRFC822 spools and parses the raw email to get the from address.
+// Its recipients are given by the payload: the To and Cc fields are not
+// parsed so undisclosed or malformed lists are relayed as they are.
+func (s *spt10n) convertRFC822(rfc822 io.Reader) (*Message, error) {
+	body, err := s.spooler.Spool(rfc822)
+	if err != nil {
+		return nil, err
+	}
 
-	// First, we need to parse the raw email to get the from address
-	messageFromRFC822, err := s.rfc5322Converter.Convert(httptest.NewRequest(http.MethodPost, "/", body))
+	msg, err := ParseMessage(body)
 	if err != nil {
+		(body.Close())
+		return nil, fmt.Errorf("failed to parse input: %w", err)
+	}
+
+	if err := senderFromHeader(msg); err != nil {
+		(body.Close())
 		return nil, err
 	}
 
+	hideBcc(msg)
+	return msg, nil
+}
+
+func (s *spt10n) rfc822ToMessage(t10n *SparkPostTransmission, message *Message) *Message {
 	// The recipient list is provided as it is in the request payload,
 	// we don't parse the raw email because Bcc header should be missing.
 	rcpts := []string{}
@@ -93,11 +157,64 @@ func (s *spt10n) rfc822ToMessage(t10n *SparkPostTransmission) (*Message, error)
 		rcpts = append(rcpts, to.Email)
 	}
 
-	return NewMessage(
-		messageFromRFC822.From(),
-		rcpts,
-		nil,
-		nil,
-		body,
-	), nil
+	message.to, message.cc, message.bcc = rcpts, nil, nil
+
+	if t10n.ReturnPath != "" {
+		message.returnPath = t10n.ReturnPath
+	}
+
+	return message
+}
+
+// WriteResponse uses the message ID as transmission ID so the transmission
+// can be matched with the relayed message
+func (s *spt10n) WriteResponse(w http.ResponseWriter, res *Result) {
+	id := res.MessageID
+	if id == "" {
+		id = strconv.Itoa(rand.Intn(spIDLenght))
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	(json.NewEncoder(w).Encode(struct {
+		Results SparkPostResults `json:"results"`
+	}{
+		Results: SparkPostResults{
+			TotalAcceptedRecipients: res.Accepted,
+			ID:                      id,
+		},
+	}))
+}
+
+func (s *spt10n) WriteError(w http.ResponseWriter, err error) {
+	kind := KindOf(err)
+	status := kind.StatusCode()
+	spErr := SparkPostError{
+		Message:     http.StatusText(status),
+		Description: err.Error(),
+	}
+
+	var (
+		domainErr *SendingDomainError
+		limitErr  *LimitError
+	)
+
+	switch {
+	// SparkPost reports the transmissions exceeding its limits as invalid ones
+	case kind == KindInvalid, errors.As(err, &limitErr):
+		status = http.StatusBadRequest
+		spErr.Message = "invalid data format/type"
+		spErr.Code = "1300"
+	case kind == KindUnconfiguredSender && errors.As(err, &domainErr):
+		status = http.StatusBadRequest
+		spErr.Message = "Invalid domain"
+		spErr.Code = "7001"
+		spErr.Description = "Unconfigured Sending Domain <" + domainErr.Domain + ">"
+	}
+
+	w.WriteHeader(status)
+	(json.NewEncoder(w).Encode(struct {
+		Errors []SparkPostError `json:"errors"`
+	}{
+		Errors: []SparkPostError{spErr},
+	}))
 }
@@ -1,11 +1,14 @@
 package converter
 
 import (
+	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"reflect"
+	"regexp"
 	"sort"
 	"strings"
 	"testing"
@@ -14,11 +17,11 @@ import (
 func TestNewSparkPost(t *testing.T) {
 	t.Run("constructor returns a converter", func(t *testing.T) {
 		want := &spt10n{
-			rfc5322Converter: NewRFC5322(),
-			validator:        val,
+			spooler:   testSpooler,
+			validator: val,
 		}
 
-		if got := NewSparkPost(); !reflect.DeepEqual(got, want) {
+		if got := NewSparkPost(testSpooler); !reflect.DeepEqual(got, want) {
 			t.Errorf("NewSparkPost() = %+v, want %+v", got, want)
 		}
 	})
@@ -33,6 +36,16 @@ func Test_spt10n_ID(t *testing.T) {
 	})
 }
 
+func Test_spt10n_Routes(t *testing.T) {
+	t.Run("Routes() returns the transmissions route", func(t *testing.T) {
+		s := &spt10n{}
+		want := []Route{{Path: "/sparkpost/api/v1/transmissions", Methods: []string{http.MethodPost}}}
+		if got := s.Routes(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Routes() = %#v, want %#v", got, want)
+		}
+	})
+}
+
 func Test_spt10n_Convert(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -64,6 +77,12 @@ func Test_spt10n_Convert(t *testing.T) {
 			wantNil: true,
 			wantErr: true,
 		},
+		{
+			name:    "return path is not valid",
+			reqBody: strings.NewReader(`{"recipients":[{"address":{"email":"foo@example.com"}}],"return_path":"bounce","content":{"email_rfc822":"From: test@example.com\n\nHello"}}`),
+			wantNil: true,
+			wantErr: true,
+		},
 		{
 			name:    "RFC822 transmission is processed",
 			reqBody: strings.NewReader(`{"recipients":[{"address":{"email":"foo@example.com"}}],"content":{"email_rfc822":"From: Test <test@example.com>\nTo: Bob <bob@example.com>\nSubject: Hello world!\n\nHello world!"}}`),
@@ -73,7 +92,7 @@ func Test_spt10n_Convert(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := NewSparkPost()
+			s := NewSparkPost(testSpooler)
 			got, err := s.Convert(httptest.NewRequest(http.MethodPost, "/", tt.reqBody))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("spt10n.Convert() error = %v, wantErr %v", err, tt.wantErr)
@@ -85,21 +104,72 @@ func Test_spt10n_Convert(t *testing.T) {
 	}
 }
 
+func Test_spt10n_Convert_rfc822(t *testing.T) {
+	t.Run("raw email is decoded", func(t *testing.T) {
+		raw := `From: Test <test@example.com>\r\nTo: Bob <bob@example.com>\r\nSubject: Caf\u00e9 \"au lait\" \ud83d\ude00\r\n\r\n` +
+			strings.Repeat(`Hello world!\r\n`, 10000)
+		body := `{"recipients":[{"address":{"email":"foo@example.com"}}],"content":{"email_rfc822":"` + raw + `"}}`
+
+		var want string
+		if err := json.Unmarshal([]byte(`"`+raw+`"`), &want); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := NewSparkPost(testSpooler).Convert(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		if err != nil {
+			t.Fatalf("spt10n.Convert() error = %v", err)
+		}
+		defer got.Close()
+
+		if content := readRaw(t, got); content != want {
+			t.Errorf("spt10n.Convert() content = %.100q, want %.100q", content, want)
+		}
+		if !reflect.DeepEqual(got.To(), []string{"foo@example.com"}) {
+			t.Errorf("spt10n.Convert() to = %#v, want %#v", got.To(), []string{"foo@example.com"})
+		}
+	})
+
+	t.Run("raw email fails to be spooled", func(t *testing.T) {
+		s := &spt10n{
+			spooler:   NewSpooler(filepath.Join(t.TempDir(), "ghost"), 0),
+			validator: val,
+		}
+
+		body := `{"recipients":[{"address":{"email":"foo@example.com"}}],"content":{"email_rfc822":"` + strings.Repeat("a", 1<<20) + `"}}`
+		if _, err := s.Convert(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))); err == nil {
+			t.Error("spt10n.Convert() error = nil, want an error")
+		}
+	})
+
+	for _, to := range []string{"undisclosed-recipients:;", "Bob <bob@", "<>"} {
+		t.Run("header recipients are not parsed: "+to, func(t *testing.T) {
+			raw := `From: Test <test@example.com>\nTo: ` + to + `\nBcc: eve@example.com\nSubject: Hello\n\nHello`
+			body := `{"recipients":[{"address":{"email":"foo@example.com"}}],"content":{"email_rfc822":"` + raw + `"}}`
+
+			got, err := NewSparkPost(testSpooler).Convert(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+			if err != nil {
+				t.Fatalf("spt10n.Convert() error = %v", err)
+			}
+			defer got.Close()
+
+			want := Envelope{From: "test@example.com", To: []string{"foo@example.com"}}
+			if !reflect.DeepEqual(got.Envelope(), want) {
+				t.Errorf("spt10n.Convert() envelope = %#v, want %#v", got.Envelope(), want)
+			}
+			if got.Header().Has("Bcc") {
+				t.Error("spt10n.Convert() kept the Bcc field")
+			}
+		})
+	}
+}
+
 func Test_spt10n_rfc822ToMessage(t *testing.T) {
 	tests := []struct {
-		name             string
-		t10n             *SparkPostTransmission
-		rfc5322Converter Converter
-		want             *Message
-		wantErr          bool
+		name    string
+		t10n    *SparkPostTransmission
+		message *Message
+		want    *Message
 	}{
-		{
-			name:             "rfc5322 converter returns an error",
-			t10n:             &SparkPostTransmission{},
-			rfc5322Converter: &Stub{Err: errors.New("some error occurred")},
-			want:             nil,
-			wantErr:          true,
-		},
 		{
 			name: "simple message",
 			t10n: &SparkPostTransmission{
@@ -108,12 +178,11 @@ func Test_spt10n_rfc822ToMessage(t *testing.T) {
 						AddressItem{Email: "recipient@example.com"},
 					},
 				},
-				Content: Content{
-					EmailRFC822: simpleMessage,
-				},
 			},
-			rfc5322Converter: &Stub{
-				Message: &Message{from: "from@example.com"},
+			message: &Message{
+				from: "from@example.com",
+				to:   []string{"bob@example.com"},
+				raw:  strings.NewReader(simpleMessage),
 			},
 			want: NewMessage(
 				"from@example.com",
@@ -122,7 +191,6 @@ func Test_spt10n_rfc822ToMessage(t *testing.T) {
 				nil,
 				strings.NewReader(simpleMessage),
 			),
-			wantErr: false,
 		},
 		{
 			name: "only recipient from the payload are considered",
@@ -138,12 +206,12 @@ func Test_spt10n_rfc822ToMessage(t *testing.T) {
 						AddressItem{Email: "recipient3@example.com"},
 					},
 				},
-				Content: Content{
-					EmailRFC822: messageWithCc,
-				},
 			},
-			rfc5322Converter: &Stub{
-				Message: &Message{from: "from@example.com"},
+			message: &Message{
+				from: "from@example.com",
+				to:   []string{"bob@example.com"},
+				cc:   []string{"alice@example.com"},
+				raw:  strings.NewReader(messageWithCc),
 			},
 			want: NewMessage(
 				"from@example.com",
@@ -152,31 +220,42 @@ func Test_spt10n_rfc822ToMessage(t *testing.T) {
 				nil,
 				strings.NewReader(messageWithCc),
 			),
-			wantErr: false,
+		},
+		{
+			name: "return path is the envelope sender",
+			t10n: &SparkPostTransmission{
+				Recipients: []Address{
+					{
+						AddressItem{Email: "recipient@example.com"},
+					},
+				},
+				ReturnPath: "bounce@example.com",
+			},
+			message: &Message{
+				from: "from@example.com",
+				to:   []string{"bob@example.com"},
+				raw:  strings.NewReader(simpleMessage),
+			},
+			want: &Message{
+				from:       "from@example.com",
+				returnPath: "bounce@example.com",
+				to:         []string{"recipient@example.com"},
+				header:     &Header{},
+				raw:        strings.NewReader(simpleMessage),
+			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &spt10n{
-				rfc5322Converter: tt.rfc5322Converter,
-				validator:        val,
-			}
-			got, err := s.rfc822ToMessage(tt.t10n)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("spt10n.rfc822ToMessage() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-
-			if tt.want == nil {
-				if got != nil {
-					t.Errorf("spt10n.rfc822ToMessage() expected to return nil")
-				}
-				return
-			}
+			s := &spt10n{validator: val}
+			got := s.rfc822ToMessage(tt.t10n, tt.message)
 
 			if got.From() != tt.want.From() {
 				t.Errorf("spt10n.rfc822ToMessage() from = %#v, want %#v", got.From(), tt.want.From())
 			}
+			if got.ReturnPath() != tt.want.ReturnPath() {
+				t.Errorf("spt10n.rfc822ToMessage() return path = %#v, want %#v", got.ReturnPath(), tt.want.ReturnPath())
+			}
 
 			// Loops over all recipient list to assert them
 			for _, provider := range []RecipientProvider{(*Message).To, (*Message).Cc, (*Message).Bcc} {
@@ -192,17 +271,8 @@ func Test_spt10n_rfc822ToMessage(t *testing.T) {
 				}
 			}
 
-			gotRaw, err := got.Raw()
-			if err != nil {
-				t.Fatalf("got message raw read failed: %v", err)
-			}
-			wantRaw, err := tt.want.Raw()
-			if err != nil {
-				t.Fatalf("want message raw read failed: %v", err)
-			}
-
-			gotRawString := string(gotRaw)
-			wantRawString := string(wantRaw)
+			gotRawString := readRaw(t, got)
+			wantRawString := readRaw(t, tt.want)
 
 			if gotRawString != wantRawString {
 				t.Errorf("spt10n.rfc822ToMessage.Raw() = %#v, want %#v", gotRawString, wantRawString)
@@ -210,3 +280,83 @@ func Test_spt10n_rfc822ToMessage(t *testing.T) {
 		})
 	}
 }
+
+func Test_spt10n_WriteResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	(&spt10n{}).WriteResponse(w, &Result{Accepted: 42})
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("WriteResponse() code = %v, want %v", w.Code, http.StatusCreated)
+	}
+
+	// Replace the random ID number by "id"
+	body := regexp.MustCompile(`"id":"\d+"`).ReplaceAllString(strings.TrimSpace(w.Body.String()), `"id":"id"`)
+	want := `{"results":{"id":"id","total_accepted_recipients":42,"total_rejected_recipients":0}}`
+
+	if body != want {
+		t.Errorf("WriteResponse() body = %#v, want %#v", body, want)
+	}
+}
+
+func Test_spt10n_WriteResponse_messageID(t *testing.T) {
+	w := httptest.NewRecorder()
+	(&spt10n{}).WriteResponse(w, &Result{Accepted: 1, MessageID: "42@example.org"})
+
+	want := `{"results":{"id":"42@example.org","total_accepted_recipients":1,"total_rejected_recipients":0}}`
+	if body := strings.TrimSpace(w.Body.String()); body != want {
+		t.Errorf("WriteResponse() body = %#v, want %#v", body, want)
+	}
+}
+
+func Test_spt10n_WriteError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "invalid request",
+			err:      &Error{Kind: KindInvalid, Err: errors.New("conversion failed")},
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"errors":[{"message":"invalid data format/type","code":"1300","description":"conversion failed"}]}`,
+		},
+		{
+			name:     "delivery error",
+			err:      &Error{Kind: KindDelivery, Err: errors.New("smtp error")},
+			wantCode: http.StatusInternalServerError,
+			wantBody: `{"errors":[{"message":"Internal Server Error","description":"smtp error"}]}`,
+		},
+		{
+			name:     "unconfigured sending domain",
+			err:      &Error{Kind: KindUnconfiguredSender, Err: &SendingDomainError{Domain: "example.com"}},
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"errors":[{"message":"Invalid domain","code":"7001","description":"Unconfigured Sending Domain \u003cexample.com\u003e"}]}`,
+		},
+		{
+			name:     "limit exceeded",
+			err:      &Error{Kind: KindTooLarge, Err: &LimitError{Limit: "message size", Max: 10, Value: 12}},
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"errors":[{"message":"invalid data format/type","code":"1300","description":"message size 12 exceeds the limit of 10"}]}`,
+		},
+		{
+			name:     "error without kind",
+			err:      errors.New("internal error"),
+			wantCode: http.StatusInternalServerError,
+			wantBody: `{"errors":[{"message":"Internal Server Error","description":"internal error"}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			(&spt10n{}).WriteError(w, tt.err)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("WriteError() code = %v, want %v", w.Code, tt.wantCode)
+			}
+			if body := strings.TrimSpace(w.Body.String()); body != tt.wantBody {
+				t.Errorf("WriteError() body = %#v, want %#v", body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package converter
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+)
+
+// DefaultSpoolThreshold is the default max size in bytes of a message kept in memory
+const DefaultSpoolThreshold = 1 << 20
+
+// Spooler buffers messages content. Contents up to the threshold are kept in
+// memory while bigger ones are written to a temporary file, so large messages
+// don't have to be held in memory while being sent.
+type Spooler struct {
+	dir       string
+	threshold int64
+}
+
+// NewSpooler returns a new spooler writing its temporary files in the given
+// directory (the default temporary directory when empty)
+func NewSpooler(dir string, threshold int64) *Spooler {
+	return &Spooler{
+		dir:       dir,
+		threshold: threshold,
+	}
+}
+
+// Spooled is a spooled raw message content. It must be closed in order
+// to release its resources.
+type Spooled interface {
+	Raw
+	io.Closer
+}
+
+// Spool reads the given reader until EOF and returns its spooled content
+func (s *Spooler) Spool(r io.Reader) (Spooled, error) {
+	buf := &bytes.Buffer{}
+
+	// Reads one extra byte to know whether the content exceeds the threshold
+	if _, err := io.CopyN(buf, r, s.threshold+1); err != nil {
+		if errors.Is(err, io.EOF) {
+			return &memorySpool{bytes.NewReader(buf.Bytes())}, nil
+		}
+		return nil, err
+	}
+
+	f, err := os.CreateTemp(s.dir, "http2smtp-*.eml")
+	if err != nil {
+		return nil, err
+	}
+
+	spool := &fileSpool{File: f}
+
+	n, err := buf.WriteTo(f)
+	if err != nil {
+		(spool.Close())
+		return nil, err
+	}
+
+	m, err := io.Copy(f, r)
+	if err != nil {
+		(spool.Close())
+		return nil, err
+	}
+
+	spool.size = n + m
+	return spool, nil
+}
+
+// SpoolFunc spools the content written by the given func
+func (s *Spooler) SpoolFunc(fn func(w io.Writer) error) (Spooled, error) {
+	pr, pw := io.Pipe()
+
+	go func() {
+		(pw.CloseWithError(fn(pw)))
+	}()
+
+	spool, err := s.Spool(pr)
+	if err != nil {
+		// Unblocks the writing func
+		(pr.CloseWithError(err))
+	}
+	return spool, err
+}
+
+// memorySpool is a content spooled in memory
+type memorySpool struct {
+	*bytes.Reader
+}
+
+// Close implements io.Closer
+func (*memorySpool) Close() error {
+	return nil
+}
+
+// fileSpool is a content spooled in a temporary file
+type fileSpool struct {
+	*os.File
+	size int64
+}
+
+// Size returns the content size in bytes
+func (f *fileSpool) Size() int64 {
+	return f.size
+}
+
+// Close closes and removes the temporary file
+func (f *fileSpool) Close() error {
+	err := f.File.Close()
+	if rmErr := os.Remove(f.Name()); rmErr != nil && err == nil {
+		err = rmErr
+	}
+	return err
+}
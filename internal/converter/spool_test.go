@@ -0,0 +1,153 @@
+package converter
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSpooler_Spool(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold int64
+		input     io.Reader
+		want      string
+		wantFile  bool
+		wantErr   bool
+	}{
+		{
+			name:      "empty content",
+			threshold: 4,
+			input:     strings.NewReader(""),
+			want:      "",
+			wantFile:  false,
+		},
+		{
+			name:      "content under threshold",
+			threshold: 4,
+			input:     strings.NewReader("foo"),
+			want:      "foo",
+			wantFile:  false,
+		},
+		{
+			name:      "content at threshold",
+			threshold: 4,
+			input:     strings.NewReader("fooo"),
+			want:      "fooo",
+			wantFile:  false,
+		},
+		{
+			name:      "content above threshold",
+			threshold: 4,
+			input:     strings.NewReader("foo bar baz"),
+			want:      "foo bar baz",
+			wantFile:  true,
+		},
+		{
+			name:      "read error",
+			threshold: 4,
+			input:     &failingReader{},
+			wantErr:   true,
+		},
+		{
+			name:      "read error after threshold",
+			threshold: 4,
+			input:     io.MultiReader(strings.NewReader("foo bar"), &failingReader{}),
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			got, err := NewSpooler(dir, tt.threshold).Spool(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Spooler.Spool() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			files, _ := os.ReadDir(dir)
+
+			if tt.wantErr {
+				if len(files) > 0 {
+					t.Errorf("Spooler.Spool() left %d temporary files", len(files))
+				}
+				return
+			}
+
+			if (len(files) == 1) != tt.wantFile {
+				t.Errorf("Spooler.Spool() created %d temporary files, want file %v", len(files), tt.wantFile)
+			}
+
+			content, err := io.ReadAll(io.NewSectionReader(got, 0, got.Size()))
+			if err != nil {
+				t.Fatalf("spool read failed: %v", err)
+			}
+			if string(content) != tt.want {
+				t.Errorf("Spooler.Spool() = %#v, want %#v", string(content), tt.want)
+			}
+
+			if err := got.Close(); err != nil {
+				t.Errorf("spool close failed: %v", err)
+			}
+
+			if files, _ := os.ReadDir(dir); len(files) > 0 {
+				t.Errorf("spool close left %d temporary files", len(files))
+			}
+		})
+	}
+
+	t.Run("temporary file creation fails", func(t *testing.T) {
+		s := NewSpooler(filepath.Join(t.TempDir(), "ghost"), 0)
+		if _, err := s.Spool(strings.NewReader("foo")); err == nil {
+			t.Error("Spooler.Spool() error = nil, want an error")
+		}
+	})
+}
+
+func TestSpooler_SpoolFunc(t *testing.T) {
+	t.Run("written content is spooled", func(t *testing.T) {
+		got, err := NewSpooler(t.TempDir(), 4).SpoolFunc(func(w io.Writer) error {
+			_, err := io.WriteString(w, "foo bar baz")
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Spooler.SpoolFunc() error = %v", err)
+		}
+		defer got.Close()
+
+		content, _ := io.ReadAll(io.NewSectionReader(got, 0, got.Size()))
+		if string(content) != "foo bar baz" {
+			t.Errorf("Spooler.SpoolFunc() = %#v, want %#v", string(content), "foo bar baz")
+		}
+	})
+
+	t.Run("func error is returned", func(t *testing.T) {
+		wantErr := errors.New("write error")
+		_, err := NewSpooler(t.TempDir(), 4).SpoolFunc(func(w io.Writer) error {
+			(io.WriteString(w, "foo bar baz"))
+			return wantErr
+		})
+		if !errors.Is(err, wantErr) {
+			t.Errorf("Spooler.SpoolFunc() error = %v, want %v", err, wantErr)
+		}
+	})
+
+	t.Run("spool error unblocks the func", func(t *testing.T) {
+		done := make(chan struct{})
+		_, err := NewSpooler(filepath.Join(t.TempDir(), "ghost"), 0).SpoolFunc(func(w io.Writer) error {
+			defer close(done)
+			for {
+				if _, err := io.WriteString(w, "foo"); err != nil {
+					return err
+				}
+			}
+		})
+		if err == nil {
+			t.Error("Spooler.SpoolFunc() error = nil, want an error")
+		}
+		<-done
+	})
+}
@@ -0,0 +1,98 @@
+package converter
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Submission describes the HTTP call a message was submitted with. It is
+// recorded in the message trace fields.
+type Submission struct {
+	// Domain is the domain of the generated Message-IDs and the
+	// receiving host of the Received: field
+	Domain string
+	// ClientIP is the IP address of the HTTP client
+	ClientIP string
+	// TraceID is the request trace ID, if any
+	TraceID string
+	// Time is the submission time
+	Time time.Time
+}
+
+// Stamp adds the Message-ID: and Date: fields when missing and prepends
+// a Received: field recording the submission
+func (m *Message) Stamp(s Submission) error {
+	if m == nil {
+		return nil
+	}
+
+	h := m.Header()
+
+	if strings.TrimSpace(h.Get("Message-Id")) == "" {
+		id, err := NewMessageID(s.Domain)
+		if err != nil {
+			return err
+		}
+		h.Set("Message-Id", "<"+id+">")
+	}
+
+	if strings.TrimSpace(h.Get("Date")) == "" {
+		h.Set("Date", s.Time.Format(time.RFC1123Z))
+	}
+
+	h.Prepend("Received", received(s))
+
+	return nil
+}
+
+// NewMessageID returns a new unique Message-ID, without its angle brackets,
+// for the given domain
+func NewMessageID(domain string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate message ID: %w", err)
+	}
+	return hex.EncodeToString(b) + "@" + domain, nil
+}
+
+// received returns a Received: field value following RFC 5321 section 4.4
+func received(s Submission) string {
+	var b strings.Builder
+
+	if s.ClientIP != "" {
+		b.WriteString("from ")
+		// IPv6 addresses are written as address literals
+		if strings.Contains(s.ClientIP, ":") {
+			b.WriteString("[IPv6:" + s.ClientIP + "] ")
+		} else {
+			b.WriteString("[" + s.ClientIP + "] ")
+		}
+	}
+
+	b.WriteString("by " + s.Domain + " (http2smtp) with HTTP")
+
+	if id := atom(s.TraceID); id != "" {
+		b.WriteString(" id " + id)
+	}
+
+	b.WriteString("; " + s.Time.Format(time.RFC1123Z))
+
+	return b.String()
+}
+
+// atom drops the characters of the given string that are not allowed
+// in a Received: field ID
+func atom(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case strings.ContainsRune("!#$%&'*+-/=?^_`{|}~.", r):
+			return r
+		}
+		return -1
+	}, s)
+}
@@ -0,0 +1,92 @@
+package converter
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessage_Stamp(t *testing.T) {
+	now := time.Date(2024, time.January, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		raw           string
+		submission    Submission
+		wantReceived  string
+		wantDate      string
+		wantMessageID string
+	}{
+		{
+			name:          "message has all the fields",
+			raw:           "Message-ID: <42@example.com>\r\nDate: Mon, 01 Jan 2024 00:00:00 +0000\r\n\r\nHello",
+			submission:    Submission{Domain: "example.org", ClientIP: "203.0.113.1", TraceID: "00-abc-def-01", Time: now},
+			wantReceived:  "from [203.0.113.1] by example.org (http2smtp) with HTTP id 00-abc-def-01; Tue, 02 Jan 2024 15:04:05 +0000",
+			wantDate:      "Mon, 01 Jan 2024 00:00:00 +0000",
+			wantMessageID: "42@example.com",
+		},
+		{
+			name:         "message misses fields",
+			raw:          "Message-ID: \r\nSubject: Hello\r\n\r\nHello",
+			submission:   Submission{Domain: "example.org", ClientIP: "::1", TraceID: "trace id;", Time: now},
+			wantReceived: "from [IPv6:::1] by example.org (http2smtp) with HTTP id traceid; Tue, 02 Jan 2024 15:04:05 +0000",
+			wantDate:     "Tue, 02 Jan 2024 15:04:05 +0000",
+		},
+		{
+			name:         "unknown client without trace",
+			raw:          "Subject: Hello\r\n\r\nHello",
+			submission:   Submission{Domain: "example.org", Time: now},
+			wantReceived: "by example.org (http2smtp) with HTTP; Tue, 02 Jan 2024 15:04:05 +0000",
+			wantDate:     "Tue, 02 Jan 2024 15:04:05 +0000",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseMessage(strings.NewReader(tt.raw))
+			if err != nil {
+				t.Fatalf("ParseMessage() error = %v", err)
+			}
+
+			if err := m.Stamp(tt.submission); err != nil {
+				t.Fatalf("Stamp() error = %v", err)
+			}
+
+			if got := m.Header().Fields()[0]; got.Key != "Received" || got.Value != tt.wantReceived {
+				t.Errorf("Stamp() first field = %#v, want Received: %#v", got, tt.wantReceived)
+			}
+			if got := m.Header().Get("Date"); got != tt.wantDate {
+				t.Errorf("Stamp() Date = %#v, want %#v", got, tt.wantDate)
+			}
+			if len(m.Header().Values("Message-Id")) != 1 {
+				t.Errorf("Stamp() Message-ID fields = %#v, want a single one", m.Header().Values("Message-Id"))
+			}
+
+			got := m.MessageID()
+			if tt.wantMessageID != "" && got != tt.wantMessageID {
+				t.Errorf("Stamp() MessageID = %#v, want %#v", got, tt.wantMessageID)
+			}
+			if tt.wantMessageID == "" && !strings.HasSuffix(got, "@"+tt.submission.Domain) {
+				t.Errorf("Stamp() MessageID = %#v, want a generated one", got)
+			}
+		})
+	}
+
+	t.Run("nil message", func(t *testing.T) {
+		var m *Message
+		if err := m.Stamp(Submission{}); err != nil {
+			t.Errorf("Stamp() error = %v", err)
+		}
+	})
+}
+
+func TestNewMessageID(t *testing.T) {
+	a, err := NewMessageID("example.org")
+	if err != nil {
+		t.Fatalf("NewMessageID() error = %v", err)
+	}
+	b, _ := NewMessageID("example.org")
+
+	if a == b || !strings.HasSuffix(a, "@example.org") || len(a) != 32+len("@example.org") {
+		t.Errorf("NewMessageID() = %#v, %#v, want unique IDs", a, b)
+	}
+}
@@ -7,9 +7,11 @@ const StubConverterID ID = "stub"
 
 // Stub is the stub converter used for testing purposes
 type Stub struct {
-	StubID  ID
-	Message *Message
-	Err     error
+	jsonResponder
+	StubID     ID
+	StubRoutes []Route
+	Message    *Message
+	Err        error
 }
 
 // ID implements the Converter interface. It returns a stub ID if provided
@@ -21,6 +23,11 @@ func (s *Stub) ID() ID {
 	return StubConverterID
 }
 
+// Routes implements the Converter interface. It returns the stub routes.
+func (s *Stub) Routes() []Route {
+	return s.StubRoutes
+}
+
 // Convert implements the Converter interface. It returns the stub
 // message and error.
 func (s *Stub) Convert(r *http.Request) (*Message, error) {
@@ -1,6 +1,9 @@
 package converter
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestStub_ID(t *testing.T) {
 	s := &Stub{}
@@ -15,3 +18,18 @@ func TestStub_ID(t *testing.T) {
 		t.Errorf("ID() = %#v, want %#v", id, ID("foo"))
 	}
 }
+
+func TestStub_Routes(t *testing.T) {
+	s := &Stub{}
+
+	if routes := s.Routes(); routes != nil {
+		t.Errorf("Routes() = %#v, want nil", routes)
+	}
+
+	want := []Route{{Path: "/stub", Methods: []string{"POST"}}}
+	s = &Stub{StubRoutes: want}
+
+	if routes := s.Routes(); !reflect.DeepEqual(routes, want) {
+		t.Errorf("Routes() = %#v, want %#v", routes, want)
+	}
+}
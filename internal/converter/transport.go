@@ -0,0 +1,56 @@
+package converter
+
+import (
+	"bufio"
+	"io"
+)
+
+// Transport describes the SMTP extensions a message needs to be relayed as it is
+type Transport struct {
+	// EightBit is true when the body has 8-bit octets (8BITMIME, RFC 6152)
+	EightBit bool
+	// UTF8 is true when the header has non-ASCII characters (SMTPUTF8, RFC 6531)
+	UTF8 bool
+}
+
+// Transport scans the message to find the SMTP extensions it needs
+func (m *Message) Transport() (Transport, error) {
+	scan := &normalization{lines: newLineReader(m.Reader()), reencode: map[int]bool{}}
+	if _, err := scan.entity(nil); err != nil {
+		return Transport{}, err
+	}
+
+	return Transport{EightBit: scan.eightBitBody, UTF8: scan.eightBitHeader}, nil
+}
+
+// WriteTo7Bit streams the message to the given writer with its 8-bit body parts
+// quoted-printable encoded, so it can be relayed without 8BITMIME
+func (m *Message) WriteTo7Bit(w io.Writer) (int64, error) {
+	scan := &normalization{lines: newLineReader(m.Reader()), reencode: map[int]bool{}, sevenBit: true}
+	if _, err := scan.entity(nil); err != nil {
+		return 0, err
+	}
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	write := &normalization{lines: newLineReader(m.Reader()), w: bw, reencode: scan.reencode, sevenBit: true}
+	if _, err := write.entity(nil); err != nil {
+		return cw.n, err
+	}
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,102 @@
+package converter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMessage_Transport(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    Transport
+		wantErr bool
+	}{
+		{
+			name: "ASCII message",
+			raw:  "From: test@example.com\r\n\r\nHello\r\n",
+			want: Transport{},
+		},
+		{
+			name: "8-bit body",
+			raw:  "From: test@example.com\r\n\r\nCafé\r\n",
+			want: Transport{EightBit: true},
+		},
+		{
+			name: "UTF-8 header",
+			raw:  "From: José <jose@example.com>\r\n\r\nHello\r\n",
+			want: Transport{UTF8: true},
+		},
+		{
+			name: "UTF-8 part header and 8-bit part",
+			raw:  "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nContent-Disposition: attachment; filename=\"café.txt\"\r\n\r\nCafé\r\n--b--\r\n",
+			want: Transport{EightBit: true, UTF8: true},
+		},
+		{
+			name:    "read error",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var raw Raw = strings.NewReader(tt.raw)
+			if tt.wantErr {
+				raw = &failingReader{}
+			}
+
+			got, err := NewMessage("", nil, nil, nil, raw).Transport()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Transport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Transport() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessage_WriteTo7Bit(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "ASCII message",
+			raw:  "From: test@example.com\r\n\r\nHello\r\n",
+			want: "From: test@example.com\r\n\r\nHello\r\n",
+		},
+		{
+			name: "8-bit message",
+			raw:  "From: test@example.com\r\nContent-Transfer-Encoding: 8bit\r\n\r\nCafé\r\nau lait\r\n",
+			want: "From: test@example.com\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nCaf=C3=A9\r\nau lait\r\n",
+		},
+		{
+			name: "8-bit part",
+			raw:  "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n\r\nHello\r\n--b\r\n\r\nCafé\r\n--b--\r\n",
+			want: "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n\r\nHello\r\n--b\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nCaf=C3=A9\r\n--b--\r\n",
+		},
+		{
+			name:    "8-bit message/rfc822 part",
+			raw:     "Content-Type: message/rfc822\r\n\r\nSubject: Café\r\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &strings.Builder{}
+			n, err := NewMessage("", nil, nil, nil, strings.NewReader(tt.raw)).WriteTo7Bit(b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WriteTo7Bit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if b.String() != tt.want || n != int64(len(tt.want)) {
+				t.Errorf("WriteTo7Bit() = %#v (%d), want %#v", b.String(), n, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+// Package dkim signs messages following RFC 6376 (DomainKeys Identified Mail)
+// with RSA-SHA256 or Ed25519-SHA256 (RFC 8463) keys
+package dkim
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultHeaders lists the header fields signed when present
+var DefaultHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID",
+	"In-Reply-To", "References", "MIME-Version", "Content-Type",
+	"Content-Transfer-Encoding",
+}
+
+// Signer signs the messages of a domain
+type Signer struct {
+	domain   string
+	selector string
+	key      crypto.Signer
+	algo     string
+	headers  []string
+	now      func() time.Time
+}
+
+// NewSigner returns a new signer for the given domain, selector and private
+// key. The key must be either an *rsa.PrivateKey or an ed25519.PrivateKey.
+func NewSigner(domain, selector string, key crypto.Signer) (*Signer, error) {
+	s := &Signer{
+		domain:   strings.ToLower(domain),
+		selector: selector,
+		key:      key,
+		headers:  DefaultHeaders,
+		now:      time.Now,
+	}
+
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		s.algo = "rsa-sha256"
+	case ed25519.PrivateKey:
+		s.algo = "ed25519-sha256"
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+
+	if domain == "" || selector == "" {
+		return nil, errors.New("domain and selector are mandatory")
+	}
+
+	return s, nil
+}
+
+// Domain returns the signing domain
+func (s *Signer) Domain() string {
+	return s.domain
+}
+
+// Sign reads the given message and returns its DKIM-Signature header field,
+// CRLF included, to be prepended to the message. Relaxed canonicalization is
+// used for both the header and the body.
+func (s *Signer) Sign(r io.Reader) (string, error) {
+	br := bufio.NewReader(r)
+
+	fields, err := readHeader(br)
+	if err != nil {
+		return "", fmt.Errorf("failed to read message header: %w", err)
+	}
+
+	bodyHash := sha256.New()
+	if err := canonicalizeBody(bodyHash, br); err != nil {
+		return "", fmt.Errorf("failed to read message body: %w", err)
+	}
+
+	// Only the header fields present are signed, the last instance
+	// of a field being the one signed first
+	var (
+		names  []string
+		signed bytes.Buffer
+	)
+	for _, name := range s.headers {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if strings.EqualFold(fields[i].name, name) {
+				names = append(names, strings.ToLower(name))
+				signed.WriteString(canonicalizeHeader(fields[i].raw) + "\r\n")
+				break
+			}
+		}
+	}
+
+	if len(names) == 0 || names[0] != "from" {
+		return "", errors.New("message has no From header field")
+	}
+
+	value := fmt.Sprintf(
+		"v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s;\r\n\tt=%s; h=%s;\r\n\tbh=%s;\r\n\tb=",
+		s.algo,
+		s.domain,
+		s.selector,
+		strconv.FormatInt(s.now().Unix(), 10),
+		strings.Join(names, ":"),
+		base64.StdEncoding.EncodeToString(bodyHash.Sum(nil)),
+	)
+
+	// The signature field is signed with an empty signature, without CRLF
+	signed.WriteString(canonicalizeHeader("DKIM-Signature: " + value))
+	digest := sha256.Sum256(signed.Bytes())
+
+	opts := crypto.Hash(0)
+	if s.algo == "rsa-sha256" {
+		opts = crypto.SHA256
+	}
+
+	sig, err := s.key.Sign(rand.Reader, digest[:], opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign message: %w", err)
+	}
+
+	return "DKIM-Signature: " + value + fold(base64.StdEncoding.EncodeToString(sig)) + "\r\n", nil
+}
+
+// field is a raw header field
+type field struct {
+	name string
+	// raw is the field as it is, folding included, without its final line break
+	raw string
+}
+
+// readHeader reads the header fields of the message
+func readHeader(r *bufio.Reader) ([]field, error) {
+	var fields []field
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+
+		text := strings.TrimRight(line, "\r\n")
+		if text == "" {
+			return fields, nil
+		}
+
+		if (text[0] == ' ' || text[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1].raw += "\r\n" + text
+		} else {
+			name, _, _ := strings.Cut(text, ":")
+			fields = append(fields, field{name: strings.TrimSpace(name), raw: text})
+		}
+
+		if errors.Is(err, io.EOF) {
+			return fields, nil
+		}
+	}
+}
+
+// canonicalizeHeader returns the relaxed canonical form of a header field,
+// without its final CRLF. See: https://www.rfc-editor.org/rfc/rfc6376#section-3.4.2
+func canonicalizeHeader(raw string) string {
+	name, value, _ := strings.Cut(raw, ":")
+
+	// Unfolds the value and reduces whitespace sequences to a single space
+	value = strings.NewReplacer("\r\n", "", "\n", "").Replace(value)
+	value = strings.Join(strings.FieldsFunc(value, isWSP), " ")
+
+	return strings.ToLower(strings.TrimRight(name, " \t")) + ":" + value
+}
+
+// canonicalizeBody writes the relaxed canonical form of the body.
+// See: https://www.rfc-editor.org/rfc/rfc6376#section-3.4.4
+func canonicalizeBody(w io.Writer, r *bufio.Reader) error {
+	// Empty lines are only written once followed by a non-empty one
+	// so the trailing empty lines are ignored
+	emptyLines := 0
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		if line != "" {
+			text := reduceWSP(strings.TrimRight(strings.TrimRight(line, "\r\n"), " \t"))
+			if text == "" {
+				emptyLines++
+			} else {
+				(io.WriteString(w, strings.Repeat("\r\n", emptyLines)+text+"\r\n"))
+				emptyLines = 0
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+	}
+}
+
+// reduceWSP replaces the whitespace sequences by a single space
+func reduceWSP(s string) string {
+	var b strings.Builder
+	wsp := false
+	for i := 0; i < len(s); i++ {
+		if s[i] == ' ' || s[i] == '\t' {
+			wsp = true
+			continue
+		}
+		if wsp {
+			b.WriteByte(' ')
+			wsp = false
+		}
+		b.WriteByte(s[i])
+	}
+	if wsp {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+func isWSP(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// fold splits the given signature in 72 characters lines
+func fold(s string) string {
+	var b strings.Builder
+	for len(s) > 72 {
+		b.WriteString(s[:72] + "\r\n\t")
+		s = s[72:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
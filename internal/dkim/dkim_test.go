@@ -0,0 +1,279 @@
+package dkim
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const message = "From: Joe SixPack <joe@football.example.com>\r\n" +
+	"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+	"Subject: Is dinner ready?\r\n" +
+	"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+	"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+	"\r\n" +
+	"Hi.\r\n" +
+	"\r\n" +
+	"We lost the game.  Are you hungry yet?\r\n" +
+	"\r\n" +
+	"Joe.\r\n"
+
+func TestSigner_Sign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		key      crypto.Signer
+		message  string
+		wantAlgo string
+		wantH    string
+		wantErr  bool
+	}{
+		{
+			name:     "rsa-sha256",
+			key:      rsaKey,
+			message:  message,
+			wantAlgo: "rsa-sha256",
+			wantH:    "from:subject:date:to:message-id",
+		},
+		{
+			name:     "ed25519-sha256",
+			key:      edKey,
+			message:  message,
+			wantAlgo: "ed25519-sha256",
+			wantH:    "from:subject:date:to:message-id",
+		},
+		{
+			name:     "last header field instance is signed",
+			key:      edKey,
+			message:  "From: joe@football.example.com\r\nSubject: first\r\nSubject:  second\r\n\tfolded \r\n\r\nHi.\r\n",
+			wantAlgo: "ed25519-sha256",
+			wantH:    "from:subject",
+		},
+		{
+			name:     "message without body",
+			key:      rsaKey,
+			message:  "From: joe@football.example.com\r\n",
+			wantAlgo: "rsa-sha256",
+			wantH:    "from",
+		},
+		{
+			name:    "message without From",
+			key:     rsaKey,
+			message: "Subject: Is dinner ready?\r\n\r\nHi.\r\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewSigner("football.example.com", "brisbane", tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.now = func() time.Time { return time.Unix(1528637909, 0) }
+
+			got, err := s.Sign(strings.NewReader(tt.message))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Signer.Sign() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if !strings.HasPrefix(got, "DKIM-Signature: ") || !strings.HasSuffix(got, "\r\n") {
+				t.Fatalf("Signer.Sign() = %#v, want a header field", got)
+			}
+
+			tags := parseTags(got)
+			for tag, want := range map[string]string{
+				"v": "1",
+				"a": tt.wantAlgo,
+				"c": "relaxed/relaxed",
+				"d": "football.example.com",
+				"s": "brisbane",
+				"t": "1528637909",
+				"h": tt.wantH,
+			} {
+				if tags[tag] != want {
+					t.Errorf("Signer.Sign() tag %s = %#v, want %#v", tag, tags[tag], want)
+				}
+			}
+
+			verify(t, got+tt.message, tt.key.Public())
+		})
+	}
+}
+
+func TestNewSigner(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name     string
+		domain   string
+		selector string
+		key      crypto.Signer
+		wantErr  bool
+	}{
+		{name: "valid signer", domain: "Example.COM", selector: "s1", key: edKey},
+		{name: "missing domain", selector: "s1", key: edKey, wantErr: true},
+		{name: "missing selector", domain: "example.com", key: edKey, wantErr: true},
+		{name: "unsupported key", domain: "example.com", selector: "s1", key: &fakeKey{}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewSigner(tt.domain, tt.selector, tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewSigner() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.Domain() != "example.com" {
+				t.Errorf("NewSigner().Domain() = %#v, want %#v", got.Domain(), "example.com")
+			}
+		})
+	}
+}
+
+func Test_canonicalizeHeader(t *testing.T) {
+	// See: https://www.rfc-editor.org/rfc/rfc6376#section-3.4.5
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{raw: "A: X", want: "a:X"},
+		{raw: "B : Y\t\r\n\tZ  ", want: "b:Y Z"},
+		{raw: "Subject:  Is  dinner\r\n ready? ", want: "subject:Is dinner ready?"},
+		{raw: "X-Empty:", want: "x-empty:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			if got := canonicalizeHeader(tt.raw); got != tt.want {
+				t.Errorf("canonicalizeHeader() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_canonicalizeBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		// See: https://www.rfc-editor.org/rfc/rfc6376#section-3.4.5
+		{name: "RFC 6376 example", body: " C \r\nD \t E\r\n\r\n\r\n", want: " C\r\nD E\r\n"},
+		{name: "empty body", body: "", want: ""},
+		{name: "empty lines only", body: "\r\n \r\n\r\n", want: ""},
+		{name: "missing final line break", body: "Hi.\r\n\r\nJoe.", want: "Hi.\r\n\r\nJoe.\r\n"},
+		{name: "LF line breaks", body: "Hi.\n\nJoe.\n\n", want: "Hi.\r\n\r\nJoe.\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got strings.Builder
+			if err := canonicalizeBody(&got, bufio.NewReader(strings.NewReader(tt.body))); err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("canonicalizeBody() = %#v, want %#v", got.String(), tt.want)
+			}
+		})
+	}
+
+	t.Run("RFC 8463 body hash", func(t *testing.T) {
+		// See: https://www.rfc-editor.org/rfc/rfc8463#appendix-A.3
+		h := sha256.New()
+		body := message[strings.Index(message, "\r\n\r\n")+4:]
+		if err := canonicalizeBody(h, bufio.NewReader(strings.NewReader(body))); err != nil {
+			t.Fatal(err)
+		}
+		want := "2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8="
+		if got := base64.StdEncoding.EncodeToString(h.Sum(nil)); got != want {
+			t.Errorf("canonicalizeBody() hash = %#v, want %#v", got, want)
+		}
+	})
+}
+
+var emptySignature = regexp.MustCompile(`[;\s]b=`)
+
+// verify checks the DKIM signature of the given message the way a verifier does.
+// See: https://www.rfc-editor.org/rfc/rfc6376#section-6.1.3
+func verify(t *testing.T, msg string, pub crypto.PublicKey) {
+	t.Helper()
+
+	r := bufio.NewReader(strings.NewReader(msg))
+	fields, err := readHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sigField := fields[0]
+	tags := parseTags(sigField.raw)
+
+	h := sha256.New()
+	if err := canonicalizeBody(h, r); err != nil {
+		t.Fatal(err)
+	}
+	if bh := base64.StdEncoding.EncodeToString(h.Sum(nil)); bh != tags["bh"] {
+		t.Fatalf("body hash = %#v, want %#v", tags["bh"], bh)
+	}
+
+	var signed strings.Builder
+	for _, name := range strings.Split(tags["h"], ":") {
+		for i := len(fields) - 1; i > 0; i-- {
+			if strings.EqualFold(fields[i].name, name) {
+				signed.WriteString(canonicalizeHeader(fields[i].raw) + "\r\n")
+				break
+			}
+		}
+	}
+	// The signature field is signed without the b= tag value
+	b := emptySignature.FindStringIndex(sigField.raw)
+	signed.WriteString(canonicalizeHeader(sigField.raw[:b[1]]))
+
+	digest := sha256.Sum256([]byte(signed.String()))
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, digest[:], sig) {
+			t.Fatal("invalid ed25519 signature")
+		}
+	}
+	if err != nil {
+		t.Fatalf("invalid signature: %v", err)
+	}
+}
+
+// parseTags returns the tags of the given DKIM-Signature header field
+func parseTags(field string) map[string]string {
+	_, value, _ := strings.Cut(field, ":")
+	tags := map[string]string{}
+	for _, tag := range strings.Split(value, ";") {
+		name, v, _ := strings.Cut(tag, "=")
+		v = strings.NewReplacer("\r\n", "", " ", "", "\t", "").Replace(v)
+		tags[strings.TrimSpace(name)] = v
+	}
+	return tags
+}
+
+type fakeKey struct {
+	crypto.Signer
+}
@@ -0,0 +1,143 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Config is the format of the DKIM keys config file
+type Config struct {
+	Keys []KeyConfig `json:"keys"`
+}
+
+// KeyConfig defines the key signing the messages sent from a domain
+type KeyConfig struct {
+	// Domain is the From: domain of the messages to sign, also used as
+	// the signing domain (d= tag)
+	Domain string `json:"domain"`
+	// Selector is the selector (s= tag) of the DNS record holding the public key
+	Selector string `json:"selector"`
+	// PrivateKeyFile is the path of the PEM encoded private key: either a PKCS #1
+	// RSA key or a PKCS #8 RSA or Ed25519 key
+	PrivateKeyFile string `json:"private_key_file"`
+	// Headers overrides the list of the header fields to sign. From is mandatory.
+	Headers []string `json:"headers"`
+}
+
+// Keyring holds the signers of each domain
+type Keyring struct {
+	signers map[string]*Signer
+}
+
+// NewKeyring returns a new keyring for the given signers
+func NewKeyring(signers ...*Signer) *Keyring {
+	k := &Keyring{signers: make(map[string]*Signer, len(signers))}
+	for _, s := range signers {
+		k.signers[s.domain] = s
+	}
+	return k
+}
+
+// LoadKeyring reads the given config file and returns its keyring
+func LoadKeyring(filename string) (*Keyring, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config := &Config{}
+	if err := json.NewDecoder(f).Decode(config); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", filename, err)
+	}
+
+	signers := make([]*Signer, 0, len(config.Keys))
+	seen := make(map[string]bool, len(config.Keys))
+
+	for _, c := range config.Keys {
+		domain := strings.ToLower(c.Domain)
+		if seen[domain] {
+			return nil, fmt.Errorf("invalid config %s: duplicate domain %s", filename, c.Domain)
+		}
+		seen[domain] = true
+
+		data, err := os.ReadFile(c.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config %s: %w", filename, err)
+		}
+
+		key, err := ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config %s: %s: %w", filename, c.PrivateKeyFile, err)
+		}
+
+		s, err := NewSigner(c.Domain, c.Selector, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config %s: domain %s: %w", filename, c.Domain, err)
+		}
+
+		if len(c.Headers) > 0 {
+			if !containsFold(c.Headers, "From") {
+				return nil, fmt.Errorf("invalid config %s: domain %s: From must be signed", filename, c.Domain)
+			}
+			s.headers = c.Headers
+		}
+
+		signers = append(signers, s)
+	}
+
+	return NewKeyring(signers...), nil
+}
+
+// Signer returns the signer of the given domain, nil when there is none
+func (k *Keyring) Signer(domain string) *Signer {
+	if k == nil {
+		return nil
+	}
+	return k.signers[strings.ToLower(domain)]
+}
+
+// ParsePrivateKey parses a PEM encoded RSA or Ed25519 private key
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded key found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			return key, nil
+		case ed25519.PrivateKey:
+			return key, nil
+		default:
+			return nil, fmt.Errorf("unsupported key type %T", key)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package dkim
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLoadKeyring(t *testing.T) {
+	dir := t.TempDir()
+	writeKeys(t, dir)
+
+	tests := []struct {
+		name        string
+		config      string
+		wantDomains []string
+		wantHeaders []string
+		wantErr     bool
+	}{
+		{
+			name:    "invalid JSON",
+			config:  `[`,
+			wantErr: true,
+		},
+		{
+			name:    "missing key file",
+			config:  `{"keys":[{"domain":"example.com","selector":"s1","private_key_file":"$DIR/ghost.pem"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid key file",
+			config:  `{"keys":[{"domain":"example.com","selector":"s1","private_key_file":"$DIR/invalid.pem"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "missing selector",
+			config:  `{"keys":[{"domain":"example.com","private_key_file":"$DIR/rsa.pem"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "unsigned From",
+			config:  `{"keys":[{"domain":"example.com","selector":"s1","private_key_file":"$DIR/rsa.pem","headers":["Subject"]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "duplicate domain",
+			config:  `{"keys":[{"domain":"example.com","selector":"s1","private_key_file":"$DIR/rsa.pem"},{"domain":"EXAMPLE.com","selector":"s2","private_key_file":"$DIR/ed25519.pem"}]}`,
+			wantErr: true,
+		},
+		{
+			name:        "several keys",
+			config:      `{"keys":[{"domain":"example.com","selector":"s1","private_key_file":"$DIR/rsa.pem"},{"domain":"Example.ORG","selector":"s2","private_key_file":"$DIR/ed25519.pem","headers":["from","subject"]}]}`,
+			wantDomains: []string{"example.com", "example.org"},
+			wantHeaders: []string{"from", "subject"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(dir, "keys.json")
+			config := strings.ReplaceAll(tt.config, "$DIR", dir)
+			if err := os.WriteFile(filename, []byte(config), 0o600); err != nil {
+				t.Fatalf("could not write config file: %v", err)
+			}
+
+			got, err := LoadKeyring(filename)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadKeyring() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			domains := []string{}
+			for domain := range got.signers {
+				domains = append(domains, domain)
+			}
+			sort.Strings(domains)
+			if !reflect.DeepEqual(domains, tt.wantDomains) {
+				t.Errorf("LoadKeyring() domains = %#v, want %#v", domains, tt.wantDomains)
+			}
+			if headers := got.Signer("example.org").headers; !reflect.DeepEqual(headers, tt.wantHeaders) {
+				t.Errorf("LoadKeyring() headers = %#v, want %#v", headers, tt.wantHeaders)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := LoadKeyring(filepath.Join(t.TempDir(), "ghost.json")); err == nil {
+			t.Error("LoadKeyring() error = nil, want an error")
+		}
+	})
+}
+
+func TestKeyring_Signer(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := NewSigner("example.com", "s1", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		keyring *Keyring
+		domain  string
+		want    *Signer
+	}{
+		{name: "nil keyring", domain: "example.com"},
+		{name: "unknown domain", keyring: NewKeyring(signer), domain: "example.org"},
+		{name: "known domain", keyring: NewKeyring(signer), domain: "example.com", want: signer},
+		{name: "case insensitive domain", keyring: NewKeyring(signer), domain: "EXAMPLE.com", want: signer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.keyring.Signer(tt.domain); got != tt.want {
+				t.Errorf("Keyring.Signer() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	dir := t.TempDir()
+	writeKeys(t, dir)
+
+	tests := []struct {
+		file     string
+		wantType string
+		wantErr  bool
+	}{
+		{file: "rsa.pem", wantType: "*rsa.PrivateKey"},
+		{file: "rsa-pkcs8.pem", wantType: "*rsa.PrivateKey"},
+		{file: "ed25519.pem", wantType: "ed25519.PrivateKey"},
+		{file: "invalid.pem", wantErr: true},
+		{file: "public.pem", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join(dir, tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ParsePrivateKey(data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePrivateKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if gotType := reflect.TypeOf(got); !tt.wantErr && gotType.String() != tt.wantType {
+				t.Errorf("ParsePrivateKey() = %v, want %v", gotType, tt.wantType)
+			}
+		})
+	}
+}
+
+// writeKeys writes PEM encoded test keys into the given directory
+func writeKeys(t *testing.T, dir string) {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaPKCS8, _ := x509.MarshalPKCS8PrivateKey(rsaKey)
+	edPKCS8, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	pubPKIX, _ := x509.MarshalPKIXPublicKey(pub)
+
+	for file, block := range map[string]*pem.Block{
+		"rsa.pem":       {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)},
+		"rsa-pkcs8.pem": {Type: "PRIVATE KEY", Bytes: rsaPKCS8},
+		"ed25519.pem":   {Type: "PRIVATE KEY", Bytes: edPKCS8},
+		"public.pem":    {Type: "PUBLIC KEY", Bytes: pubPKIX},
+	} {
+		if err := os.WriteFile(filepath.Join(dir, file), pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "invalid.pem"), []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -9,8 +9,118 @@ type Bag struct {
 	// injected into all logs entries related to the same request. This is used to track
 	// the a request trace within the underlying services
 	HTTPTraceHeader string `envconfig:"TRACEPARENT_HEADER" default:"traceparent"`
-	// SMTPAddr is the hostname:port config of the SMTP server the app forwards emails to
-	SMTPAddr string `envconfig:"SMTP_ADDR" required:"true"`
+	// SMTPAddr is the comma-separated list of the hostname:port configs of the SMTP
+	// servers the app forwards emails to. Each one can be followed by its weight
+	// (e.g. smtp1:25=3) used by the weighted strategy.
+	SMTPAddr []string `envconfig:"SMTP_ADDR" required:"true"`
+	// SMTPStrategy is how the SMTP server is chosen for each message when several are
+	// given: priority (the first healthy one), round-robin or weighted
+	SMTPStrategy string `envconfig:"SMTP_STRATEGY" default:"priority"`
+	// SMTPCircuitFailures is the number of consecutive transient failures after which
+	// an SMTP server is skipped for SMTPCircuitCooldown seconds. Never skipped when 0.
+	SMTPCircuitFailures int `envconfig:"SMTP_CIRCUIT_FAILURES" default:"3"`
+	SMTPCircuitCooldown int `envconfig:"SMTP_CIRCUIT_COOLDOWN" default:"30"`
+	// SMTPTimeout is a duration in seconds bounding the dial to the SMTP server and each
+	// command sent to it. Only the request deadline applies when 0.
+	SMTPTimeout int `envconfig:"SMTP_TIMEOUT" default:"30"`
+	// SMTPTLS is how the connection to the SMTP server is secured: none, starttls
+	// (when supported by the server), starttls-required or implicit (SMTPS)
+	SMTPTLS string `envconfig:"SMTP_TLS" default:"none"`
+	// SMTPTLSCAFile is the PEM bundle of the CA certificates trusted in addition
+	// to the system ones
+	SMTPTLSCAFile string `envconfig:"SMTP_TLS_CA_FILE"`
+	// SMTPTLSCertFile and SMTPTLSKeyFile are the PEM client certificate and key
+	SMTPTLSCertFile string `envconfig:"SMTP_TLS_CERT_FILE"`
+	SMTPTLSKeyFile  string `envconfig:"SMTP_TLS_KEY_FILE"`
+	// SMTPTLSInsecureSkipVerify disables the server certificate verification so
+	// self-signed test relays can be used
+	SMTPTLSInsecureSkipVerify bool `envconfig:"SMTP_TLS_INSECURE_SKIP_VERIFY" default:"false"`
+	// SMTPUsername enables the authentication to the SMTP server (SMTP AUTH)
+	SMTPUsername string `envconfig:"SMTP_USERNAME"`
+	// SMTPPassword is the password of SMTPUsername. It is read from
+	// SMTPPasswordFile when empty.
+	SMTPPassword     string `envconfig:"SMTP_PASSWORD"`
+	SMTPPasswordFile string `envconfig:"SMTP_PASSWORD_FILE"`
+	// SMTPOAuth2Token is the OAuth 2.0 access token used with the XOAUTH2 mechanism
+	// instead of the password. It is read from SMTPOAuth2TokenFile when empty.
+	SMTPOAuth2Token     string `envconfig:"SMTP_OAUTH2_TOKEN"`
+	SMTPOAuth2TokenFile string `envconfig:"SMTP_OAUTH2_TOKEN_FILE"`
+	// SMTPAuthMechanism forces the authentication mechanism: PLAIN, LOGIN, CRAM-MD5
+	// or XOAUTH2. It is otherwise chosen amongst the ones advertised by the server.
+	SMTPAuthMechanism string `envconfig:"SMTP_AUTH_MECHANISM"`
+	// SMTPPoolMaxActive is the max number of SMTP connections in use at once
+	SMTPPoolMaxActive int `envconfig:"SMTP_POOL_MAX_ACTIVE" default:"4"`
+	// SMTPPoolMaxIdle is the max number of idle SMTP connections kept open
+	SMTPPoolMaxIdle int `envconfig:"SMTP_POOL_MAX_IDLE" default:"2"`
+	// SMTPPoolIdleTimeout is a duration in seconds after which an idle SMTP connection
+	// is closed. Idle connections are kept open when 0.
+	SMTPPoolIdleTimeout int `envconfig:"SMTP_POOL_IDLE_TIMEOUT" default:"60"`
+	// SMTPPoolMaxMessages is the max number of messages sent over an SMTP connection
+	// before it is closed. No limit when 0.
+	SMTPPoolMaxMessages int `envconfig:"SMTP_POOL_MAX_MESSAGES" default:"100"`
+	// SMTPRetryAttempts is the max number of attempts of a sending failing transiently
+	// (4xx replies, network errors), the first one included
+	SMTPRetryAttempts int `envconfig:"SMTP_RETRY_ATTEMPTS" default:"3"`
+	// SMTPRetryBackoff is the delay in seconds before the first retry. It doubles
+	// at each retry, up to SMTPRetryBackoffMax seconds.
+	SMTPRetryBackoff    int `envconfig:"SMTP_RETRY_BACKOFF" default:"1"`
+	SMTPRetryBackoffMax int `envconfig:"SMTP_RETRY_BACKOFF_MAX" default:"10"`
+	// BounceAddress is the envelope sender (MAIL FROM) of the messages that don't request
+	// a return path. The message From: address is used when empty.
+	BounceAddress string `envconfig:"BOUNCE_ADDRESS"`
+	// VERP enables the encoding of each recipient address into the envelope sender
+	// (e.g. bounces+bob=example.com@example.org). Each recipient gets its own transaction.
+	VERP bool `envconfig:"VERP" default:"false"`
+	// MessageIDDomain is the domain of the Message-IDs generated for the messages
+	// without any. It is also the host name recorded in the Received: fields.
+	// Defaults to the machine host name.
+	MessageIDDomain string `envconfig:"MESSAGE_ID_DOMAIN"`
+	// DKIMKeysFile is the path of the config file defining the DKIM key of each From:
+	// domain. The messages are not signed when empty.
+	DKIMKeysFile string `envconfig:"DKIM_KEYS_FILE"`
+	// FiltersFile is the path of the config file defining the chain of filters applied
+	// to the messages before they are sent. No filter is applied when empty.
+	FiltersFile string `envconfig:"FILTERS_FILE"`
+	// JSONMappingsFile is the path of the config file defining the JSON mapping converters.
+	// No JSON mapping converter is registered when empty.
+	JSONMappingsFile string `envconfig:"JSON_MAPPINGS_FILE"`
+	// EnabledConverters is the comma-separated list of the converter IDs whose routes are
+	// exposed by the app. All the available converters are exposed when empty. The app
+	// does not start if an ID is unknown.
+	EnabledConverters []string `envconfig:"ENABLED_CONVERTERS"`
+	// SpoolDir is the directory where the messages bigger than SpoolThreshold are
+	// temporarily written while being sent. Defaults to the system temporary directory.
+	SpoolDir string `envconfig:"SPOOL_DIR"`
+	// SpoolThreshold is the max size in bytes of a message kept in memory while being sent
+	SpoolThreshold int64 `envconfig:"SPOOL_THRESHOLD" default:"1048576"`
+	// QueueDir enables the asynchronous delivery: the messages are written to this
+	// directory and delivered by QueueWorkers workers while the requests return
+	// right away. Messages are sent synchronously when empty.
+	QueueDir     string `envconfig:"QUEUE_DIR"`
+	QueueWorkers int    `envconfig:"QUEUE_WORKERS" default:"4"`
+	// QueueRetryAttempts is the max number of delivery attempts of a queued message
+	QueueRetryAttempts int `envconfig:"QUEUE_RETRY_ATTEMPTS" default:"10"`
+	// QueueRetryBackoff is the delay in seconds before the first retry of a queued
+	// message. It doubles at each retry, up to QueueRetryBackoffMax seconds.
+	QueueRetryBackoff    int `envconfig:"QUEUE_RETRY_BACKOFF" default:"60"`
+	QueueRetryBackoffMax int `envconfig:"QUEUE_RETRY_BACKOFF_MAX" default:"3600"`
+	// QueueDeadLetterDir is the directory where the queued messages whose delivery failed
+	// permanently are kept. Defaults to the dead-letter sub-directory of QueueDir.
+	QueueDeadLetterDir string `envconfig:"QUEUE_DEAD_LETTER_DIR"`
+	// AdminToken enables the admin routes (e.g. dead letters), authenticated with
+	// this bearer token
+	AdminToken string `envconfig:"ADMIN_TOKEN"`
+	// MaxMessageSize is the max size in bytes of the converted messages. No limit when 0.
+	MaxMessageSize int64 `envconfig:"MAX_MESSAGE_SIZE" default:"0"`
+	// MaxAttachments is the max number of attachments of a message. No limit when 0.
+	MaxAttachments int `envconfig:"MAX_ATTACHMENTS" default:"0"`
+	// MaxAttachmentSize is the max encoded size in bytes of each attachment. No limit when 0.
+	MaxAttachmentSize int64 `envconfig:"MAX_ATTACHMENT_SIZE" default:"0"`
+	// MaxRecipients is the max number of recipients of a transmission. No limit when 0.
+	MaxRecipients int `envconfig:"MAX_RECIPIENTS" default:"0"`
+	// StrictMode rejects the messages with bare CR or LF, NUL bytes or lines longer than
+	// 998 octets with a 422 status instead of fixing them
+	StrictMode bool `envconfig:"STRICT_MODE" default:"false"`
 	// LogLevel is the level of log generated by the app
 	LogLevel string `envconfig:"LOG_LEVEL" default:"info"`
 }
@@ -0,0 +1,223 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eexit/http2smtp/internal/converter"
+)
+
+// transcriptExt is the extension of the SMTP transcript of a dead letter
+const transcriptExt = ".log"
+
+// ErrNotFound is returned when the dead letter does not exist
+var ErrNotFound = errors.New("dead letter not found")
+
+// validID matches the queue IDs so no path can be given as ID
+var validID = regexp.MustCompile(`^[0-9A-Za-z-]+$`)
+
+// WithDeadLetterDir sets the directory where the messages whose delivery
+// failed permanently are kept. It must be on the same file system as the
+// queue directory.
+func WithDeadLetterDir(dir string) Option {
+	return func(q *Queue) {
+		q.deadLetterDir = dir
+	}
+}
+
+// DeadLetter is a message whose delivery failed permanently
+type DeadLetter struct {
+	ID        string `json:"id"`
+	MessageID string `json:"message_id"`
+	TraceID   string `json:"trace_id,omitempty"`
+	// Envelope holds the recipients the message was not delivered to
+	Envelope  converter.Envelope `json:"envelope"`
+	Attempts  int                `json:"attempts"`
+	CreatedAt time.Time          `json:"created_at"`
+	FailedAt  time.Time          `json:"failed_at"`
+	Error     string             `json:"error"`
+	// Transcript is the SMTP transcript of the last delivery attempt
+	Transcript string `json:"transcript,omitempty"`
+}
+
+func newDeadLetter(e *entry) DeadLetter {
+	return DeadLetter{
+		ID:        e.ID,
+		MessageID: e.MessageID,
+		TraceID:   e.TraceID,
+		Envelope:  e.Envelope,
+		Attempts:  e.Attempts,
+		CreatedAt: e.CreatedAt,
+		FailedAt:  e.FailedAt,
+		Error:     e.LastError,
+	}
+}
+
+// bury moves a queued message to the dead letters along with the transcript
+// of its last delivery attempt
+func (q *Queue) bury(e *entry, transcript string) error {
+	e.FailedAt = q.now()
+
+	if err := writeFile(q.dead.path(e.ID, transcriptExt), func(w io.Writer) (int64, error) {
+		n, err := io.WriteString(w, transcript)
+		return int64(n), err
+	}); err != nil {
+		return err
+	}
+
+	if err := q.dead.update(e); err != nil {
+		return err
+	}
+
+	if err := os.Rename(q.spool.path(e.ID, messageExt), q.dead.path(e.ID, messageExt)); err != nil {
+		return err
+	}
+
+	return q.spool.remove(e.ID)
+}
+
+// DeadLetters returns the dead letters, oldest first. Their transcript is not
+// returned.
+func (q *Queue) DeadLetters() ([]DeadLetter, error) {
+	files, err := os.ReadDir(q.dead.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	letters := []DeadLetter{}
+	for _, f := range files {
+		id := strings.TrimSuffix(f.Name(), metadataExt)
+		if f.IsDir() || filepath.Ext(f.Name()) != metadataExt || !q.dead.exists(id) {
+			continue
+		}
+
+		e, err := q.dead.entry(id)
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, newDeadLetter(e))
+	}
+
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].ID < letters[j].ID
+	})
+	return letters, nil
+}
+
+// DeadLetter returns a dead letter along with its transcript
+func (q *Queue) DeadLetter(id string) (*DeadLetter, error) {
+	e, err := q.deadEntry(id)
+	if err != nil {
+		return nil, err
+	}
+
+	letter := newDeadLetter(e)
+
+	transcript, err := os.ReadFile(q.dead.path(id, transcriptExt))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	letter.Transcript = string(transcript)
+
+	return &letter, nil
+}
+
+// DeadLetterMessage returns the raw content of a dead letter. It must be
+// closed by the caller.
+func (q *Queue) DeadLetterMessage(id string) (io.ReadCloser, error) {
+	if _, err := q.deadEntry(id); err != nil {
+		return nil, err
+	}
+	return os.Open(q.dead.path(id, messageExt))
+}
+
+// Requeue queues a dead letter again for delivery to the recipients it was
+// not delivered to
+func (q *Queue) Requeue(id string) error {
+	q.mu.Lock()
+	closed := q.closed
+	q.mu.Unlock()
+	if closed {
+		return errors.New("delivery queue is closed")
+	}
+
+	// A dead letter requeued twice at once would be queued twice
+	q.deadMu.Lock()
+	defer q.deadMu.Unlock()
+
+	e, err := q.deadEntry(id)
+	if err != nil {
+		return err
+	}
+
+	e.Attempts, e.LastError = 0, ""
+	e.FailedAt, e.NextAttempt = time.Time{}, q.now()
+
+	// The message is queued before being removed from the dead letters so
+	// it can't be lost in between
+	if err := q.spool.update(e); err != nil {
+		return fmt.Errorf("failed to requeue message: %w", err)
+	}
+
+	if err := os.Rename(q.dead.path(id, messageExt), q.spool.path(id, messageExt)); err != nil {
+		(os.Remove(q.spool.path(id, metadataExt)))
+		return fmt.Errorf("failed to requeue message: %w", err)
+	}
+
+	if err := q.removeDeadLetter(id); err != nil {
+		q.logger.Error().Err(err).Str("queue_id", id).Msg("failed to remove requeued dead letter")
+	}
+
+	logger := q.entryLogger(e)
+	logger.Info().Msg("dead letter requeued")
+	q.schedule(e)
+
+	return nil
+}
+
+// DeleteDeadLetter deletes a dead letter
+func (q *Queue) DeleteDeadLetter(id string) error {
+	q.deadMu.Lock()
+	defer q.deadMu.Unlock()
+
+	if _, err := q.deadEntry(id); err != nil {
+		return err
+	}
+
+	if err := os.Remove(q.dead.path(id, messageExt)); err != nil {
+		return err
+	}
+	return q.removeDeadLetter(id)
+}
+
+// deadEntry returns the metadata of a dead letter
+func (q *Queue) deadEntry(id string) (*entry, error) {
+	if !validID.MatchString(id) || !q.dead.exists(id) {
+		return nil, ErrNotFound
+	}
+
+	e, err := q.dead.entry(id)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return e, err
+}
+
+// removeDeadLetter removes the metadata and the transcript of a dead letter
+func (q *Queue) removeDeadLetter(id string) error {
+	var errs []error
+	for _, ext := range []string{metadataExt, transcriptExt} {
+		if err := os.Remove(q.dead.path(id, ext)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
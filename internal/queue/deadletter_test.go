@@ -0,0 +1,145 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/textproto"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/eexit/http2smtp/internal/converter"
+	"github.com/rs/zerolog"
+)
+
+// newDeadLetterQueue returns a queue holding a single dead letter
+func newDeadLetterQueue(t *testing.T, client *fakeClient) (*Queue, DeadLetter) {
+	t.Helper()
+
+	dir := t.TempDir()
+	q, err := New(dir, client, zerolog.Nop(), WithDeadLetterDir(filepath.Join(dir, "dead")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { (q.Close()) })
+
+	envelope := converter.Envelope{From: "from@example.com", To: []string{"to@example.com"}}
+	if _, err := q.Send(context.Background(), newTestMessage(t, envelope)); err != nil {
+		t.Fatalf("Queue.Send() error = %v", err)
+	}
+
+	var letters []DeadLetter
+	waitFor(t, func() bool {
+		letters, err = q.DeadLetters()
+		return err == nil && len(letters) == 1
+	})
+	return q, letters[0]
+}
+
+func TestQueue_DeadLetter(t *testing.T) {
+	client := &fakeClient{errs: []error{&textproto.Error{Code: 550, Msg: "mailbox unavailable"}}}
+	q, letter := newDeadLetterQueue(t, client)
+
+	if letter.Error != client.errs[0].Error() || letter.Attempts != 1 || letter.FailedAt.IsZero() {
+		t.Errorf("Queue.DeadLetters() = %#v, want the delivery error", letter)
+	}
+
+	got, err := q.DeadLetter(letter.ID)
+	if err != nil {
+		t.Fatalf("Queue.DeadLetter() error = %v", err)
+	}
+	if !reflect.DeepEqual(*got, letter) {
+		t.Errorf("Queue.DeadLetter() = %#v, want %#v", *got, letter)
+	}
+
+	r, err := q.DeadLetterMessage(letter.ID)
+	if err != nil {
+		t.Fatalf("Queue.DeadLetterMessage() error = %v", err)
+	}
+	defer r.Close()
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != testRaw {
+		t.Errorf("Queue.DeadLetterMessage() = %q, want %q", content, testRaw)
+	}
+
+	for _, id := range []string{"unknown", "../" + letter.ID, ""} {
+		if _, err := q.DeadLetter(id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Queue.DeadLetter(%q) error = %v, want %v", id, err, ErrNotFound)
+		}
+	}
+}
+
+func TestQueue_Requeue(t *testing.T) {
+	client := &fakeClient{errs: []error{&textproto.Error{Code: 550, Msg: "mailbox unavailable"}}}
+	q, letter := newDeadLetterQueue(t, client)
+
+	if err := q.Requeue(letter.ID); err != nil {
+		t.Fatalf("Queue.Requeue() error = %v", err)
+	}
+
+	waitFor(t, func() bool { return client.calls() == 2 && len(spooled(t, q.spool.dir)) == 0 })
+
+	if got := spooled(t, q.dead.dir); len(got) != 0 {
+		t.Errorf("dead letter files = %v, want none", got)
+	}
+
+	if err := q.Requeue(letter.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Queue.Requeue() error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestQueue_Requeue_concurrent(t *testing.T) {
+	client := &fakeClient{errs: []error{&textproto.Error{Code: 550, Msg: "mailbox unavailable"}}}
+	q, letter := newDeadLetterQueue(t, client)
+
+	const requeues = 10
+	start, errs := make(chan struct{}), make(chan error, requeues)
+	for i := 0; i < requeues; i++ {
+		go func() {
+			<-start
+			errs <- q.Requeue(letter.ID)
+		}()
+	}
+	close(start)
+
+	requeued := 0
+	for i := 0; i < requeues; i++ {
+		switch err := <-errs; {
+		case err == nil:
+			requeued++
+		case !errors.Is(err, ErrNotFound):
+			t.Errorf("Queue.Requeue() error = %v, want %v", err, ErrNotFound)
+		}
+	}
+	if requeued != 1 {
+		t.Errorf("requeued dead letters = %v, want 1", requeued)
+	}
+
+	waitFor(t, func() bool { return client.calls() == 2 && len(spooled(t, q.spool.dir)) == 0 })
+}
+
+func TestQueue_DeleteDeadLetter(t *testing.T) {
+	client := &fakeClient{errs: []error{&textproto.Error{Code: 550, Msg: "mailbox unavailable"}}}
+	q, letter := newDeadLetterQueue(t, client)
+
+	if err := q.DeleteDeadLetter(letter.ID); err != nil {
+		t.Fatalf("Queue.DeleteDeadLetter() error = %v", err)
+	}
+
+	letters, err := q.DeadLetters()
+	if err != nil {
+		t.Fatalf("Queue.DeadLetters() error = %v", err)
+	}
+	if len(letters) != 0 || len(spooled(t, q.dead.dir)) != 0 {
+		t.Errorf("Queue.DeadLetters() = %#v, want none", letters)
+	}
+
+	if err := q.DeleteDeadLetter(letter.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Queue.DeleteDeadLetter() error = %v, want %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,344 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/eexit/http2smtp/internal/converter"
+	ictx "github.com/eexit/http2smtp/internal/ctx"
+	"github.com/eexit/http2smtp/internal/smtp"
+	"github.com/rs/zerolog"
+)
+
+// idRandomBytes is the number of random bytes of the queue IDs
+const idRandomBytes = 8
+
+// DefaultWorkers is the number of workers unless WithWorkers is given
+const DefaultWorkers = 4
+
+// DefaultRetryPolicy is the retry policy of the failed deliveries unless
+// WithRetry is given
+var DefaultRetryPolicy = smtp.RetryPolicy{
+	Attempts: 10,
+	Backoff:  smtp.Backoff{Min: time.Minute, Max: time.Hour},
+}
+
+// Queue is an asynchronous delivery queue implementing smtp.Client: the messages
+// are written to a spool directory and delivered later by a pool of workers
+// using the given client. Queued messages survive the process restarts.
+type Queue struct {
+	spool *spool
+	// dead holds the messages whose delivery failed permanently
+	dead          *spool
+	deadLetterDir string
+	client        smtp.Client
+	logger        zerolog.Logger
+	workers       int
+	retry         smtp.RetryPolicy
+	// ready receives the IDs of the messages due for delivery
+	ready chan string
+	done  chan struct{}
+	// ctx is the context of the deliveries, canceled to interrupt them
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+	closed bool
+	// deadMu serializes the changes of the dead letters
+	deadMu sync.Mutex
+	now    func() time.Time
+}
+
+// Option configures the queue
+type Option func(*Queue)
+
+// WithWorkers sets the number of messages delivered concurrently
+func WithWorkers(n int) Option {
+	return func(q *Queue) {
+		q.workers = n
+	}
+}
+
+// WithRetry sets the retry policy of the deliveries failing transiently
+func WithRetry(policy smtp.RetryPolicy) Option {
+	return func(q *Queue) {
+		q.retry = policy
+	}
+}
+
+// New returns a new queue spooling messages to the given directory and starts
+// its workers. The messages left in the directory by a previous run are
+// delivered again.
+func New(dir string, client smtp.Client, logger zerolog.Logger, opts ...Option) (*Queue, error) {
+	s, err := openSpool(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		spool:   s,
+		client:  client,
+		logger:  logger.With().Str("queue_dir", dir).Logger(),
+		workers: DefaultWorkers,
+		retry:   DefaultRetryPolicy,
+		ready:   make(chan string),
+		done:    make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+		timers:  make(map[string]*time.Timer),
+		now:     time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(q)
+	}
+	if q.workers < 1 {
+		q.workers = 1
+	}
+	if q.deadLetterDir == "" {
+		q.deadLetterDir = filepath.Join(dir, "dead-letter")
+	}
+
+	if q.dead, err = openSpool(q.deadLetterDir); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	entries, err := q.spool.recover()
+	if err != nil {
+		q.logger.Warn().Err(err).Msg("failed to recover some queued messages")
+	}
+
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+
+	for _, e := range entries {
+		q.schedule(e)
+	}
+
+	q.logger.Info().Int("workers", q.workers).Int("pending", len(entries)).Msg("delivery queue started")
+
+	return q, nil
+}
+
+// Send queues the message and returns its number of recipients. The message
+// is delivered asynchronously.
+func (q *Queue) Send(ctx context.Context, msg *converter.Message) (int, error) {
+	if msg == nil {
+		return 0, errors.New("failed to process nil message")
+	}
+
+	if !msg.HasRecipients() {
+		return 0, errors.New("message has no recipient")
+	}
+
+	q.mu.Lock()
+	closed := q.closed
+	q.mu.Unlock()
+	if closed {
+		return 0, errors.New("delivery queue is closed")
+	}
+
+	now := q.now()
+	id, err := newID(now)
+	if err != nil {
+		return 0, err
+	}
+
+	e := &entry{
+		ID:          id,
+		MessageID:   msg.MessageID(),
+		TraceID:     ictx.TraceID(ctx),
+		Envelope:    msg.Envelope(),
+		CreatedAt:   now,
+		NextAttempt: now,
+	}
+
+	if err := q.spool.write(e, msg); err != nil {
+		return 0, fmt.Errorf("failed to queue message: %w", err)
+	}
+
+	logger := q.entryLogger(e)
+	logger.Info().Int64("size", msg.Size()).Msg("message queued")
+	q.schedule(e)
+
+	return recipients(e.Envelope), nil
+}
+
+// Close stops the workers, interrupting their current delivery, and closes
+// the client. The pending messages are kept in the spool directory.
+func (q *Queue) Close() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return q.Shutdown(ctx)
+}
+
+// Shutdown stops the workers once their current delivery is done and closes
+// the client. The deliveries still running when ctx is done are interrupted
+// and retried on the next run. The pending messages are kept in the spool
+// directory.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	for _, t := range q.timers {
+		t.Stop()
+	}
+	q.mu.Unlock()
+
+	q.logger.Info().Msg("closing delivery queue")
+	close(q.done)
+
+	stopped := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		q.logger.Warn().Msg("interrupting the current deliveries")
+		q.cancel()
+		<-stopped
+	}
+	q.cancel()
+
+	return q.client.Close()
+}
+
+// schedule delivers the message once its next attempt is due
+func (q *Queue) schedule(e *entry) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+
+	id := e.ID
+	q.timers[id] = time.AfterFunc(e.NextAttempt.Sub(q.now()), func() {
+		select {
+		case q.ready <- id:
+		case <-q.done:
+		}
+	})
+}
+
+// work delivers the messages until the queue is closed
+func (q *Queue) work() {
+	defer q.wg.Done()
+
+	for {
+		select {
+		case <-q.done:
+			return
+		case id := <-q.ready:
+			q.mu.Lock()
+			delete(q.timers, id)
+			q.mu.Unlock()
+
+			q.deliver(id)
+		}
+	}
+}
+
+// deliver sends a queued message. Deliveries failing transiently are
+// rescheduled with backoff for the recipients not delivered yet.
+func (q *Queue) deliver(id string) {
+	e, msg, err := q.spool.read(id)
+	if err != nil {
+		q.logger.Error().Err(err).Str("queue_id", id).Msg("failed to read queued message")
+		return
+	}
+
+	logger := q.entryLogger(e)
+	transcript := &smtp.Transcript{}
+	ctx := smtp.WithTranscript(ictx.WithTraceID(q.ctx, e.TraceID), transcript)
+
+	accepted, err := q.client.Send(ctx, msg)
+	(msg.Close())
+
+	// The message is delivered again on the next run, the attempt not counted
+	if q.ctx.Err() != nil && err != nil {
+		var deliveryErr *smtp.DeliveryError
+		if errors.As(err, &deliveryErr) {
+			e.Envelope = e.Envelope.Without(deliveryErr.Delivered)
+			if err := q.spool.update(e); err != nil {
+				logger.Error().Err(err).Msg("failed to update queued message")
+			}
+		}
+		logger.Warn().Err(err).Msg("queued message delivery interrupted")
+		return
+	}
+
+	e.Attempts++
+
+	if err == nil {
+		logger.Info().Int("accepted", accepted).Int("attempts", e.Attempts).Msg("queued message delivered")
+		if err := q.spool.remove(id); err != nil {
+			logger.Error().Err(err).Msg("failed to remove delivered message")
+		}
+		return
+	}
+
+	var deliveryErr *smtp.DeliveryError
+	if errors.As(err, &deliveryErr) {
+		e.Envelope = e.Envelope.Without(deliveryErr.Delivered)
+	}
+	e.LastError = err.Error()
+
+	if !smtp.IsTransient(err) || e.Attempts >= q.retry.Attempts {
+		logger.Error().Err(err).Int("attempts", e.Attempts).Msg("queued message delivery failed, moved to dead letters")
+		if err := q.bury(e, transcript.String()); err != nil {
+			logger.Error().Err(err).Msg("failed to move message to dead letters")
+		}
+		return
+	}
+
+	e.NextAttempt = q.now().Add(q.retry.Backoff.Delay(e.Attempts))
+	if err := q.spool.update(e); err != nil {
+		logger.Error().Err(err).Msg("failed to update queued message")
+	}
+
+	logger.Warn().Err(err).
+		Int("attempts", e.Attempts).
+		Time("next_attempt", e.NextAttempt).
+		Msg("queued message delivery failed, retry scheduled")
+	q.schedule(e)
+}
+
+func (q *Queue) entryLogger(e *entry) zerolog.Logger {
+	logger := q.logger.With().Str("queue_id", e.ID).Str("message_id", e.MessageID)
+	if e.TraceID != "" {
+		logger = logger.Str("trace_id", e.TraceID)
+	}
+	return logger.Logger()
+}
+
+// newID returns a new queue ID, sortable by queuing time
+func newID(t time.Time) (string, error) {
+	b := make([]byte, idRandomBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate queue id: %w", err)
+	}
+	return t.UTC().Format("20060102T150405") + "-" + hex.EncodeToString(b), nil
+}
+
+// recipients returns the number of recipients of the envelope
+func recipients(e converter.Envelope) int {
+	return len(e.To) + len(e.Cc) + len(e.Bcc)
+}
@@ -0,0 +1,458 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eexit/http2smtp/internal/converter"
+	"github.com/eexit/http2smtp/internal/smtp"
+	"github.com/rs/zerolog"
+)
+
+const testRaw = "From: from@example.com\r\nTo: to@example.com\r\nSubject: test\r\n\r\nbody\r\n"
+
+// fakeClient records the messages it is asked to send
+type fakeClient struct {
+	mu sync.Mutex
+	// errs are the errors returned by the successive sendings
+	errs []error
+	// blocking makes the sendings wait for their context to be done
+	blocking  bool
+	envelopes []converter.Envelope
+	contents  []string
+	closed    bool
+}
+
+func (c *fakeClient) Send(ctx context.Context, msg *converter.Message) (int, error) {
+	buf := &bytes.Buffer{}
+	if _, err := msg.WriteTo(buf); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.envelopes = append(c.envelopes, msg.Envelope())
+	c.contents = append(c.contents, buf.String())
+
+	if c.blocking {
+		c.mu.Unlock()
+		<-ctx.Done()
+		c.mu.Lock()
+		return 0, ctx.Err()
+	}
+
+	if n := len(c.envelopes); n <= len(c.errs) && c.errs[n-1] != nil {
+		return 0, c.errs[n-1]
+	}
+	return len(msg.To()) + len(msg.Cc()) + len(msg.Bcc()), nil
+}
+
+func (c *fakeClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *fakeClient) calls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.envelopes)
+}
+
+// waitFor waits until the condition is met
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before deadline")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// spooled returns the names of the files of the given directory
+func spooled(t *testing.T, dir string) []string {
+	t.Helper()
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, f := range files {
+		if !f.IsDir() {
+			names = append(names, f.Name())
+		}
+	}
+	return names
+}
+
+func newTestMessage(t *testing.T, envelope converter.Envelope) *converter.Message {
+	t.Helper()
+
+	msg, err := converter.ParseMessage(strings.NewReader(testRaw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.SetEnvelope(envelope)
+	return msg
+}
+
+func TestQueue_Send(t *testing.T) {
+	dir := t.TempDir()
+	client := &fakeClient{}
+
+	q, err := New(dir, client, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	envelope := converter.Envelope{
+		From: "from@example.com",
+		To:   []string{"to@example.com"},
+		Bcc:  []string{"bcc@example.com"},
+	}
+
+	got, err := q.Send(context.Background(), newTestMessage(t, envelope))
+	if err != nil {
+		t.Fatalf("Queue.Send() error = %v", err)
+	}
+	if got != 2 {
+		t.Errorf("Queue.Send() = %v, want %v", got, 2)
+	}
+
+	waitFor(t, func() bool { return client.calls() == 1 && len(spooled(t, dir)) == 0 })
+
+	if !reflect.DeepEqual(client.envelopes[0], envelope) {
+		t.Errorf("delivered envelope = %#v, want %#v", client.envelopes[0], envelope)
+	}
+	if client.contents[0] != testRaw {
+		t.Errorf("delivered content = %q, want %q", client.contents[0], testRaw)
+	}
+
+	t.Run("message without recipient", func(t *testing.T) {
+		if _, err := q.Send(context.Background(), newTestMessage(t, converter.Envelope{})); err == nil {
+			t.Error("Queue.Send() error = nil, want an error")
+		}
+	})
+
+	t.Run("nil message", func(t *testing.T) {
+		if _, err := q.Send(context.Background(), nil); err == nil {
+			t.Error("Queue.Send() error = nil, want an error")
+		}
+	})
+}
+
+func TestQueue_deliver(t *testing.T) {
+	greylisted := &textproto.Error{Code: 451, Msg: "greylisted, try again later"}
+	envelope := converter.Envelope{
+		From: "from@example.com",
+		To:   []string{"to@example.com"},
+		Bcc:  []string{"bcc@example.com"},
+	}
+
+	tests := []struct {
+		name          string
+		errs          []error
+		wantCalls     int
+		wantEnvelopes []converter.Envelope
+		wantDead      bool
+	}{
+		{
+			name:          "transient failure is retried",
+			errs:          []error{greylisted},
+			wantCalls:     2,
+			wantEnvelopes: []converter.Envelope{envelope, envelope},
+		},
+		{
+			name:          "permanent failure is not retried",
+			errs:          []error{&textproto.Error{Code: 550, Msg: "mailbox unavailable"}},
+			wantCalls:     1,
+			wantEnvelopes: []converter.Envelope{envelope},
+			wantDead:      true,
+		},
+		{
+			name:          "attempts are exhausted",
+			errs:          []error{greylisted, greylisted, greylisted, greylisted},
+			wantCalls:     3,
+			wantEnvelopes: []converter.Envelope{envelope, envelope, envelope},
+			wantDead:      true,
+		},
+		{
+			name:      "delivered recipients are not retried",
+			errs:      []error{&smtp.DeliveryError{Delivered: []string{"to@example.com"}, Err: greylisted}},
+			wantCalls: 2,
+			wantEnvelopes: []converter.Envelope{envelope, {
+				From: "from@example.com",
+				Bcc:  []string{"bcc@example.com"},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			client := &fakeClient{errs: tt.errs}
+
+			q, err := New(dir, client, zerolog.Nop(), WithWorkers(2), WithRetry(smtp.RetryPolicy{
+				Attempts: 3,
+				Backoff:  smtp.Backoff{Min: time.Millisecond, Max: time.Millisecond},
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer q.Close()
+
+			if _, err := q.Send(context.Background(), newTestMessage(t, envelope)); err != nil {
+				t.Fatalf("Queue.Send() error = %v", err)
+			}
+
+			// The message is removed once delivered or moved to dead letters
+			waitFor(t, func() bool { return client.calls() >= tt.wantCalls && len(spooled(t, dir)) == 0 })
+
+			letters, err := q.DeadLetters()
+			if err != nil {
+				t.Fatalf("Queue.DeadLetters() error = %v", err)
+			}
+			if got := len(letters) == 1; got != tt.wantDead {
+				t.Errorf("Queue.DeadLetters() = %#v, want a dead letter %v", letters, tt.wantDead)
+			}
+
+			if got := client.calls(); got != tt.wantCalls {
+				t.Errorf("sendings = %v, want %v", got, tt.wantCalls)
+			}
+			if !reflect.DeepEqual(client.envelopes, tt.wantEnvelopes) {
+				t.Errorf("delivered envelopes = %#v, want %#v", client.envelopes, tt.wantEnvelopes)
+			}
+		})
+	}
+}
+
+func TestQueue_recover(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := openSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope := converter.Envelope{From: "from@example.com", To: []string{"to@example.com"}}
+	e := &entry{ID: "queued", Envelope: envelope, Attempts: 1}
+	if err := s.write(e, newTestMessage(t, envelope)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files left behind by an interrupted queuing
+	for _, name := range []string{"orphan.eml", "partial.eml.tmp"} {
+		if err := os.WriteFile(dir+"/"+name, []byte(testRaw), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := &fakeClient{}
+	q, err := New(dir, client, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	waitFor(t, func() bool { return client.calls() == 1 && len(spooled(t, dir)) == 0 })
+
+	if !reflect.DeepEqual(client.envelopes[0], envelope) {
+		t.Errorf("delivered envelope = %#v, want %#v", client.envelopes[0], envelope)
+	}
+}
+
+func TestQueue_Close(t *testing.T) {
+	dir := t.TempDir()
+	client := &fakeClient{errs: []error{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}}
+
+	q, err := New(dir, client, zerolog.Nop(), WithRetry(smtp.RetryPolicy{
+		Attempts: 3,
+		Backoff:  smtp.Backoff{Min: time.Hour, Max: time.Hour},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope := converter.Envelope{From: "from@example.com", To: []string{"to@example.com"}}
+	if _, err := q.Send(context.Background(), newTestMessage(t, envelope)); err != nil {
+		t.Fatalf("Queue.Send() error = %v", err)
+	}
+
+	waitFor(t, func() bool { return client.calls() == 1 })
+
+	if err := q.Close(); err != nil {
+		t.Errorf("Queue.Close() error = %v", err)
+	}
+	if !client.closed {
+		t.Error("client not closed")
+	}
+
+	// The pending message is kept for the next run
+	if got := spooled(t, dir); len(got) != 2 {
+		t.Errorf("spooled files = %v, want the message and its metadata", got)
+	}
+
+	if _, err := q.Send(context.Background(), newTestMessage(t, envelope)); err == nil {
+		t.Error("Queue.Send() error = nil, want a closed queue error")
+	}
+}
+
+func TestQueue_Shutdown(t *testing.T) {
+	dir := t.TempDir()
+	client := &fakeClient{blocking: true}
+
+	q, err := New(dir, client, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope := converter.Envelope{From: "from@example.com", To: []string{"to@example.com"}}
+	if _, err := q.Send(context.Background(), newTestMessage(t, envelope)); err != nil {
+		t.Fatalf("Queue.Send() error = %v", err)
+	}
+
+	waitFor(t, func() bool { return client.calls() == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// The blocked delivery is interrupted once the context is done
+	if err := q.Shutdown(ctx); err != nil {
+		t.Errorf("Queue.Shutdown() error = %v", err)
+	}
+	if !client.closed {
+		t.Error("client not closed")
+	}
+
+	// The interrupted delivery is neither counted nor moved to the dead letters
+	got := spooled(t, dir)
+	if len(got) != 2 {
+		t.Fatalf("spooled files = %v, want the message and its metadata", got)
+	}
+	e, msg, err := q.spool.read(strings.TrimSuffix(got[0], filepath.Ext(got[0])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	(msg.Close())
+	if e.Attempts != 0 {
+		t.Errorf("attempts = %v, want 0", e.Attempts)
+	}
+	if dead := spooled(t, filepath.Join(dir, "dead-letter")); len(dead) != 0 {
+		t.Errorf("dead letters = %v, want none", dead)
+	}
+}
+
+// serveOneTransaction is an SMTP server accepting the first transaction of
+// each connection and never replying to the next ones. blocked receives the
+// unanswered commands.
+func serveOneTransaction(ln net.Listener, blocked chan<- string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+
+			text := textproto.NewConn(conn)
+			(text.PrintfLine("220 localhost ready"))
+			transactions := 0
+			for {
+				line, err := text.ReadLine()
+				if err != nil {
+					return
+				}
+
+				switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); cmd {
+				case "MAIL":
+					if transactions > 0 {
+						blocked <- line
+						(io.Copy(io.Discard, conn))
+						return
+					}
+					(text.PrintfLine("250 ok"))
+				case "DATA":
+					(text.PrintfLine("354 go ahead"))
+					if _, err := text.ReadDotBytes(); err != nil {
+						return
+					}
+					transactions++
+					(text.PrintfLine("250 queued"))
+				case "QUIT":
+					(text.PrintfLine("221 bye"))
+					return
+				default:
+					(text.PrintfLine("250 ok"))
+				}
+			}
+		}()
+	}
+}
+
+func TestQueue_Shutdown_partialDelivery(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	blocked := make(chan string, 1)
+	go serveOneTransaction(ln, blocked)
+
+	dir := t.TempDir()
+	client := smtp.New(ln.Addr().String(), zerolog.Nop(), smtp.WithRetry(smtp.NoRetry))
+	q, err := New(dir, client, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The To recipient is delivered by the first transaction, the Bcc one is not
+	envelope := converter.Envelope{From: "from@example.com", To: []string{"to@example.com"}, Bcc: []string{"bcc@example.com"}}
+	if _, err := q.Send(context.Background(), newTestMessage(t, envelope)); err != nil {
+		t.Fatalf("Queue.Send() error = %v", err)
+	}
+
+	select {
+	case <-blocked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second transaction not started")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := q.Shutdown(ctx); err != nil {
+		t.Errorf("Queue.Shutdown() error = %v", err)
+	}
+
+	got := spooled(t, dir)
+	if len(got) != 2 {
+		t.Fatalf("spooled files = %v, want the message and its metadata", got)
+	}
+	e, err := q.spool.entry(strings.TrimSuffix(got[0], filepath.Ext(got[0])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := converter.Envelope{From: "from@example.com", Bcc: []string{"bcc@example.com"}}
+	if !reflect.DeepEqual(e.Envelope, want) {
+		t.Errorf("spooled envelope = %#v, want %#v", e.Envelope, want)
+	}
+}
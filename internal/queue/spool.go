@@ -0,0 +1,221 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/eexit/http2smtp/internal/converter"
+)
+
+// Spool file extensions: each queued message is stored as its raw content
+// and its metadata. Files are written under a temporary name then renamed
+// so a crash never leaves a partially written file behind.
+const (
+	messageExt  = ".eml"
+	metadataExt = ".json"
+	tmpExt      = ".tmp"
+)
+
+// entry is the metadata of a queued message
+type entry struct {
+	ID        string `json:"id"`
+	MessageID string `json:"message_id"`
+	TraceID   string `json:"trace_id,omitempty"`
+	// Envelope holds the recipients the message is yet to be delivered to
+	Envelope    converter.Envelope `json:"envelope"`
+	Attempts    int                `json:"attempts"`
+	CreatedAt   time.Time          `json:"created_at"`
+	NextAttempt time.Time          `json:"next_attempt"`
+	LastError   string             `json:"last_error,omitempty"`
+	// FailedAt is the time the delivery failed permanently
+	FailedAt time.Time `json:"failed_at,omitempty"`
+}
+
+// spool stores the queued messages in a directory
+type spool struct {
+	dir string
+}
+
+func openSpool(dir string) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	return &spool{dir: dir}, nil
+}
+
+func (s *spool) path(id, ext string) string {
+	return filepath.Join(s.dir, id+ext)
+}
+
+// write stores the message content and its metadata. The metadata is written
+// last: a message without metadata has not been queued.
+func (s *spool) write(e *entry, msg *converter.Message) error {
+	if err := writeFile(s.path(e.ID, messageExt), msg.WriteTo); err != nil {
+		return err
+	}
+
+	if err := s.update(e); err != nil {
+		(os.Remove(s.path(e.ID, messageExt)))
+		return err
+	}
+	return nil
+}
+
+// update replaces the metadata of a queued message
+func (s *spool) update(e *entry) error {
+	return writeFile(s.path(e.ID, metadataExt), func(w io.Writer) (int64, error) {
+		return 0, json.NewEncoder(w).Encode(e)
+	})
+}
+
+// exists returns true if the spool holds the content of the message
+func (s *spool) exists(id string) bool {
+	_, err := os.Stat(s.path(id, messageExt))
+	return err == nil
+}
+
+// entry reads the metadata of a queued message
+func (s *spool) entry(id string) (*entry, error) {
+	data, err := os.ReadFile(s.path(id, metadataExt))
+	if err != nil {
+		return nil, err
+	}
+
+	e := &entry{}
+	if err := json.Unmarshal(data, e); err != nil {
+		return nil, fmt.Errorf("failed to decode queued message %s metadata: %w", id, err)
+	}
+	return e, nil
+}
+
+// read returns the metadata and the message of a queued message. The message
+// must be closed in order to release its file.
+func (s *spool) read(id string) (*entry, *converter.Message, error) {
+	e, err := s.entry(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Open(s.path(id, messageExt))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		(f.Close())
+		return nil, nil, err
+	}
+
+	msg, err := converter.ParseMessage(&file{File: f, size: info.Size()})
+	if err != nil {
+		(f.Close())
+		return nil, nil, fmt.Errorf("failed to parse queued message %s: %w", id, err)
+	}
+
+	// The message is closed by the caller, its file along
+	msg.SetEnvelope(e.Envelope)
+	return e, msg, nil
+}
+
+// remove deletes a queued message
+func (s *spool) remove(id string) error {
+	var errs []error
+	for _, ext := range []string{metadataExt, messageExt} {
+		if err := os.Remove(s.path(id, ext)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// recover returns the queued messages and deletes the files left behind by
+// an interrupted queuing: temporary files and contents without metadata
+func (s *spool) recover() ([]*entry, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		entries []*entry
+		errs    []error
+	)
+
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() {
+			continue
+		}
+
+		switch ext := filepath.Ext(name); ext {
+		case tmpExt:
+			errs = append(errs, os.Remove(filepath.Join(s.dir, name)))
+		case messageExt:
+			if _, err := os.Stat(s.path(strings.TrimSuffix(name, ext), metadataExt)); errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, os.Remove(filepath.Join(s.dir, name)))
+			}
+		case metadataExt:
+			id := strings.TrimSuffix(name, ext)
+			if _, err := os.Stat(s.path(id, messageExt)); errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, os.Remove(filepath.Join(s.dir, name)))
+				continue
+			}
+
+			e, err := s.entry(id)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, errors.Join(errs...)
+}
+
+// writeFile atomically writes a file with the content written by the given func
+func writeFile(name string, write func(io.Writer) (int64, error)) error {
+	f, err := os.OpenFile(name+tmpExt, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := write(f); err != nil {
+		(f.Close())
+		(os.Remove(f.Name()))
+		return err
+	}
+
+	// The content must be on disk before the file gets its final name
+	if err := f.Sync(); err != nil {
+		(f.Close())
+		(os.Remove(f.Name()))
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		(os.Remove(f.Name()))
+		return err
+	}
+
+	return os.Rename(f.Name(), name)
+}
+
+// file is a spooled message content
+type file struct {
+	*os.File
+	size int64
+}
+
+// Size returns the content size in bytes
+func (f *file) Size() int64 {
+	return f.size
+}
@@ -0,0 +1,100 @@
+package queue
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/eexit/http2smtp/internal/converter"
+)
+
+func TestSpool_read(t *testing.T) {
+	s, err := openSpool(filepath.Join(t.TempDir(), "queue"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope := converter.Envelope{
+		From:       "from@example.com",
+		ReturnPath: "bounces@example.com",
+		To:         []string{"to@example.com"},
+	}
+	want := &entry{
+		ID:          "id",
+		MessageID:   "id@example.com",
+		Envelope:    envelope,
+		CreatedAt:   time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NextAttempt: time.Date(2020, 1, 1, 0, 1, 0, 0, time.UTC),
+	}
+
+	if err := s.write(want, newTestMessage(t, envelope)); err != nil {
+		t.Fatalf("spool.write() error = %v", err)
+	}
+
+	got, msg, err := s.read("id")
+	if err != nil {
+		t.Fatalf("spool.read() error = %v", err)
+	}
+	defer msg.Close()
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("spool.read() = %#v, want %#v", got, want)
+	}
+	if !reflect.DeepEqual(msg.Envelope(), envelope) {
+		t.Errorf("spool.read() envelope = %#v, want %#v", msg.Envelope(), envelope)
+	}
+
+	buf := &bytes.Buffer{}
+	if _, err := msg.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != testRaw {
+		t.Errorf("spool.read() content = %q, want %q", buf.String(), testRaw)
+	}
+
+	if err := s.remove("id"); err != nil {
+		t.Errorf("spool.remove() error = %v", err)
+	}
+	if _, _, err := s.read("id"); err == nil {
+		t.Error("spool.read() error = nil, want a removed message error")
+	}
+}
+
+func TestSpool_recover(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope := converter.Envelope{From: "from@example.com", To: []string{"to@example.com"}}
+	if err := s.write(&entry{ID: "queued", Envelope: envelope}, newTestMessage(t, envelope)); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string]string{
+		"orphan.eml":      testRaw,
+		"partial.eml.tmp": testRaw,
+		"lost.json":       `{"id":"lost"}`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := s.recover()
+	if err != nil {
+		t.Fatalf("spool.recover() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].ID != "queued" {
+		t.Errorf("spool.recover() = %#v, want the queued message", entries)
+	}
+
+	want := []string{"queued.eml", "queued.json"}
+	if got := spooled(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("spooled files = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,168 @@
+package smtp
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// Credentials holds the credentials used to authenticate to the SMTP server
+type Credentials struct {
+	Username string
+	Password string
+	// Token is the OAuth 2.0 access token of the XOAUTH2 mechanism
+	Token string
+	// Mechanism forces the authentication mechanism. It is otherwise chosen
+	// amongst the ones advertised by the server.
+	Mechanism string
+}
+
+// WithAuth enables the authentication to the SMTP server with the given
+// credentials. Nothing is done when the credentials are empty.
+func WithAuth(c Credentials) Option {
+	return func(s *smtpClient) {
+		if c.Username != "" {
+			s.credentials = &c
+		}
+	}
+}
+
+// ReadSecret returns the given value, or the content of the given file
+// when the value is empty so secrets can be mounted as files
+func ReadSecret(value, filename string) (string, error) {
+	if value != "" || filename == "" {
+		return value, nil
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// authenticate authenticates to the server with the preferred mechanism it
+// advertises: XOAUTH2 when a token is provided, CRAM-MD5 which does not send
+// the password, then PLAIN and LOGIN. Like net/smtp PlainAuth, mechanisms
+// sending the credentials require a TLS connection unless the server is local.
+func (s *smtpClient) authenticate(c *deadlineConn, host string) error {
+	ok, param := c.Extension("AUTH")
+	if !ok {
+		return errors.New("smtp server does not support AUTH")
+	}
+
+	auth, err := s.credentials.auth(strings.Fields(strings.ToUpper(param)), host)
+	if err != nil {
+		return err
+	}
+
+	if err := c.deadline(); err != nil {
+		return err
+	}
+	if err := c.Client.Auth(auth); err != nil {
+		return fmt.Errorf("failed to authenticate: %w", err)
+	}
+	return nil
+}
+
+// auth returns the authentication of the first supported mechanism
+func (c *Credentials) auth(advertised []string, host string) (smtp.Auth, error) {
+	mechanisms := []string{"CRAM-MD5", "PLAIN", "LOGIN"}
+	if c.Token != "" {
+		mechanisms = []string{"XOAUTH2"}
+	}
+	if c.Mechanism != "" {
+		mechanisms = []string{strings.ToUpper(c.Mechanism)}
+	}
+
+	for _, mechanism := range mechanisms {
+		if !contains(advertised, mechanism) {
+			continue
+		}
+
+		switch mechanism {
+		case "XOAUTH2":
+			return &xoauth2Auth{username: c.Username, token: c.Token, host: host}, nil
+		case "CRAM-MD5":
+			return smtp.CRAMMD5Auth(c.Username, c.Password), nil
+		case "PLAIN":
+			return smtp.PlainAuth("", c.Username, c.Password, host), nil
+		case "LOGIN":
+			return &loginAuth{username: c.Username, password: c.Password, host: host}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no supported AUTH mechanism amongst %s", strings.Join(advertised, " "))
+}
+
+// loginAuth implements the LOGIN mechanism
+// See: https://datatracker.ietf.org/doc/html/draft-murchison-sasl-login-00
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if err := checkEncryption(server, a.host); err != nil {
+		return "", nil, err
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch prompt := strings.ToLower(string(fromServer)); {
+	case strings.HasPrefix(prompt, "user"):
+		return []byte(a.username), nil
+	case strings.HasPrefix(prompt, "pass"):
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected LOGIN challenge %#v", string(fromServer))
+}
+
+// xoauth2Auth implements the XOAUTH2 mechanism
+// See: https://developers.google.com/gmail/imap/xoauth2-protocol
+type xoauth2Auth struct {
+	username, token, host string
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if err := checkEncryption(server, a.host); err != nil {
+		return "", nil, err
+	}
+	return "XOAUTH2", []byte("user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"), nil
+}
+
+func (a *xoauth2Auth) Next(_ []byte, more bool) ([]byte, error) {
+	// The server sends the error details as a challenge: an empty
+	// response is expected to get the final error reply
+	if more {
+		return []byte{}, nil
+	}
+	return nil, nil
+}
+
+// checkEncryption returns an error if the credentials would be sent
+// in clear text to a remote server
+func checkEncryption(server *smtp.ServerInfo, host string) error {
+	if server.Name != host {
+		return errors.New("wrong host name")
+	}
+	if !server.TLS && host != "localhost" && host != "127.0.0.1" && host != "::1" {
+		return errors.New("unencrypted connection")
+	}
+	return nil
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+package smtp
+
+import (
+	"context"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestReadSecret(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(filename, []byte("s3cr3t\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		value    string
+		filename string
+		want     string
+		wantErr  bool
+	}{
+		{name: "no secret"},
+		{name: "value", value: "pass", filename: filename, want: "pass"},
+		{name: "file", filename: filename, want: "s3cr3t"},
+		{name: "missing file", filename: filepath.Join(t.TempDir(), "ghost"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadSecret(tt.value, tt.filename)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ReadSecret() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCredentials_auth(t *testing.T) {
+	tests := []struct {
+		name       string
+		c          Credentials
+		advertised []string
+		want       string
+		wantErr    bool
+	}{
+		{name: "CRAM-MD5 is preferred", c: Credentials{Username: "user", Password: "pass"}, advertised: []string{"LOGIN", "PLAIN", "CRAM-MD5"}, want: "CRAM-MD5"},
+		{name: "PLAIN is preferred to LOGIN", c: Credentials{Username: "user", Password: "pass"}, advertised: []string{"LOGIN", "PLAIN"}, want: "PLAIN"},
+		{name: "LOGIN", c: Credentials{Username: "user", Password: "pass"}, advertised: []string{"LOGIN"}, want: "LOGIN"},
+		{name: "XOAUTH2 with a token", c: Credentials{Username: "user", Token: "t0k3n"}, advertised: []string{"PLAIN", "XOAUTH2"}, want: "XOAUTH2"},
+		{name: "XOAUTH2 not advertised", c: Credentials{Username: "user", Token: "t0k3n"}, advertised: []string{"PLAIN"}, wantErr: true},
+		{name: "forced mechanism", c: Credentials{Username: "user", Password: "pass", Mechanism: "login"}, advertised: []string{"PLAIN", "LOGIN"}, want: "LOGIN"},
+		{name: "no supported mechanism", c: Credentials{Username: "user", Password: "pass"}, advertised: []string{"GSSAPI"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := tt.c.auth(tt.advertised, "smtp.example.com")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Credentials.auth() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			got, _, err := auth.Start(&smtp.ServerInfo{Name: "smtp.example.com", TLS: true, Auth: tt.advertised})
+			if err != nil {
+				t.Fatalf("Auth.Start() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Credentials.auth() mechanism = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_loginAuth(t *testing.T) {
+	a := &loginAuth{username: "user", password: "pass", host: "smtp.example.com"}
+
+	if _, _, err := a.Start(&smtp.ServerInfo{Name: "smtp.example.com"}); err == nil {
+		t.Error("loginAuth.Start() error = nil, want an unencrypted connection error")
+	}
+	if _, _, err := a.Start(&smtp.ServerInfo{Name: "smtp.example.org", TLS: true}); err == nil {
+		t.Error("loginAuth.Start() error = nil, want a wrong host name error")
+	}
+
+	tests := []struct {
+		challenge string
+		more      bool
+		want      string
+		wantErr   bool
+	}{
+		{challenge: "Username:", more: true, want: "user"},
+		{challenge: "Password:", more: true, want: "pass"},
+		{challenge: "Token:", more: true, wantErr: true},
+		{more: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.challenge, func(t *testing.T) {
+			got, err := a.Next([]byte(tt.challenge), tt.more)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loginAuth.Next() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("loginAuth.Next() = %#v, want %#v", string(got), tt.want)
+			}
+		})
+	}
+}
+
+func Test_xoauth2Auth(t *testing.T) {
+	a := &xoauth2Auth{username: "user@example.com", token: "t0k3n", host: "localhost"}
+
+	mechanism, resp, err := a.Start(&smtp.ServerInfo{Name: "localhost"})
+	if err != nil {
+		t.Fatalf("xoauth2Auth.Start() error = %v", err)
+	}
+	if want := "user=user@example.com\x01auth=Bearer t0k3n\x01\x01"; mechanism != "XOAUTH2" || string(resp) != want {
+		t.Errorf("xoauth2Auth.Start() = %#v, %#v, want %#v, %#v", mechanism, string(resp), "XOAUTH2", want)
+	}
+
+	// The error challenge gets an empty response
+	if got, err := a.Next([]byte(`{"status":"401"}`), true); err != nil || got == nil || len(got) != 0 {
+		t.Errorf("xoauth2Auth.Next() = %#v, %v, want an empty response", got, err)
+	}
+}
+
+func Test_smtpClient_dial_auth(t *testing.T) {
+	tests := []struct {
+		name        string
+		credentials *Credentials
+		extensions  []string
+		wantErr     bool
+	}{
+		{name: "no authentication", extensions: []string{"AUTH PLAIN"}},
+		{name: "authenticated", credentials: &Credentials{Username: "user", Password: "pass"}, extensions: []string{"AUTH LOGIN PLAIN"}},
+		{name: "invalid credentials", credentials: &Credentials{Username: "user", Password: "wrong"}, extensions: []string{"AUTH PLAIN"}, wantErr: true},
+		{name: "AUTH not supported", credentials: &Credentials{Username: "user", Password: "pass"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln := newLocalListener(t)
+			defer ln.Close()
+
+			go serveSMTP(ln, nil, false, false, tt.extensions...)
+
+			s := &smtpClient{addr: ln.Addr().String(), logger: zerolog.Nop(), tlsMode: TLSNone, credentials: tt.credentials}
+
+			client, err := s.dial(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("smtpClient.dial() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				(client.Close())
+			}
+		})
+	}
+}
+
+func TestWithAuth(t *testing.T) {
+	s := &smtpClient{}
+	WithAuth(Credentials{})(s)
+	if s.credentials != nil {
+		t.Errorf("WithAuth() credentials = %#v, want nil", s.credentials)
+	}
+
+	WithAuth(Credentials{Username: "user", Password: "pass"})(s)
+	if s.credentials == nil || s.credentials.Username != "user" {
+		t.Errorf("WithAuth() credentials = %#v, want user credentials", s.credentials)
+	}
+}
@@ -0,0 +1,57 @@
+package smtp
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoffFactor is the growth of the delay between two attempts
+const backoffFactor = 2
+
+// Backoff computes exponentially growing delays between attempts
+type Backoff struct {
+	// Min is the delay after the first failed attempt
+	Min time.Duration
+	// Max caps the delays. The delay is constant when lower than Min.
+	Max time.Duration
+}
+
+// DefaultReconnectBackoff is the delay between two failed dials to the
+// SMTP server unless WithReconnectBackoff is given
+var DefaultReconnectBackoff = Backoff{
+	Min: time.Second,
+	Max: 30 * time.Second,
+}
+
+// WithReconnectBackoff sets the delays between two failed dials to the
+// SMTP server. Sendings fail without dialing in the meantime.
+func WithReconnectBackoff(b Backoff) Option {
+	return func(s *smtpClient) {
+		s.reconnectBackoff = b
+	}
+}
+
+// Delay returns the delay after the given number of failed attempts. The delay
+// is randomized between the half and the whole of the exponential delay (jitter)
+// so the clients don't retry all at once.
+func (b Backoff) Delay(failures int) time.Duration {
+	if failures < 1 || b.Min <= 0 {
+		return 0
+	}
+
+	max := b.Max
+	if max < b.Min {
+		max = b.Min
+	}
+
+	d := b.Min
+	for i := 1; i < failures && d < max; i++ {
+		d *= backoffFactor
+	}
+	if d > max {
+		d = max
+	}
+
+	half := d / backoffFactor
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
@@ -0,0 +1,62 @@
+package smtp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff_Delay(t *testing.T) {
+	tests := []struct {
+		name     string
+		backoff  Backoff
+		failures int
+		want     time.Duration
+	}{
+		{
+			name:     "no failure",
+			backoff:  Backoff{Min: time.Second, Max: time.Minute},
+			failures: 0,
+			want:     0,
+		},
+		{
+			name:     "no backoff",
+			backoff:  Backoff{},
+			failures: 3,
+			want:     0,
+		},
+		{
+			name:     "first failure",
+			backoff:  Backoff{Min: time.Second, Max: time.Minute},
+			failures: 1,
+			want:     time.Second,
+		},
+		{
+			name:     "delay grows exponentially",
+			backoff:  Backoff{Min: time.Second, Max: time.Minute},
+			failures: 4,
+			want:     8 * time.Second,
+		},
+		{
+			name:     "delay is capped",
+			backoff:  Backoff{Min: time.Second, Max: time.Minute},
+			failures: 100,
+			want:     time.Minute,
+		},
+		{
+			name:     "delay is constant without max",
+			backoff:  Backoff{Min: time.Second},
+			failures: 5,
+			want:     time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The delay is randomized between the half and the whole of the expected delay
+			for i := 0; i < 10; i++ {
+				if got := tt.backoff.Delay(tt.failures); got < tt.want/2 || got > tt.want {
+					t.Errorf("Backoff.Delay() = %v, want between %v and %v", got, tt.want/2, tt.want)
+				}
+			}
+		})
+	}
+}
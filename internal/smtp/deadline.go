@@ -0,0 +1,183 @@
+package smtp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/smtp"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout is the I/O timeout used unless WithTimeout is given
+const DefaultTimeout = 30 * time.Second
+
+// WithTimeout sets the timeout of the dials and of each command sent to the
+// SMTP server, so a server that stops responding does not block the sendings.
+// No timeout is applied when 0, only the deadline of the sending context.
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *smtpClient) {
+		s.timeout = timeout
+	}
+}
+
+// deadlineConn is an SMTP client whose connection gets a deadline before
+// each command: the I/O timeout, or the deadline of the context it is bound
+// to when earlier. The blocked commands are interrupted when the bound
+// context is done.
+type deadlineConn struct {
+	*smtp.Client
+	conn    net.Conn
+	timeout time.Duration
+
+	mu  sync.Mutex
+	ctx context.Context
+	// interrupted is true once the bound context is done
+	interrupted bool
+}
+
+func newDeadlineConn(conn net.Conn, timeout time.Duration) *deadlineConn {
+	return &deadlineConn{conn: conn, timeout: timeout, ctx: context.Background()}
+}
+
+// bind makes the commands follow the given context until the returned
+// func is called
+func (c *deadlineConn) bind(ctx context.Context) (release func()) {
+	c.mu.Lock()
+	c.ctx, c.interrupted = ctx, false
+	c.mu.Unlock()
+
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			c.mu.Lock()
+			c.interrupted = true
+			// A deadline in the past unblocks the pending I/O
+			(c.conn.SetDeadline(time.Unix(1, 0)))
+			c.mu.Unlock()
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+
+		c.mu.Lock()
+		c.ctx, c.interrupted = context.Background(), false
+		c.mu.Unlock()
+	}
+}
+
+// deadline sets the deadline of the next command
+func (c *deadlineConn) deadline() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.interrupted {
+		return c.ctx.Err()
+	}
+
+	var deadline time.Time
+	if c.timeout > 0 {
+		deadline = time.Now().Add(c.timeout)
+	}
+	if d, ok := c.ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+	return c.conn.SetDeadline(deadline)
+}
+
+// cause returns the error of the bound context instead of the I/O error of
+// an interrupted command, so the interruption is not taken for a network
+// failure
+func (c *deadlineConn) cause(err error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err == nil {
+		return nil
+	}
+	if ctxErr := c.ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	// The I/O deadline may be reached before the context timer fires
+	if d, ok := c.ctx.Deadline(); ok && !time.Now().Before(d) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// Extension may issue the EHLO command the first time it is called
+func (c *deadlineConn) Extension(ext string) (bool, string) {
+	if err := c.deadline(); err != nil {
+		return false, ""
+	}
+	return c.Client.Extension(ext)
+}
+
+func (c *deadlineConn) Mail(from string) error {
+	if err := c.deadline(); err != nil {
+		return err
+	}
+	return c.cause(c.Client.Mail(from))
+}
+
+func (c *deadlineConn) Rcpt(to string) error {
+	if err := c.deadline(); err != nil {
+		return err
+	}
+	return c.cause(c.Client.Rcpt(to))
+}
+
+// Data refreshes the deadline before each write of the message content
+func (c *deadlineConn) Data() (io.WriteCloser, error) {
+	if err := c.deadline(); err != nil {
+		return nil, err
+	}
+
+	w, err := c.Client.Data()
+	if err != nil {
+		return nil, c.cause(err)
+	}
+	return &deadlineWriter{WriteCloser: w, conn: c}, nil
+}
+
+func (c *deadlineConn) Reset() error {
+	if err := c.deadline(); err != nil {
+		return err
+	}
+	return c.cause(c.Client.Reset())
+}
+
+func (c *deadlineConn) Quit() error {
+	if err := c.deadline(); err != nil {
+		return err
+	}
+	return c.cause(c.Client.Quit())
+}
+
+// deadlineWriter is the DATA writer of a deadlineConn
+type deadlineWriter struct {
+	io.WriteCloser
+	conn *deadlineConn
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	if err := w.conn.deadline(); err != nil {
+		return 0, err
+	}
+	n, err := w.WriteCloser.Write(p)
+	return n, w.conn.cause(err)
+}
+
+// Close ends the data and reads the server final reply
+func (w *deadlineWriter) Close() error {
+	if err := w.conn.deadline(); err != nil {
+		(w.WriteCloser.Close())
+		return err
+	}
+	return w.conn.cause(w.WriteCloser.Close())
+}
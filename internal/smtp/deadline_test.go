@@ -0,0 +1,114 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// serveSilently accepts the connections and never replies, except the
+// greeting when asked
+func serveSilently(ln net.Listener, greet bool) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+			if greet {
+				(io.WriteString(conn, "220 hello\r\n"))
+			}
+			(io.Copy(io.Discard, conn))
+		}()
+	}
+}
+
+func Test_smtpClient_dial_timeout(t *testing.T) {
+	ln := newLocalListener(t)
+	defer ln.Close()
+
+	go serveSilently(ln, false)
+
+	s := &smtpClient{addr: ln.Addr().String(), logger: zerolog.Nop(), tlsMode: TLSNone, timeout: 50 * time.Millisecond}
+
+	start := time.Now()
+	if _, err := s.dial(context.Background()); err == nil {
+		t.Fatal("smtpClient.dial() error = nil, want a timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("smtpClient.dial() returned after %s, want the timeout", elapsed)
+	}
+}
+
+func Test_deadlineConn(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		ctx     func() (context.Context, context.CancelFunc)
+		wantErr error
+	}{
+		{
+			name:    "command times out",
+			timeout: 50 * time.Millisecond,
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithCancel(context.Background())
+			},
+		},
+		{
+			name: "command follows the context deadline",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name: "command is interrupted when the context is canceled",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			wantErr: context.Canceled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln := newLocalListener(t)
+			defer ln.Close()
+
+			go serveSilently(ln, true)
+
+			s := &smtpClient{addr: ln.Addr().String(), logger: zerolog.Nop(), tlsMode: TLSNone, timeout: tt.timeout}
+			c, err := s.dial(context.Background())
+			if err != nil {
+				t.Fatalf("smtpClient.dial() error = %v", err)
+			}
+			defer c.Close()
+
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			release := c.bind(ctx)
+			defer release()
+
+			start := time.Now()
+			err = c.Mail("from@example.com")
+			if err == nil {
+				t.Fatal("deadlineConn.Mail() error = nil, want an error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("deadlineConn.Mail() error = %v, want %v", err, tt.wantErr)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("deadlineConn.Mail() returned after %s, want it interrupted", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package smtp
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/eexit/http2smtp/internal/converter"
+	"github.com/eexit/http2smtp/internal/dkim"
+	"github.com/rs/zerolog"
+)
+
+// WithDKIM enables the DKIM signing of the messages whose From: domain
+// has a key in the given keyring
+func WithDKIM(keyring *dkim.Keyring) Option {
+	return func(s *smtpClient) {
+		s.keyring = keyring
+	}
+}
+
+// sign returns the DKIM-Signature header field of the message as it is
+// transferred, empty when its From: domain has no key. The message is
+// signed once negotiated so the signature covers the exact bytes sent.
+func (s *smtpClient) sign(logger zerolog.Logger, msg *converter.Message, t transfer) (string, error) {
+	from := msg.From()
+	signer := s.keyring.Signer(from[strings.LastIndex(from, "@")+1:])
+	if signer == nil {
+		return "", nil
+	}
+
+	write := msg.WriteTo
+	if t.sevenBit {
+		write = msg.WriteTo7Bit
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := write(pw)
+		(pw.CloseWithError(err))
+	}()
+
+	signature, err := signer.Sign(pr)
+	(pr.CloseWithError(io.ErrClosedPipe))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign message: %w", err)
+	}
+
+	logger.Debug().Str("domain", signer.Domain()).Msg("message signed")
+	return signature, nil
+}
@@ -0,0 +1,124 @@
+package smtp
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/eexit/http2smtp/internal/converter"
+	"github.com/eexit/http2smtp/internal/dkim"
+	"github.com/rs/zerolog"
+)
+
+func TestSMTP_Send_dkim(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := dkim.NewSigner("example.com", "s1", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const eightBitBody = "From: test@example.com\r\nSubject: Cafe\r\n\r\nCafé!\r\n"
+
+	tests := []struct {
+		name       string
+		keyring    *dkim.Keyring
+		extensions []string
+		from       string
+		raw        string
+		wantSigned bool
+		wantData   string
+		wantBody   string
+		wantErr    bool
+	}{
+		{
+			name:       "no keyring",
+			extensions: []string{"8BITMIME"},
+			from:       "test@example.com",
+			raw:        eightBitBody,
+			wantData:   eightBitBody,
+		},
+		{
+			name:       "domain without key",
+			extensions: []string{"8BITMIME"},
+			keyring:    dkim.NewKeyring(signer),
+			from:       "test@example.org",
+			raw:        eightBitBody,
+			wantData:   eightBitBody,
+		},
+		{
+			name:       "signed message",
+			keyring:    dkim.NewKeyring(signer),
+			extensions: []string{"8BITMIME"},
+			from:       "test@EXAMPLE.com",
+			raw:        eightBitBody,
+			wantSigned: true,
+			wantData:   eightBitBody,
+			wantBody:   "Café!\r\n",
+		},
+		{
+			name:       "7-bit message is signed once encoded",
+			keyring:    dkim.NewKeyring(signer),
+			from:       "test@example.com",
+			raw:        eightBitBody,
+			wantSigned: true,
+			wantData:   "From: test@example.com\r\nSubject: Cafe\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nCaf=C3=A9!\r\n",
+			wantBody:   "Caf=C3=A9!\r\n",
+		},
+		{
+			name:    "message without From header field",
+			keyring: dkim.NewKeyring(signer),
+			from:    "test@example.com",
+			raw:     "Subject: Hello\r\n\r\nHello world!\r\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := &fakeWriteCloser{}
+
+			s := &smtpClient{
+				pool: newFakePool(&fakeSMTP{
+					extensions: tt.extensions,
+					mail:       strCmdOK,
+					rcpt:       strCmdOK,
+					data:       func() (io.WriteCloser, error) { return data, nil },
+				}),
+				logger:  zerolog.Nop(),
+				keyring: tt.keyring,
+			}
+
+			msg := converter.NewMessage(tt.from, []string{"bob@example.com"}, nil, nil, strings.NewReader(tt.raw))
+
+			_, err := s.Send(context.Background(), msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SMTP.Send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			got := data.buf.String()
+			if !tt.wantSigned {
+				if got != tt.wantData {
+					t.Errorf("SMTP.Send() data = %#v, want %#v", got, tt.wantData)
+				}
+				return
+			}
+
+			signature, rest, _ := strings.Cut(got, "\r\nFrom:")
+			if !strings.HasPrefix(signature, "DKIM-Signature: ") || "From:"+rest != tt.wantData {
+				t.Fatalf("SMTP.Send() data = %#v, want a signed %#v", got, tt.wantData)
+			}
+
+			hash := sha256.Sum256([]byte(tt.wantBody))
+			if bh := "bh=" + base64.StdEncoding.EncodeToString(hash[:]); !strings.Contains(signature, bh) {
+				t.Errorf("SMTP.Send() signature = %#v, want %#v", signature, bh)
+			}
+		})
+	}
+}
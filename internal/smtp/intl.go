@@ -0,0 +1,196 @@
+package smtp
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/eexit/http2smtp/internal/converter"
+	"github.com/rs/zerolog"
+	"golang.org/x/net/idna"
+)
+
+// transfer holds how a message is transferred to the server
+type transfer struct {
+	// smtputf8 is true when the server supports SMTPUTF8 (RFC 6531)
+	smtputf8 bool
+	// sevenBit is true when the 8-bit message body must be encoded
+	// because the server does not support 8BITMIME (RFC 6152)
+	sevenBit bool
+	// signature is the DKIM-Signature header field prepended to the message
+	signature string
+}
+
+// addressFields are the header fields holding address lists (RFC 5322
+// section 3.6)
+var addressFields = map[string]bool{
+	"From":          true,
+	"Sender":        true,
+	"Reply-To":      true,
+	"To":            true,
+	"Cc":            true,
+	"Bcc":           true,
+	"Resent-From":   true,
+	"Resent-Sender": true,
+	"Resent-To":     true,
+	"Resent-Cc":     true,
+	"Resent-Bcc":    true,
+}
+
+// structuredFields are the header fields whose value can't be RFC 2047 encoded
+var structuredFields = map[string]bool{
+	"Message-Id":                true,
+	"In-Reply-To":               true,
+	"References":                true,
+	"Resent-Message-Id":         true,
+	"Return-Path":               true,
+	"Received":                  true,
+	"Date":                      true,
+	"Resent-Date":               true,
+	"Mime-Version":              true,
+	"Content-Type":              true,
+	"Content-Transfer-Encoding": true,
+	"Content-Id":                true,
+	"Content-Disposition":       true,
+	"Dkim-Signature":            true,
+}
+
+// negotiateTransfer checks the extensions the message needs against the ones
+// the server supports. The message is only scanned when the server misses one.
+// Without SMTPUTF8, the message is returned with its header downgraded to ASCII.
+func (s *smtpClient) negotiateTransfer(logger zerolog.Logger, c goSMTP, msg *converter.Message) (transfer, *converter.Message, error) {
+	smtputf8, _ := c.Extension("SMTPUTF8")
+	eightBitMIME, _ := c.Extension("8BITMIME")
+
+	t := transfer{smtputf8: smtputf8}
+	if smtputf8 && eightBitMIME {
+		return t, msg, nil
+	}
+
+	needs, err := msg.Transport()
+	if err != nil {
+		return t, nil, &converter.Error{
+			Kind: converter.KindInternal,
+			Err:  fmt.Errorf("failed to scan message: %w", err),
+		}
+	}
+
+	if needs.UTF8 && !smtputf8 {
+		if msg, err = downgradeHeader(msg); err != nil {
+			return t, nil, &converter.Error{Kind: converter.KindUnprocessable, Err: err}
+		}
+		logger.Debug().Msg("smtp server does not support SMTPUTF8, message header is downgraded to ASCII")
+	}
+
+	if t.sevenBit = needs.EightBit && !eightBitMIME; t.sevenBit {
+		logger.Debug().Msg("smtp server does not support 8BITMIME, message body is encoded in 7-bit")
+	}
+
+	return t, msg, nil
+}
+
+// downgradeHeader returns the message with an ASCII header: the domains of the
+// address fields are converted to punycode and the unstructured fields are
+// RFC 2047 encoded. Non-ASCII local parts and structured fields can't be
+// downgraded, nor the header of the MIME parts.
+func downgradeHeader(msg *converter.Message) (*converter.Message, error) {
+	header, err := msg.Header().Map(func(key, value string) (string, error) {
+		switch {
+		case isASCII(value):
+			return value, nil
+		case addressFields[key]:
+			return asciiAddressList(key, value)
+		case structuredFields[key]:
+			return "", fmt.Errorf("%s header field has non-ASCII characters but the smtp server does not support SMTPUTF8", key)
+		}
+		return mime.QEncoding.Encode("utf-8", value), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	downgraded := msg.WithHeader(header)
+	needs, err := downgraded.Transport()
+	if err != nil {
+		return nil, err
+	}
+	if needs.UTF8 {
+		return nil, errors.New("message part header has non-ASCII characters but the smtp server does not support SMTPUTF8")
+	}
+	return downgraded, nil
+}
+
+// asciiAddressList returns the address list with its domains converted to
+// punycode and its display names RFC 2047 encoded
+func asciiAddressList(key, value string) (string, error) {
+	addrs, err := mail.ParseAddressList(value)
+	if err != nil || len(addrs) == 0 {
+		return "", fmt.Errorf("%s header field has non-ASCII characters and can't be parsed", key)
+	}
+
+	list := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		at := strings.LastIndex(addr.Address, "@")
+		if at < 0 || !isASCII(addr.Address[:at]) {
+			return "", fmt.Errorf("address %s has a non-ASCII local part but the smtp server does not support SMTPUTF8", addr.Address)
+		}
+
+		domain, err := domainToASCII(addr.Address[at+1:])
+		if err != nil {
+			return "", err
+		}
+		addr.Address = addr.Address[:at+1] + domain
+		list = append(list, addr.String())
+	}
+	return strings.Join(list, ", "), nil
+}
+
+// envelopeAddress returns the given address as it can be sent in the envelope.
+// Without SMTPUTF8, internationalized domains are converted to punycode and
+// non-ASCII local parts can't be sent.
+func (t transfer) envelopeAddress(addr string) (string, error) {
+	if t.smtputf8 || isASCII(addr) {
+		return addr, nil
+	}
+
+	at := strings.LastIndex(addr, "@")
+	if at < 0 || !isASCII(addr[:at]) {
+		return "", &converter.Error{
+			Kind: converter.KindUnprocessable,
+			Err:  fmt.Errorf("address %s has a non-ASCII local part but the smtp server does not support SMTPUTF8", addr),
+		}
+	}
+
+	domain, err := domainToASCII(addr[at+1:])
+	if err != nil {
+		return "", &converter.Error{Kind: converter.KindUnprocessable, Err: err}
+	}
+	return addr[:at+1] + domain, nil
+}
+
+// domainToASCII converts an internationalized domain name into its ASCII
+// compatible form, following the IDNA2008 lookup profile
+func domainToASCII(domain string) (string, error) {
+	if !utf8.ValidString(domain) {
+		return "", fmt.Errorf("invalid domain %#v: invalid UTF-8", domain)
+	}
+
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", fmt.Errorf("invalid domain %#v: %w", domain, err)
+	}
+	return ascii, nil
+}
+
+// isASCII returns true if the string only has ASCII characters
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,217 @@
+package smtp
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/eexit/http2smtp/internal/converter"
+	"github.com/rs/zerolog"
+)
+
+func TestSMTP_Send_intl(t *testing.T) {
+	const (
+		asciiBody    = "From: test@example.com\r\n\r\nHello world!\r\n"
+		eightBitBody = "From: test@example.com\r\nSubject: Cafe\r\n\r\nCafé!\r\n"
+		utf8Body     = "From: test@example.com\r\nSubject: Café\r\n\r\nCafé!\r\n"
+	)
+
+	tests := []struct {
+		name       string
+		extensions []string
+		from       string
+		to         string
+		raw        string
+		wantFrom   string
+		wantTo     string
+		wantData   string
+		wantKind   converter.ErrorKind
+		wantErr    bool
+	}{
+		{
+			name:     "ASCII message without extensions",
+			from:     "test@example.com",
+			to:       "bob@example.com",
+			raw:      asciiBody,
+			wantFrom: "test@example.com",
+			wantTo:   "bob@example.com",
+			wantData: asciiBody,
+		},
+		{
+			name:       "internationalized message with extensions",
+			extensions: []string{"SMTPUTF8", "8BITMIME"},
+			from:       "test@bücher.example",
+			to:         "josé@münchen.example",
+			raw:        utf8Body,
+			wantFrom:   "test@bücher.example",
+			wantTo:     "josé@münchen.example",
+			wantData:   utf8Body,
+		},
+		{
+			name:       "domains are converted to punycode without SMTPUTF8",
+			extensions: []string{"8BITMIME"},
+			from:       "test@bücher.example",
+			to:         "bob@münchen.example",
+			raw:        eightBitBody,
+			wantFrom:   "test@xn--bcher-kva.example",
+			wantTo:     "bob@xn--mnchen-3ya.example",
+			wantData:   eightBitBody,
+		},
+		{
+			name:     "body is encoded in 7-bit without 8BITMIME",
+			from:     "test@example.com",
+			to:       "bob@example.com",
+			raw:      eightBitBody,
+			wantFrom: "test@example.com",
+			wantTo:   "bob@example.com",
+			wantData: "From: test@example.com\r\nSubject: Cafe\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nCaf=C3=A9!\r\n",
+		},
+		{
+			name:       "unstructured header fields are encoded without SMTPUTF8",
+			extensions: []string{"8BITMIME"},
+			from:       "test@example.com",
+			to:         "bob@example.com",
+			raw:        utf8Body,
+			wantFrom:   "test@example.com",
+			wantTo:     "bob@example.com",
+			wantData:   "From: test@example.com\r\nSubject: =?utf-8?q?Caf=C3=A9?=\r\n\r\nCafé!\r\n",
+		},
+		{
+			name:     "address header fields domains are converted to punycode without SMTPUTF8",
+			from:     "zoe@bücher.example",
+			to:       "bob@münchen.example",
+			raw:      "From: Zoë <zoe@bücher.example>\r\nTo: bob@münchen.example, alice@example.com\r\nSubject: Hello\r\n\r\nHello\r\n",
+			wantFrom: "zoe@xn--bcher-kva.example",
+			wantTo:   "bob@xn--mnchen-3ya.example",
+			wantData: "From: =?utf-8?q?Zo=C3=AB?= <zoe@xn--bcher-kva.example>\r\nTo: <bob@xn--mnchen-3ya.example>, <alice@example.com>\r\nSubject: Hello\r\n\r\nHello\r\n",
+		},
+		{
+			name:     "non-ASCII local part in header without SMTPUTF8",
+			from:     "test@example.com",
+			to:       "bob@example.com",
+			raw:      "From: test@example.com\r\nTo: josé@example.com\r\n\r\nHello\r\n",
+			wantKind: converter.KindUnprocessable,
+			wantErr:  true,
+		},
+		{
+			name:     "non-ASCII structured header field without SMTPUTF8",
+			from:     "test@example.com",
+			to:       "bob@example.com",
+			raw:      "From: test@example.com\r\nMessage-ID: <café@example.com>\r\n\r\nHello\r\n",
+			wantKind: converter.KindUnprocessable,
+			wantErr:  true,
+		},
+		{
+			name:     "non-ASCII part header without SMTPUTF8",
+			from:     "test@example.com",
+			to:       "bob@example.com",
+			raw:      "From: test@example.com\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nContent-Disposition: attachment; filename=\"café.txt\"\r\n\r\nHello\r\n--b--\r\n",
+			wantKind: converter.KindUnprocessable,
+			wantErr:  true,
+		},
+		{
+			name:       "non-ASCII local part without SMTPUTF8",
+			extensions: []string{"8BITMIME"},
+			from:       "test@example.com",
+			to:         "josé@example.com",
+			raw:        asciiBody,
+			wantKind:   converter.KindUnprocessable,
+			wantErr:    true,
+		},
+		{
+			name:     "non-ASCII sender local part without SMTPUTF8",
+			from:     "josé@example.com",
+			to:       "bob@example.com",
+			raw:      asciiBody,
+			wantKind: converter.KindUnprocessable,
+			wantErr:  true,
+		},
+		{
+			name:     "invalid domain without SMTPUTF8",
+			from:     "test@example.com",
+			to:       "bob@b\xfccher.example",
+			raw:      asciiBody,
+			wantKind: converter.KindUnprocessable,
+			wantErr:  true,
+		},
+		{
+			name:     "message scan failure",
+			from:     "test@example.com",
+			to:       "bob@example.com",
+			wantKind: converter.KindInternal,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotFrom, gotTo string
+			data := &fakeWriteCloser{}
+
+			s := &smtpClient{
+				pool: newFakePool(&fakeSMTP{
+					extensions: tt.extensions,
+					mail:       func(from string) error { gotFrom = from; return nil },
+					rcpt:       func(to string) error { gotTo = to; return nil },
+					data:       func() (io.WriteCloser, error) { return data, nil },
+				}),
+				logger: zerolog.Nop(),
+			}
+
+			msg := converter.NewMessage(tt.from, []string{tt.to}, nil, nil, &failingReader{})
+			if tt.raw != "" {
+				var err error
+				if msg, err = converter.ParseMessage(strings.NewReader(tt.raw)); err != nil {
+					t.Fatal(err)
+				}
+				msg.SetEnvelope(converter.Envelope{From: tt.from, To: []string{tt.to}})
+			}
+
+			_, err := s.Send(context.Background(), msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SMTP.Send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if kind := converter.KindOf(err); kind != tt.wantKind {
+					t.Errorf("SMTP.Send() error kind = %v, want %v", kind, tt.wantKind)
+				}
+				return
+			}
+
+			if gotFrom != tt.wantFrom || gotTo != tt.wantTo {
+				t.Errorf("SMTP.Send() envelope = %#v -> %#v, want %#v -> %#v", gotFrom, gotTo, tt.wantFrom, tt.wantTo)
+			}
+			if got := data.buf.String(); got != tt.wantData {
+				t.Errorf("SMTP.Send() data = %#v, want %#v", got, tt.wantData)
+			}
+		})
+	}
+}
+
+func Test_transfer_envelopeAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		t       transfer
+		addr    string
+		want    string
+		wantErr string
+	}{
+		{name: "ASCII address", addr: "bob@example.com", want: "bob@example.com"},
+		{name: "SMTPUTF8 address", t: transfer{smtputf8: true}, addr: "josé@bücher.example", want: "josé@bücher.example"},
+		{name: "IDN domain", addr: "bob@bücher.example", want: "bob@xn--bcher-kva.example"},
+		{name: "IDN domain is mapped", addr: "bob@BÜCHER。example", want: "bob@xn--bcher-kva.example"},
+		{name: "non-ASCII local part", addr: "josé@example.com", wantErr: "address josé@example.com has a non-ASCII local part but the smtp server does not support SMTPUTF8"},
+		{name: "non-ASCII address without domain", addr: "josé", wantErr: "address josé has a non-ASCII local part but the smtp server does not support SMTPUTF8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.t.envelopeAddress(tt.addr)
+			if (err != nil || tt.wantErr != "") && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("envelopeAddress() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("envelopeAddress() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"sync"
+	"time"
+
+	"github.com/eexit/http2smtp/internal/converter"
+)
+
+// PoolConfig holds the limits of the SMTP connection pool
+type PoolConfig struct {
+	// MaxActive is the max number of connections in use at once
+	MaxActive int
+	// MaxIdle is the max number of idle connections kept open
+	MaxIdle int
+	// IdleTimeout is the duration after which an idle connection is closed.
+	// Idle connections are kept open when 0.
+	IdleTimeout time.Duration
+	// MaxMessages is the max number of messages sent over a connection
+	// before it is closed. No limit when 0.
+	MaxMessages int
+}
+
+// DefaultPoolConfig is the pool config used unless WithPool is given
+var DefaultPoolConfig = PoolConfig{
+	MaxActive:   4,
+	MaxIdle:     2,
+	IdleTimeout: time.Minute,
+	MaxMessages: 100,
+}
+
+// WithPool sets the limits of the SMTP connection pool
+func WithPool(config PoolConfig) Option {
+	return func(s *smtpClient) {
+		s.poolConfig = config
+	}
+}
+
+// poolConn is a pooled connection
+type poolConn struct {
+	goSMTP
+	// messages is the number of messages sent over the connection
+	messages int
+	lastUsed time.Time
+	// release unbinds the connection from the context of its sending
+	release func()
+}
+
+// binder is implemented by the connections whose commands can follow a context
+type binder interface {
+	bind(ctx context.Context) (release func())
+}
+
+// bind makes the connection commands follow the given context while in use
+func (c *poolConn) bind(ctx context.Context) {
+	if b, ok := c.goSMTP.(binder); ok {
+		c.release = b.bind(ctx)
+	}
+}
+
+func (c *poolConn) unbind() {
+	if c.release != nil {
+		c.release()
+		c.release = nil
+	}
+}
+
+// pool is a bounded pool of SMTP connections. Each connection is used by a
+// single sending at a time and is reset (RSET) before being reused.
+type pool struct {
+	config PoolConfig
+	dial   func(ctx context.Context) (goSMTP, error)
+	// active holds a token per connection in use
+	active chan struct{}
+	mu     sync.Mutex
+	idle   []*poolConn
+	closed bool
+	now    func() time.Time
+	// backoff delays the dials following a failed one
+	backoff Backoff
+	// failures is the number of consecutive failed dials
+	failures int
+	// retryAt is the time before which no dial is attempted
+	retryAt time.Time
+	dialErr error
+}
+
+func newPool(config PoolConfig, backoff Backoff, dial func(ctx context.Context) (goSMTP, error)) *pool {
+	if config.MaxActive < 1 {
+		config.MaxActive = 1
+	}
+	if config.MaxIdle > config.MaxActive {
+		config.MaxIdle = config.MaxActive
+	}
+
+	return &pool{
+		config:  config,
+		dial:    dial,
+		active:  make(chan struct{}, config.MaxActive),
+		now:     time.Now,
+		backoff: backoff,
+	}
+}
+
+// get returns an idle connection or dials a new one. It waits for a
+// connection to be released when the pool is exhausted.
+func (p *pool) get(ctx context.Context) (*poolConn, error) {
+	select {
+	case p.active <- struct{}{}:
+	default:
+		// Waits for a connection to be released
+		select {
+		case p.active <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			<-p.active
+			return nil, errors.New("smtp connection pool is closed")
+		}
+
+		n := len(p.idle)
+		if n == 0 {
+			p.mu.Unlock()
+			break
+		}
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+
+		// Stale and dead connections are discarded
+		if p.config.IdleTimeout > 0 && p.now().Sub(c.lastUsed) > p.config.IdleTimeout {
+			discard(c)
+			continue
+		}
+		c.bind(ctx)
+		if err := c.Reset(); err != nil {
+			c.unbind()
+			(c.Close())
+			continue
+		}
+		return c, nil
+	}
+
+	client, err := p.connect(ctx)
+	if err != nil {
+		<-p.active
+		return nil, err
+	}
+
+	c := &poolConn{goSMTP: client}
+	c.bind(ctx)
+	return c, nil
+}
+
+// connect dials a new connection. The dials following a failed one are
+// delayed with backoff: it fails without dialing until the delay is over.
+// A dial aborted because its context is done is not a server failure.
+func (p *pool) connect(ctx context.Context) (goSMTP, error) {
+	p.mu.Lock()
+	if wait := p.retryAt.Sub(p.now()); wait > 0 {
+		err := p.dialErr
+		p.mu.Unlock()
+		return nil, &UnavailableError{RetryIn: wait, Err: err}
+	}
+	p.mu.Unlock()
+
+	client, err := p.dial(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err != nil && ctx.Err() != nil {
+		return nil, err
+	}
+
+	if err != nil {
+		p.failures++
+		p.retryAt = p.now().Add(p.backoff.Delay(p.failures))
+		p.dialErr = err
+		return nil, err
+	}
+
+	p.failures, p.retryAt, p.dialErr = 0, time.Time{}, nil
+	return client, nil
+}
+
+// put releases the connection: it is kept idle unless it is broken or
+// has reached its limits
+func (p *pool) put(c *poolConn, broken bool) {
+	defer func() { <-p.active }()
+	c.unbind()
+	c.lastUsed = p.now()
+
+	if broken {
+		(c.Close())
+		return
+	}
+
+	p.mu.Lock()
+	if p.closed ||
+		(p.config.MaxMessages > 0 && c.messages >= p.config.MaxMessages) ||
+		len(p.idle) >= p.config.MaxIdle {
+		p.mu.Unlock()
+		discard(c)
+		return
+	}
+	p.idle = append(p.idle, c)
+	p.mu.Unlock()
+}
+
+// discard gracefully closes the connection. QUIT is bounded by the I/O timeout.
+func discard(c *poolConn) {
+	if err := c.Quit(); err != nil {
+		(c.Close())
+	}
+}
+
+// Close closes the idle connections. The connections in use are
+// closed once released.
+func (p *pool) Close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle, p.closed = nil, true
+	p.mu.Unlock()
+
+	var errs []error
+	for _, c := range idle {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// UnavailableError is returned while the dials to the SMTP server are
+// delayed after a failed one
+type UnavailableError struct {
+	// RetryIn is the remaining delay before the next dial
+	RetryIn time.Duration
+	// Err is the error of the last dial
+	Err error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("smtp server unavailable, next dial in %s: %s", e.RetryIn.Round(time.Millisecond), e.Err)
+}
+
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// isBroken returns true if the connection can't be reused after the given
+// error: SMTP replies leave the connection usable, except 421 which means
+// the server is closing it.
+func isBroken(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var replyErr *textproto.Error
+	if errors.As(err, &replyErr) {
+		return replyErr.Code == serviceNotAvailable
+	}
+
+	var convErr *converter.Error
+	return !errors.As(err, &convErr)
+}
@@ -0,0 +1,316 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eexit/http2smtp/internal/converter"
+)
+
+// countingDialer dials fake connections and counts them
+type countingDialer struct {
+	mu     sync.Mutex
+	dialed int
+	closed int
+	reset  func() error
+	err    error
+}
+
+func (d *countingDialer) dial(context.Context) (goSMTP, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	d.dialed++
+
+	closeFn := func() error {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.closed++
+		return nil
+	}
+	return &fakeSMTP{reset: d.reset, quit: closeFn, close: closeFn}, nil
+}
+
+func (d *countingDialer) counts() (int, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dialed, d.closed
+}
+
+func TestPool_reuse(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     PoolConfig
+		reset      func() error
+		idleFor    time.Duration
+		messages   int
+		broken     bool
+		wantDialed int
+		wantClosed int
+	}{
+		{
+			name:       "idle connection is reused",
+			config:     PoolConfig{MaxActive: 1, MaxIdle: 1},
+			wantDialed: 1,
+		},
+		{
+			name:       "broken connection is closed",
+			config:     PoolConfig{MaxActive: 1, MaxIdle: 1},
+			broken:     true,
+			wantDialed: 2,
+			wantClosed: 1,
+		},
+		{
+			name:       "connection is closed when no idle connection is kept",
+			config:     PoolConfig{MaxActive: 1},
+			wantDialed: 2,
+			wantClosed: 1,
+		},
+		{
+			name:       "connection is closed once it reaches its max messages",
+			config:     PoolConfig{MaxActive: 1, MaxIdle: 1, MaxMessages: 2},
+			messages:   2,
+			wantDialed: 2,
+			wantClosed: 1,
+		},
+		{
+			name:       "connection under its max messages is reused",
+			config:     PoolConfig{MaxActive: 1, MaxIdle: 1, MaxMessages: 2},
+			messages:   1,
+			wantDialed: 1,
+		},
+		{
+			name:       "stale connection is closed",
+			config:     PoolConfig{MaxActive: 1, MaxIdle: 1, IdleTimeout: time.Minute},
+			idleFor:    2 * time.Minute,
+			wantDialed: 2,
+			wantClosed: 1,
+		},
+		{
+			name:       "dead connection is closed",
+			config:     PoolConfig{MaxActive: 1, MaxIdle: 1},
+			reset:      func() error { return errors.New("EOF") },
+			wantDialed: 2,
+			wantClosed: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &countingDialer{reset: tt.reset}
+			p := newPool(tt.config, Backoff{}, d.dial)
+			now := time.Now()
+			p.now = func() time.Time { return now }
+
+			c, err := p.get(context.Background())
+			if err != nil {
+				t.Fatalf("pool.get() error = %v", err)
+			}
+			c.messages = tt.messages
+			p.put(c, tt.broken)
+
+			now = now.Add(tt.idleFor)
+
+			if _, err := p.get(context.Background()); err != nil {
+				t.Fatalf("pool.get() error = %v", err)
+			}
+
+			if dialed, closed := d.counts(); dialed != tt.wantDialed || closed != tt.wantClosed {
+				t.Errorf("pool dialed %d and closed %d connections, want %d and %d", dialed, closed, tt.wantDialed, tt.wantClosed)
+			}
+		})
+	}
+}
+
+func TestPool_bounded(t *testing.T) {
+	d := &countingDialer{}
+	p := newPool(PoolConfig{MaxActive: 2, MaxIdle: 2}, Backoff{}, d.dial)
+
+	c1, _ := p.get(context.Background())
+	c2, _ := p.get(context.Background())
+
+	// The pool is exhausted until a connection is released
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.get(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("pool.get() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	got := make(chan *poolConn)
+	go func() {
+		c, _ := p.get(context.Background())
+		got <- c
+	}()
+
+	p.put(c1, false)
+	if c := <-got; c != c1 {
+		t.Errorf("pool.get() = %p, want the released connection %p", c, c1)
+	}
+	p.put(c2, false)
+
+	if dialed, _ := d.counts(); dialed != 2 {
+		t.Errorf("pool dialed %d connections, want 2", dialed)
+	}
+}
+
+func TestPool_concurrency(t *testing.T) {
+	d := &countingDialer{}
+	p := newPool(PoolConfig{MaxActive: 3, MaxIdle: 3}, Backoff{}, d.dial)
+
+	var (
+		wg          sync.WaitGroup
+		mu          sync.Mutex
+		inUse, peak int
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			c, err := p.get(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			mu.Lock()
+			if inUse++; inUse > peak {
+				peak = inUse
+			}
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mu.Lock()
+			inUse--
+			mu.Unlock()
+
+			p.put(c, false)
+		}()
+	}
+	wg.Wait()
+
+	if dialed, _ := d.counts(); dialed > 3 || peak > 3 {
+		t.Errorf("pool dialed %d connections with %d in use at once, want at most 3", dialed, peak)
+	}
+}
+
+func TestPool_Close(t *testing.T) {
+	d := &countingDialer{}
+	p := newPool(PoolConfig{MaxActive: 2, MaxIdle: 2}, Backoff{}, d.dial)
+
+	c1, _ := p.get(context.Background())
+	c2, _ := p.get(context.Background())
+	p.put(c1, false)
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("pool.Close() error = %v", err)
+	}
+	if _, closed := d.counts(); closed != 1 {
+		t.Errorf("pool closed %d connections, want the idle one", closed)
+	}
+
+	// Connections in use are closed once released
+	p.put(c2, false)
+	if _, closed := d.counts(); closed != 2 {
+		t.Errorf("pool closed %d connections, want 2", closed)
+	}
+
+	if _, err := p.get(context.Background()); err == nil {
+		t.Error("pool.get() error = nil, want a closed pool error")
+	}
+}
+
+func TestPool_dialError(t *testing.T) {
+	d := &countingDialer{err: errors.New("connection refused")}
+	p := newPool(PoolConfig{MaxActive: 1, MaxIdle: 1}, Backoff{}, d.dial)
+
+	for i := 0; i < 2; i++ {
+		if _, err := p.get(context.Background()); err == nil {
+			t.Fatal("pool.get() error = nil, want a dial error")
+		}
+	}
+
+	// The failed dials don't hold any connection token
+	d.err = nil
+	if _, err := p.get(context.Background()); err != nil {
+		t.Errorf("pool.get() error = %v", err)
+	}
+}
+
+func TestPool_dialCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	d := &countingDialer{err: context.Canceled}
+	p := newPool(PoolConfig{MaxActive: 1, MaxIdle: 1}, Backoff{Min: time.Minute, Max: time.Minute}, d.dial)
+
+	if _, err := p.get(ctx); err == nil {
+		t.Fatal("pool.get() error = nil, want a dial error")
+	}
+
+	// The other sendings are not delayed by the aborted dial
+	d.err = nil
+	if _, err := p.get(context.Background()); err != nil {
+		t.Errorf("pool.get() error = %v, want no backoff", err)
+	}
+}
+
+func Test_isBroken(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "no error"},
+		{name: "SMTP reply", err: &textproto.Error{Code: 550, Msg: "mailbox unavailable"}},
+		{name: "wrapped SMTP reply", err: fmt.Errorf("rcpt: %w", &textproto.Error{Code: 451, Msg: "try again later"})},
+		{name: "service not available", err: &textproto.Error{Code: 421, Msg: "closing connection"}, want: true},
+		{name: "message error", err: &converter.Error{Kind: converter.KindUnprocessable, Err: errors.New("invalid address")}},
+		{name: "network error", err: errors.New("broken pipe"), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isBroken(tt.err); got != tt.want {
+				t.Errorf("isBroken() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPool_dialBackoff(t *testing.T) {
+	now := time.Now()
+	d := &countingDialer{err: errors.New("connection refused")}
+	p := newPool(PoolConfig{MaxActive: 1, MaxIdle: 1}, Backoff{Min: time.Minute, Max: time.Minute}, d.dial)
+	p.now = func() time.Time { return now }
+
+	if _, err := p.get(context.Background()); !errors.Is(err, d.err) {
+		t.Fatalf("pool.get() error = %v, want %v", err, d.err)
+	}
+
+	// No dial is attempted until the backoff delay is over
+	d.err = nil
+	_, err := p.get(context.Background())
+	var unavailableErr *UnavailableError
+	if !errors.As(err, &unavailableErr) {
+		t.Fatalf("pool.get() error = %v, want an *UnavailableError", err)
+	}
+	if dialed, _ := d.counts(); dialed != 0 {
+		t.Errorf("dialed = %d, want 0", dialed)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := p.get(context.Background()); err != nil {
+		t.Fatalf("pool.get() error = %v", err)
+	}
+	if p.failures != 0 || !p.retryAt.IsZero() {
+		t.Errorf("pool failures = %d, retryAt = %v, want the backoff to be reset", p.failures, p.retryAt)
+	}
+}
@@ -0,0 +1,309 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eexit/http2smtp/internal/converter"
+	ictx "github.com/eexit/http2smtp/internal/ctx"
+	"github.com/rs/zerolog"
+)
+
+// Strategy is how the upstream relays are chosen for each message
+type Strategy string
+
+const (
+	// StrategyPriority sends to the first available upstream, in the configured order
+	StrategyPriority Strategy = "priority"
+	// StrategyRoundRobin sends to each available upstream in turn
+	StrategyRoundRobin Strategy = "round-robin"
+	// StrategyWeighted sends to the available upstreams randomly, in proportion
+	// to their weight
+	StrategyWeighted Strategy = "weighted"
+)
+
+// ParseStrategy returns the strategy of the given name
+func ParseStrategy(name string) (Strategy, error) {
+	switch strategy := Strategy(name); strategy {
+	case StrategyPriority, StrategyRoundRobin, StrategyWeighted:
+		return strategy, nil
+	case "":
+		return StrategyPriority, nil
+	}
+	return "", fmt.Errorf("unknown relay strategy %#v", name)
+}
+
+// Upstream is an upstream SMTP relay
+type Upstream struct {
+	// Addr is the hostname:port of the relay
+	Addr string
+	// Weight is the share of the messages sent to the relay with the weighted strategy
+	Weight int
+}
+
+// ParseUpstreams parses the upstream relays given as hostname:port, optionally
+// followed by their weight (e.g. smtp.example.com:25=3). The weight defaults to 1.
+func ParseUpstreams(addrs []string) ([]Upstream, error) {
+	upstreams := make([]Upstream, 0, len(addrs))
+	for _, addr := range addrs {
+		u := Upstream{Addr: strings.TrimSpace(addr), Weight: 1}
+
+		if i := strings.LastIndex(u.Addr, "="); i >= 0 {
+			weight, err := strconv.Atoi(u.Addr[i+1:])
+			if err != nil || weight < 1 {
+				return nil, fmt.Errorf("invalid weight of upstream relay %#v", addr)
+			}
+			u.Addr, u.Weight = u.Addr[:i], weight
+		}
+
+		if u.Addr == "" {
+			return nil, errors.New("empty upstream relay address")
+		}
+		upstreams = append(upstreams, u)
+	}
+
+	if len(upstreams) == 0 {
+		return nil, errors.New("no upstream relay")
+	}
+	return upstreams, nil
+}
+
+// RelayConfig holds the upstream selection and health tracking settings
+type RelayConfig struct {
+	Strategy Strategy
+	// FailureThreshold is the number of consecutive failures after which an
+	// upstream is skipped (its circuit is open). Upstreams are never skipped when 0.
+	FailureThreshold int
+	// Cooldown is the duration an upstream is skipped for. It is tried again
+	// afterwards: a success closes its circuit while a failure opens it again.
+	Cooldown time.Duration
+}
+
+// DefaultRelayConfig is the relay config used unless another one is given
+var DefaultRelayConfig = RelayConfig{
+	Strategy:         StrategyPriority,
+	FailureThreshold: 3,
+	Cooldown:         30 * time.Second,
+}
+
+// upstream is an upstream relay client and its health
+type upstream struct {
+	Upstream
+	client Client
+	// failures is the number of consecutive failures
+	failures int
+	// openUntil is the time until which the upstream is skipped
+	openUntil time.Time
+}
+
+// relayClient sends the messages through several upstream relays, failing over
+// to the next one when an upstream fails transiently
+type relayClient struct {
+	upstreams []*upstream
+	config    RelayConfig
+	// retryPolicy retries the sendings failing on all the upstreams
+	retryPolicy RetryPolicy
+	logger      zerolog.Logger
+	mu          sync.Mutex
+	// next is the index of the next upstream of the round-robin strategy
+	next int
+	now  func() time.Time
+	rand func(n int) int
+}
+
+// NewRelay returns a client sending through the given upstream relays. Each
+// upstream gets its own client created with the given options. The upstream
+// clients don't retry: a failing upstream is failed over right away and the
+// retry policy applies to the sendings failing on all the upstreams.
+func NewRelay(upstreams []Upstream, config RelayConfig, logger zerolog.Logger, opts ...Option) Client {
+	settings := &smtpClient{retryPolicy: DefaultRetryPolicy}
+	for _, opt := range opts {
+		opt(settings)
+	}
+	opts = append(opts[:len(opts):len(opts)], WithRetry(NoRetry))
+
+	r := &relayClient{
+		config:      config,
+		retryPolicy: settings.retryPolicy,
+		logger:      logger,
+		now:         time.Now,
+		rand:        rand.Intn,
+	}
+
+	for _, u := range upstreams {
+		if u.Weight < 1 {
+			u.Weight = 1
+		}
+		r.upstreams = append(r.upstreams, &upstream{
+			Upstream: u,
+			client:   New(u.Addr, logger, opts...),
+		})
+	}
+
+	logger.Info().
+		Int("upstreams", len(upstreams)).
+		Str("strategy", string(config.Strategy)).
+		Msg("relaying through upstream smtp servers")
+
+	return r
+}
+
+// Send sends the message through the upstreams chosen by the strategy. Transient
+// failures fail over to the next upstream while permanent ones are returned
+// right away: the message would be rejected by the other upstreams as well.
+// The message is sent again to the recipients it was not delivered to only.
+func (r *relayClient) Send(ctx context.Context, msg *converter.Message) (int, error) {
+	logger := r.logger
+	if traceID := ictx.TraceID(ctx); traceID != "" {
+		logger = logger.With().Str("trace_id", traceID).Logger()
+	}
+
+	envelope := msg.Envelope()
+	defer msg.SetEnvelope(envelope)
+
+	d := &delivery{}
+	err := retry(ctx, logger, r.retryPolicy, func() error {
+		return r.failover(ctx, logger, msg, d)
+	})
+	if err != nil && len(d.delivered) > 0 {
+		err = &DeliveryError{Delivered: d.delivered, Err: err}
+	}
+	return d.accepted, err
+}
+
+// failover sends the message through the upstreams until one of them does not
+// fail transiently. The recipients delivered are removed from the message.
+func (r *relayClient) failover(ctx context.Context, logger zerolog.Logger, msg *converter.Message, d *delivery) error {
+	var err error
+	for _, u := range r.order() {
+		var accepted int
+		accepted, err = u.client.Send(ctx, msg)
+		d.accepted += accepted
+
+		// The recipients of a partial delivery are not sent the message twice
+		var deliveryErr *DeliveryError
+		if errors.As(err, &deliveryErr) {
+			d.delivered = append(d.delivered, deliveryErr.Delivered...)
+			msg.SetEnvelope(msg.Envelope().Without(deliveryErr.Delivered))
+			err = deliveryErr.Err
+		}
+
+		if err == nil || !IsTransient(err) {
+			r.record(u, true)
+			if err == nil {
+				logger.Info().
+					Str("upstream", u.Addr).
+					Str("message_id", msg.MessageID()).
+					Int("accepted", d.accepted).
+					Msg("message delivered by upstream")
+			}
+			return err
+		}
+
+		r.record(u, false)
+		logger.Warn().Err(err).Str("upstream", u.Addr).Msg("upstream failed, failing over")
+	}
+
+	return err
+}
+
+// Close closes the upstream clients
+func (r *relayClient) Close() error {
+	var errs []error
+	for _, u := range r.upstreams {
+		if err := u.client.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// order returns the upstreams to try, in order. The upstreams whose circuit
+// is open are skipped, unless all of them are.
+func (r *relayClient) order() []*upstream {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	var available, skipped []*upstream
+	for _, u := range r.upstreams {
+		if now.Before(u.openUntil) {
+			skipped = append(skipped, u)
+		} else {
+			available = append(available, u)
+		}
+	}
+
+	if len(available) == 0 {
+		// Giving a chance to the skipped upstreams beats failing for sure
+		available = skipped
+	}
+
+	switch r.config.Strategy {
+	case StrategyRoundRobin:
+		start := r.next % len(available)
+		r.next++
+		available = append(append([]*upstream{}, available[start:]...), available[:start]...)
+	case StrategyWeighted:
+		available = r.shuffle(available)
+	case StrategyPriority:
+	}
+
+	return available
+}
+
+// shuffle returns the upstreams in a random order where the upstreams with
+// the heaviest weights tend to come first
+func (r *relayClient) shuffle(upstreams []*upstream) []*upstream {
+	remaining := append([]*upstream{}, upstreams...)
+	ordered := make([]*upstream, 0, len(upstreams))
+
+	for len(remaining) > 0 {
+		total := 0
+		for _, u := range remaining {
+			total += u.Weight
+		}
+
+		pick := r.rand(total)
+		for i, u := range remaining {
+			if pick < u.Weight {
+				ordered = append(ordered, u)
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				break
+			}
+			pick -= u.Weight
+		}
+	}
+	return ordered
+}
+
+// record tracks the health of the upstream: its circuit opens once it has
+// failed FailureThreshold times in a row and closes on its next success
+func (r *relayClient) record(u *upstream, healthy bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if healthy {
+		if !u.openUntil.IsZero() {
+			r.logger.Info().Str("upstream", u.Addr).Msg("upstream is healthy again")
+		}
+		u.failures, u.openUntil = 0, time.Time{}
+		return
+	}
+
+	u.failures++
+	if r.config.FailureThreshold > 0 && u.failures >= r.config.FailureThreshold {
+		u.openUntil = r.now().Add(r.config.Cooldown)
+		r.logger.Warn().
+			Str("upstream", u.Addr).
+			Int("failures", u.failures).
+			Time("until", u.openUntil).
+			Msg("upstream is unhealthy, skipping it")
+	}
+}
@@ -0,0 +1,355 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eexit/http2smtp/internal/converter"
+	"github.com/rs/zerolog"
+)
+
+func TestParseUpstreams(t *testing.T) {
+	tests := []struct {
+		name    string
+		addrs   []string
+		want    []Upstream
+		wantErr bool
+	}{
+		{
+			name:  "single upstream",
+			addrs: []string{"smtp:25"},
+			want:  []Upstream{{Addr: "smtp:25", Weight: 1}},
+		},
+		{
+			name:  "weighted upstreams",
+			addrs: []string{"smtp1:25=3", " smtp2:587 "},
+			want:  []Upstream{{Addr: "smtp1:25", Weight: 3}, {Addr: "smtp2:587", Weight: 1}},
+		},
+		{
+			name:    "invalid weight",
+			addrs:   []string{"smtp:25=heavy"},
+			wantErr: true,
+		},
+		{
+			name:    "zero weight",
+			addrs:   []string{"smtp:25=0"},
+			wantErr: true,
+		},
+		{
+			name:    "empty address",
+			addrs:   []string{"=2"},
+			wantErr: true,
+		},
+		{
+			name:    "no upstream",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseUpstreams(tt.addrs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseUpstreams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseUpstreams() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// recordingClient records the upstreams messages are sent to
+type recordingClient struct {
+	addr      string
+	sent      *[]string
+	errs      []error
+	calls     int
+	envelopes []converter.Envelope
+}
+
+func (c *recordingClient) Send(_ context.Context, msg *converter.Message) (int, error) {
+	c.calls++
+	c.envelopes = append(c.envelopes, msg.Envelope())
+	*c.sent = append(*c.sent, c.addr)
+	if c.calls <= len(c.errs) {
+		return 0, c.errs[c.calls-1]
+	}
+	return 1, nil
+}
+
+func (c *recordingClient) Close() error {
+	return nil
+}
+
+func newTestRelay(config RelayConfig, sent *[]string, errs map[string][]error, upstreams ...Upstream) *relayClient {
+	r := &relayClient{
+		config: config,
+		logger: zerolog.Nop(),
+		now:    time.Now,
+		rand:   func(n int) int { return n - 1 },
+	}
+	for _, u := range upstreams {
+		r.upstreams = append(r.upstreams, &upstream{
+			Upstream: u,
+			client:   &recordingClient{addr: u.Addr, sent: sent, errs: errs[u.Addr]},
+		})
+	}
+	return r
+}
+
+func TestRelay_Send(t *testing.T) {
+	unavailable := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	rejected := &textproto.Error{Code: 550, Msg: "mailbox unavailable"}
+
+	tests := []struct {
+		name      string
+		config    RelayConfig
+		upstreams []Upstream
+		errs      map[string][]error
+		sends     int
+		want      []string
+		wantErr   bool
+	}{
+		{
+			name:      "priority sends to the first upstream",
+			config:    RelayConfig{Strategy: StrategyPriority},
+			upstreams: []Upstream{{Addr: "a", Weight: 1}, {Addr: "b", Weight: 1}},
+			sends:     2,
+			want:      []string{"a", "a"},
+		},
+		{
+			name:      "transient failure fails over",
+			config:    RelayConfig{Strategy: StrategyPriority},
+			upstreams: []Upstream{{Addr: "a", Weight: 1}, {Addr: "b", Weight: 1}},
+			errs:      map[string][]error{"a": {unavailable}},
+			sends:     2,
+			want:      []string{"a", "b", "a"},
+		},
+		{
+			name:      "permanent failure is returned",
+			config:    RelayConfig{Strategy: StrategyPriority},
+			upstreams: []Upstream{{Addr: "a", Weight: 1}, {Addr: "b", Weight: 1}},
+			errs:      map[string][]error{"a": {rejected}},
+			sends:     1,
+			want:      []string{"a"},
+			wantErr:   true,
+		},
+		{
+			name:      "partial delivery is not failed over on permanent failure",
+			config:    RelayConfig{Strategy: StrategyPriority},
+			upstreams: []Upstream{{Addr: "a", Weight: 1}, {Addr: "b", Weight: 1}},
+			errs:      map[string][]error{"a": {&DeliveryError{Delivered: []string{"to@example.com"}, Err: rejected}}},
+			sends:     1,
+			want:      []string{"a"},
+			wantErr:   true,
+		},
+		{
+			name:      "all upstreams fail",
+			config:    RelayConfig{Strategy: StrategyPriority},
+			upstreams: []Upstream{{Addr: "a", Weight: 1}, {Addr: "b", Weight: 1}},
+			errs:      map[string][]error{"a": {unavailable}, "b": {unavailable}},
+			sends:     1,
+			want:      []string{"a", "b"},
+			wantErr:   true,
+		},
+		{
+			name:      "round-robin sends to each upstream in turn",
+			config:    RelayConfig{Strategy: StrategyRoundRobin},
+			upstreams: []Upstream{{Addr: "a", Weight: 1}, {Addr: "b", Weight: 1}, {Addr: "c", Weight: 1}},
+			sends:     4,
+			want:      []string{"a", "b", "c", "a"},
+		},
+		{
+			name:      "weighted sends to the upstreams in proportion to their weight",
+			config:    RelayConfig{Strategy: StrategyWeighted},
+			upstreams: []Upstream{{Addr: "a", Weight: 1}, {Addr: "b", Weight: 3}},
+			sends:     1,
+			want:      []string{"b"},
+		},
+		{
+			name:      "unhealthy upstream is skipped",
+			config:    RelayConfig{Strategy: StrategyPriority, FailureThreshold: 1, Cooldown: time.Hour},
+			upstreams: []Upstream{{Addr: "a", Weight: 1}, {Addr: "b", Weight: 1}},
+			errs:      map[string][]error{"a": {unavailable}},
+			sends:     3,
+			want:      []string{"a", "b", "b", "b"},
+		},
+		{
+			name:      "unhealthy upstreams are tried when none is healthy",
+			config:    RelayConfig{Strategy: StrategyPriority, FailureThreshold: 1, Cooldown: time.Hour},
+			upstreams: []Upstream{{Addr: "a", Weight: 1}},
+			errs:      map[string][]error{"a": {unavailable}},
+			sends:     2,
+			want:      []string{"a", "a"},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent []string
+			r := newTestRelay(tt.config, &sent, tt.errs, tt.upstreams...)
+
+			var err error
+			for i := 0; i < tt.sends; i++ {
+				msg := converter.NewMessage("from@example.com", []string{"to@example.com"}, nil, nil, strings.NewReader(""))
+				if _, sendErr := r.Send(context.Background(), msg); sendErr != nil {
+					err = sendErr
+				}
+			}
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("relay.Send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(sent, tt.want) {
+				t.Errorf("upstreams = %v, want %v", sent, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelay_Send_partialDelivery(t *testing.T) {
+	var sent []string
+	r := newTestRelay(
+		RelayConfig{Strategy: StrategyPriority},
+		&sent,
+		map[string][]error{"a": {&DeliveryError{Delivered: []string{"to@example.com"}, Err: io.ErrUnexpectedEOF}}},
+		Upstream{Addr: "a", Weight: 1},
+		Upstream{Addr: "b", Weight: 1},
+	)
+
+	msg := converter.NewMessage("from@example.com", []string{"to@example.com"}, nil, []string{"bcc@example.com"}, strings.NewReader(""))
+	envelope := msg.Envelope()
+	if _, err := r.Send(context.Background(), msg); err != nil {
+		t.Fatalf("relay.Send() error = %v", err)
+	}
+
+	// The undelivered recipients only fail over
+	want := []converter.Envelope{{From: "from@example.com", Bcc: []string{"bcc@example.com"}}}
+	if got := r.upstreams[1].client.(*recordingClient).envelopes; !reflect.DeepEqual(got, want) {
+		t.Errorf("failed over envelopes = %#v, want %#v", got, want)
+	}
+	if got := msg.Envelope(); !reflect.DeepEqual(got, envelope) {
+		t.Errorf("Message.Envelope() = %#v, want %#v", got, envelope)
+	}
+}
+
+func TestRelay_Send_retry(t *testing.T) {
+	var sent []string
+	r := newTestRelay(
+		RelayConfig{Strategy: StrategyPriority},
+		&sent,
+		map[string][]error{"a": {io.ErrUnexpectedEOF}, "b": {io.ErrUnexpectedEOF}},
+		Upstream{Addr: "a", Weight: 1},
+		Upstream{Addr: "b", Weight: 1},
+	)
+	r.retryPolicy = RetryPolicy{Attempts: 2, Backoff: Backoff{Min: time.Millisecond, Max: time.Millisecond}}
+
+	msg := converter.NewMessage("from@example.com", []string{"to@example.com"}, nil, nil, strings.NewReader(""))
+	if _, err := r.Send(context.Background(), msg); err != nil {
+		t.Fatalf("relay.Send() error = %v", err)
+	}
+
+	// The upstreams are all tried before retrying
+	if want := []string{"a", "b", "a"}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("upstreams = %v, want %v", sent, want)
+	}
+}
+
+func TestNewRelay_failoverLatency(t *testing.T) {
+	// The refused primary upstream
+	refused := newLocalListener(t)
+	refusedAddr := refused.Addr().String()
+	refused.Close()
+
+	ln := newLocalListener(t)
+	defer ln.Close()
+	// The pooled connection is reused by the second sending
+	go serveSMTP(ln, nil, false, false)
+
+	c := NewRelay(
+		[]Upstream{{Addr: refusedAddr, Weight: 1}, {Addr: ln.Addr().String(), Weight: 1}},
+		RelayConfig{Strategy: StrategyPriority},
+		zerolog.Nop(),
+		WithRetry(RetryPolicy{Attempts: 3, Backoff: Backoff{Min: time.Second, Max: time.Second}}),
+	)
+	defer c.Close()
+
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		msg := converter.NewMessage("from@example.com", []string{"to@example.com"}, nil, nil, strings.NewReader("Subject: test\r\n\r\nbody\r\n"))
+		if _, err := c.Send(context.Background(), msg); err != nil {
+			t.Fatalf("relay.Send() error = %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("relay.Send() failed over after %s, want no retry of the refused upstream", elapsed)
+		}
+	}
+}
+
+func TestRelay_circuit(t *testing.T) {
+	now := time.Now()
+	var sent []string
+	r := newTestRelay(
+		RelayConfig{Strategy: StrategyPriority, FailureThreshold: 2, Cooldown: time.Minute},
+		&sent,
+		map[string][]error{"a": {io.ErrUnexpectedEOF, io.ErrUnexpectedEOF, io.ErrUnexpectedEOF}},
+		Upstream{Addr: "a", Weight: 1},
+		Upstream{Addr: "b", Weight: 1},
+	)
+	r.now = func() time.Time { return now }
+
+	send := func() {
+		msg := converter.NewMessage("from@example.com", []string{"to@example.com"}, nil, nil, strings.NewReader(""))
+		(r.Send(context.Background(), msg))
+	}
+
+	// The circuit opens after 2 failures in a row
+	send()
+	send()
+	send()
+	if want := []string{"a", "b", "a", "b", "b"}; !reflect.DeepEqual(sent, want) {
+		t.Fatalf("upstreams = %v, want %v", sent, want)
+	}
+
+	// The upstream is tried again after the cooldown: a new failure opens the circuit again
+	now = now.Add(time.Minute)
+	sent = nil
+	send()
+	send()
+	if want := []string{"a", "b", "b"}; !reflect.DeepEqual(sent, want) {
+		t.Fatalf("upstreams = %v, want %v", sent, want)
+	}
+
+	// Then its success closes the circuit
+	now = now.Add(time.Minute)
+	sent = nil
+	send()
+	send()
+	if want := []string{"a", "a"}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("upstreams = %v, want %v", sent, want)
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	for name, want := range map[string]Strategy{
+		"":            StrategyPriority,
+		"priority":    StrategyPriority,
+		"round-robin": StrategyRoundRobin,
+		"weighted":    StrategyWeighted,
+	} {
+		if got, err := ParseStrategy(name); err != nil || got != want {
+			t.Errorf("ParseStrategy(%#v) = %#v, %v, want %#v", name, got, err, want)
+		}
+	}
+
+	if _, err := ParseStrategy("random"); err == nil {
+		t.Error("ParseStrategy() error = nil, want an unknown strategy error")
+	}
+}
@@ -0,0 +1,105 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"time"
+
+	"github.com/eexit/http2smtp/internal/converter"
+	"github.com/rs/zerolog"
+)
+
+// SMTP reply codes (RFC 5321 section 4.2)
+const (
+	// replyClassDivisor gives the class of a reply code, its first digit
+	replyClassDivisor = 100
+	// transientNegativeReply is the class of the transient failures
+	transientNegativeReply = 4
+	// serviceNotAvailable is the reply of a server closing the connection
+	serviceNotAvailable = 421
+)
+
+// RetryPolicy defines how the transient sending failures are retried
+type RetryPolicy struct {
+	// Attempts is the max number of attempts, the first one included
+	Attempts int
+	// Backoff computes the delays between two attempts
+	Backoff Backoff
+}
+
+// DefaultRetryPolicy is the retry policy used unless WithRetry is given
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts: 3,
+	Backoff:  Backoff{Min: time.Second, Max: 10 * time.Second},
+}
+
+// NoRetry is the retry policy of the clients whose caller retries the
+// failed sendings itself, like the delivery queue
+var NoRetry = RetryPolicy{Attempts: 1}
+
+// WithRetry sets the retry policy of the transient sending failures
+func WithRetry(policy RetryPolicy) Option {
+	return func(s *smtpClient) {
+		s.retryPolicy = policy
+	}
+}
+
+// retry calls attempt until it succeeds, fails permanently or the policy
+// attempts are exhausted. It gives up early when the context deadline
+// would be exceeded before the next attempt.
+func retry(ctx context.Context, logger zerolog.Logger, policy RetryPolicy, attempt func() error) error {
+	for n := 1; ; n++ {
+		err := attempt()
+		if err == nil || !IsTransient(err) || n >= policy.Attempts {
+			return err
+		}
+
+		delay := policy.Backoff.Delay(n)
+		var unavailableErr *UnavailableError
+		if errors.As(err, &unavailableErr) && unavailableErr.RetryIn > delay {
+			delay = unavailableErr.RetryIn
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			logger.Warn().Err(err).Msg("transient failure, not retried before the context deadline")
+			return err
+		}
+
+		logger.Warn().Err(err).Int("attempt", n).Dur("retry_in", delay).Msg("transient failure, retrying")
+		transcriptFrom(ctx).Note("transient failure, retrying in %s", delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// IsTransient returns true if the sending may succeed when retried: 4xx
+// replies (e.g. greylisting), network errors and unexpected connection
+// closes are transient. Any other error is permanent, like 5xx replies,
+// message errors or unsupported server extensions.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var replyErr *textproto.Error
+	if errors.As(err, &replyErr) {
+		return replyErr.Code/replyClassDivisor == transientNegativeReply
+	}
+
+	var convErr *converter.Error
+	if errors.As(err, &convErr) {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,200 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eexit/http2smtp/internal/converter"
+	"github.com/rs/zerolog"
+)
+
+func TestSMTP_Send_retry(t *testing.T) {
+	greylisted := &textproto.Error{Code: 451, Msg: "greylisted, try again later"}
+	policy := RetryPolicy{Attempts: 3, Backoff: Backoff{Min: time.Millisecond, Max: time.Millisecond}}
+
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		ctx    func() (context.Context, context.CancelFunc)
+		bcc    []string
+		// failures are the errors returned by the successive RCPT commands
+		failures []error
+		// dialFailures is the number of failed dials before a successful one
+		dialFailures int
+		wantAccepted int
+		wantRcpts    int
+		wantErr      bool
+		// wantDelivered are the recipients reported by a DeliveryError
+		wantDelivered []string
+	}{
+		{
+			name:         "transient failure is retried",
+			policy:       policy,
+			failures:     []error{greylisted},
+			wantAccepted: 1,
+			wantRcpts:    2,
+		},
+		{
+			name:      "permanent failure is not retried",
+			policy:    policy,
+			failures:  []error{&textproto.Error{Code: 550, Msg: "mailbox unavailable"}},
+			wantRcpts: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "attempts are exhausted",
+			policy:    policy,
+			failures:  []error{greylisted, greylisted, greylisted},
+			wantRcpts: 3,
+			wantErr:   true,
+		},
+		{
+			name:      "no retry without policy",
+			failures:  []error{greylisted},
+			wantRcpts: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "no retry policy",
+			policy:    NoRetry,
+			failures:  []error{greylisted},
+			wantRcpts: 1,
+			wantErr:   true,
+		},
+		{
+			name:         "executed transactions are not retried",
+			policy:       policy,
+			bcc:          []string{"bcc@example.com"},
+			failures:     []error{nil, greylisted},
+			wantAccepted: 2,
+			wantRcpts:    3,
+		},
+		{
+			name:          "partial delivery is reported",
+			policy:        policy,
+			bcc:           []string{"bcc@example.com"},
+			failures:      []error{nil, &textproto.Error{Code: 550, Msg: "mailbox unavailable"}},
+			wantAccepted:  1,
+			wantRcpts:     2,
+			wantErr:       true,
+			wantDelivered: []string{"to@example.com"},
+		},
+		{
+			name:         "network failure is retried over a new connection",
+			policy:       policy,
+			failures:     []error{io.ErrUnexpectedEOF},
+			wantAccepted: 1,
+			wantRcpts:    2,
+		},
+		{
+			name:         "dial failure is retried",
+			policy:       policy,
+			dialFailures: 1,
+			wantAccepted: 1,
+			wantRcpts:    1,
+		},
+		{
+			name: "retry would exceed the context deadline",
+			policy: RetryPolicy{
+				Attempts: 3,
+				Backoff:  Backoff{Min: time.Hour, Max: time.Hour},
+			},
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), time.Minute)
+			},
+			failures:  []error{greylisted},
+			wantRcpts: 1,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rcpts, dials := 0, 0
+			conn := &fakeSMTP{
+				mail: strCmdOK,
+				rcpt: func(string) error {
+					rcpts++
+					if rcpts <= len(tt.failures) {
+						return tt.failures[rcpts-1]
+					}
+					return nil
+				},
+				data: dataOK,
+			}
+
+			s := &smtpClient{
+				pool: newPool(PoolConfig{MaxActive: 1, MaxIdle: 1}, Backoff{}, func(context.Context) (goSMTP, error) {
+					dials++
+					if dials <= tt.dialFailures {
+						return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+					}
+					return conn, nil
+				}),
+				logger:      zerolog.Nop(),
+				retryPolicy: tt.policy,
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.ctx != nil {
+				ctx, cancel = tt.ctx()
+			}
+			defer cancel()
+
+			msg := converter.NewMessage("from@example.com", []string{"to@example.com"}, nil, tt.bcc, strings.NewReader(""))
+			got, err := s.Send(ctx, msg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SMTP.Send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.wantAccepted {
+				t.Errorf("SMTP.Send() = %v, want %v", got, tt.wantAccepted)
+			}
+			var deliveryErr *DeliveryError
+			if errors.As(err, &deliveryErr) != (tt.wantDelivered != nil) {
+				t.Errorf("SMTP.Send() error = %v, want a DeliveryError %v", err, tt.wantDelivered != nil)
+			} else if deliveryErr != nil && !reflect.DeepEqual(deliveryErr.Delivered, tt.wantDelivered) {
+				t.Errorf("DeliveryError.Delivered = %v, want %v", deliveryErr.Delivered, tt.wantDelivered)
+			}
+			if rcpts != tt.wantRcpts {
+				t.Errorf("RCPT commands = %v, want %v", rcpts, tt.wantRcpts)
+			}
+		})
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "no error"},
+		{name: "greylisting", err: &textproto.Error{Code: 451, Msg: "try again later"}, want: true},
+		{name: "service not available", err: fmt.Errorf("mail: %w", &textproto.Error{Code: 421, Msg: "closing connection"}), want: true},
+		{name: "permanent failure", err: &textproto.Error{Code: 550, Msg: "mailbox unavailable"}},
+		{name: "message error", err: &converter.Error{Kind: converter.KindTooLarge, Err: errors.New("too large")}},
+		{name: "message read error", err: &converter.Error{Kind: converter.KindUnprocessable, Err: io.ErrUnexpectedEOF}},
+		{name: "context canceled", err: fmt.Errorf("failed to get smtp connection: %w", context.Canceled)},
+		{name: "network error", err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}, want: true},
+		{name: "connection closed", err: fmt.Errorf("data: %w", io.EOF), want: true},
+		{name: "connection closed in the middle of a reply", err: io.ErrUnexpectedEOF, want: true},
+		{name: "unsupported extension", err: errors.New("smtp: server doesn't support AUTH")},
+		{name: "unencrypted connection", err: errors.New("unencrypted connection")},
+		{name: "pool closed", err: fmt.Errorf("failed to get smtp connection: %w", errors.New("smtp connection pool is closed"))},
+		{name: "smtp server unavailable", err: &UnavailableError{RetryIn: time.Second, Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransient(tt.err); got != tt.want {
+				t.Errorf("IsTransient() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,22 +2,29 @@ package smtp
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
-	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/eexit/http2smtp/internal/converter"
 	ictx "github.com/eexit/http2smtp/internal/ctx"
+	"github.com/eexit/http2smtp/internal/dkim"
 	"github.com/rs/zerolog"
 )
 
 // goSMTP exposes the Go SMTP client methods used by this package so
 // it could be easier tested
 type goSMTP interface {
+	Extension(string) (bool, string)
 	Mail(string) error
 	Rcpt(string) error
 	Data() (io.WriteCloser, error)
+	Reset() error
+	Quit() error
 	Close() error
 }
 
@@ -29,13 +36,44 @@ type Client interface {
 
 // smtpClient wraps smtpClient email sending
 type smtpClient struct {
-	addr   string
-	client goSMTP
-	logger zerolog.Logger
+	addr          string
+	pool          *pool
+	poolConfig    PoolConfig
+	logger        zerolog.Logger
+	bounceAddress string
+	verp          bool
+	keyring       *dkim.Keyring
+	tlsMode       TLSMode
+	tlsConfig     *tls.Config
+	credentials   *Credentials
+	retryPolicy   RetryPolicy
+	timeout       time.Duration
+	// reconnectBackoff delays the dials following a failed one
+	reconnectBackoff Backoff
+}
+
+// Option configures the SMTP client
+type Option func(*smtpClient)
+
+// WithBounceAddress sets the envelope sender used for the messages
+// that don't request a return path
+func WithBounceAddress(addr string) Option {
+	return func(s *smtpClient) {
+		s.bounceAddress = addr
+	}
+}
+
+// WithVERP enables the encoding of the recipient address into the envelope
+// sender (Variable Envelope Return Path) so bounces can be matched with the
+// recipient they were issued for. Each recipient gets its own transaction.
+func WithVERP(enabled bool) Option {
+	return func(s *smtpClient) {
+		s.verp = enabled
+	}
 }
 
 // New creates a new Go native SMTP client
-func New(addr string, logger zerolog.Logger) Client {
+func New(addr string, logger zerolog.Logger, opts ...Option) Client {
 	logger = logger.With().Dict(
 		"smtp", zerolog.Dict().
 			Fields(map[string]interface{}{
@@ -43,18 +81,33 @@ func New(addr string, logger zerolog.Logger) Client {
 				"addr": addr,
 			})).Logger()
 
-	logger.Info().Msg("dialing to smtp server")
-
-	client, err := smtp.Dial(addr)
-	if err != nil {
-		logger.Panic().Err(err).Msg("could not dial to smtp server")
+	s := &smtpClient{
+		addr:             addr,
+		logger:           logger,
+		tlsMode:          TLSNone,
+		poolConfig:       DefaultPoolConfig,
+		reconnectBackoff: DefaultReconnectBackoff,
+		retryPolicy:      DefaultRetryPolicy,
+		timeout:          DefaultTimeout,
 	}
 
-	return &smtpClient{
-		addr:   addr,
-		client: client,
-		logger: logger,
+	for _, opt := range opts {
+		opt(s)
 	}
+
+	// Connections are dialed on demand so the service starts while the
+	// SMTP server is unavailable
+	s.pool = newPool(s.poolConfig, s.reconnectBackoff, func(ctx context.Context) (goSMTP, error) {
+		s.logger.Info().Str("tls", string(s.tlsMode)).Msg("dialing to smtp server")
+		client, err := s.dial(ctx)
+		if err != nil {
+			s.logger.Error().Err(err).Msg("could not dial to smtp server")
+			return nil, err
+		}
+		return client, nil
+	})
+
+	return s
 }
 
 // Send sends given messsage and returns the number accepted recipients by the server.
@@ -72,72 +125,233 @@ func (s *smtpClient) Send(ctx context.Context, msg *converter.Message) (int, err
 		logger = logger.With().Str("trace_id", traceID).Logger()
 	}
 
-	raw, err := msg.Raw()
-	if err != nil {
-		logger.Error().Err(err).Msg("failed to read email data")
-		return 0, err
-	}
-
 	if !msg.HasRecipients() {
 		return 0, errors.New("message has no recipient")
 	}
 
 	logger.Info().Msg("sending message")
 
-	accepted := 0
 	// Loops over all recipients lists and execute one email transaction per list
-	for _, tos := range buildRcptLists(msg) {
+	d := &delivery{lists: buildRcptLists(msg)}
+	if s.verp {
+		d.lists = splitRcptLists(d.lists)
+	}
+
+	// The transactions that failed transiently are retried, the ones
+	// already executed are not
+	err := retry(ctx, logger, s.retryPolicy, func() error {
+		return s.attempt(ctx, logger, msg, d)
+	})
+	if err != nil {
+		if len(d.delivered) > 0 {
+			err = &DeliveryError{Delivered: d.delivered, Err: err}
+		}
+		return d.accepted, err
+	}
+
+	logger.Info().Int("accepted", d.accepted).Msg("message sent")
+
+	return d.accepted, nil
+}
+
+// delivery tracks the progress of the sending of a message over its attempts
+type delivery struct {
+	// lists are the recipient lists whose transaction is yet to be executed
+	lists [][]string
+	// delivered are the recipients of the executed transactions
+	delivered []string
+	accepted  int
+}
+
+// DeliveryError is returned when the sending failed after the message was
+// delivered to some of its recipients
+type DeliveryError struct {
+	// Delivered are the recipients the message was delivered to
+	Delivered []string
+	Err       error
+}
+
+func (e *DeliveryError) Error() string {
+	return fmt.Sprintf("message delivered to %d recipient(s) only: %s", len(e.Delivered), e.Err)
+}
+
+func (e *DeliveryError) Unwrap() error {
+	return e.Err
+}
+
+// attempt executes the remaining transactions of the message over a pooled connection
+func (s *smtpClient) attempt(ctx context.Context, logger zerolog.Logger, msg *converter.Message, d *delivery) error {
+	transcript := transcriptFrom(ctx)
+
+	c, err := s.pool.get(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to get smtp connection: %w", err)
+		transcript.Note("%s", err)
+		return err
+	}
+
+	var conn goSMTP = c
+	if transcript != nil {
+		transcript.Note("connected to %s", s.addr)
+		conn = &recordingSMTP{goSMTP: c, transcript: transcript}
+	}
+
+	err = s.send(ctx, logger, conn, msg, d)
+	if err == nil {
+		c.messages++
+	}
+	s.pool.put(c, isBroken(err))
+
+	return err
+}
+
+// send executes the transactions of the message over the given connection
+func (s *smtpClient) send(ctx context.Context, logger zerolog.Logger, c goSMTP, msg *converter.Message, d *delivery) error {
+	t, msg, err := s.negotiateTransfer(logger, c, msg)
+	if err != nil {
+		return err
+	}
+
+	if t.signature, err = s.sign(logger, msg, t); err != nil {
+		return &converter.Error{Kind: converter.KindInternal, Err: err}
+	}
+
+	if err := s.checkSize(c, msg, t); err != nil {
+		return err
+	}
+
+	for len(d.lists) > 0 {
+		tos := d.lists[0]
+
 		select {
 		case <-ctx.Done():
+			// The recipients left are reported by a DeliveryError when some
+			// were delivered already
 			logger.Warn().Msgf("process aborted: %s", ctx.Err())
-			return accepted, nil
+			return fmt.Errorf("process aborted: %w", ctx.Err())
 		default:
 			logger.Debug().Strs("tos", tos).Msg("executing transaction")
-			if err := s.execTransaction(logger, msg.From(), tos, raw); err != nil {
-				return accepted, fmt.Errorf("an error occurred while sending emails: %w", err)
+			if err := s.execTransaction(logger, c, msg, tos, t); err != nil {
+				return fmt.Errorf("an error occurred while sending emails: %w", err)
 			}
-			accepted += len(tos)
+			d.lists = d.lists[1:]
+			d.delivered = append(d.delivered, tos...)
+			d.accepted += len(tos)
 			logger.Debug().Strs("tos", tos).Msg("transaction executed")
 		}
 	}
 
-	logger.Info().Int("accepted", accepted).Msg("message sent")
-
-	return accepted, nil
+	return nil
 }
 
-// Close terminates the SMTP connection
+// Close terminates the SMTP connections
 func (s *smtpClient) Close() error {
-	s.logger.Info().Msg("closing smtp server connection")
-	return s.client.Close()
+	s.logger.Info().Msg("closing smtp server connections")
+	return s.pool.Close()
 }
 
-func (s *smtpClient) execTransaction(logger zerolog.Logger, from string, tos []string, raw []byte) error {
+// checkSize rejects the message if it is bigger than the max message size
+// advertised by the server (RFC 1870), so DATA is not issued in vain
+func (s *smtpClient) checkSize(c goSMTP, msg *converter.Message, t transfer) error {
+	ok, param := c.Extension("SIZE")
+	if !ok {
+		return nil
+	}
+
+	max, err := strconv.ParseInt(param, 10, 64)
+	if err != nil || max <= 0 {
+		return nil
+	}
+
+	size := msg.Size()
+	if t.sevenBit {
+		if size, err = msg.WriteTo7Bit(io.Discard); err != nil {
+			return &converter.Error{Kind: converter.KindUnprocessable, Err: fmt.Errorf("failed to encode message: %w", err)}
+		}
+	}
+	size += int64(len(t.signature))
+
+	if size > max {
+		return &converter.Error{
+			Kind: converter.KindTooLarge,
+			Err:  &converter.LimitError{Limit: "smtp server message size", Max: max, Value: size},
+		}
+	}
+	return nil
+}
+
+// envelopeSender returns the MAIL FROM address of a transaction: the message
+// return path, the configured bounce address or the message From: address,
+// VERP encoded when enabled.
+func (s *smtpClient) envelopeSender(msg *converter.Message, tos []string) string {
+	from := msg.ReturnPath()
+	if from == "" {
+		from = s.bounceAddress
+	}
+	if from == "" {
+		from = msg.From()
+	}
+
+	if s.verp && len(tos) == 1 {
+		from = verp(from, tos[0])
+	}
+	return from
+}
+
+func (s *smtpClient) execTransaction(logger zerolog.Logger, c goSMTP, msg *converter.Message, tos []string, t transfer) error {
+	from, err := t.envelopeAddress(s.envelopeSender(msg, tos))
+	if err != nil {
+		return err
+	}
+
 	logger.Debug().Str("from", from).Msg("sending MAIL FROM cmd")
-	if err := s.client.Mail(from); err != nil {
+	if err := c.Mail(from); err != nil {
 		logger.Error().Err(err).Msg("failed to issue MAIL FROM cmd")
 		return err
 	}
 
 	for _, to := range tos {
+		if to, err = t.envelopeAddress(to); err != nil {
+			return err
+		}
+
 		logger.Debug().Str("to", to).Msg("sending RCPT cmd")
-		if err := s.client.Rcpt(to); err != nil {
+		if err := c.Rcpt(to); err != nil {
 			logger.Error().Err(err).Msg("failed to issue RCPT cmd")
 			return err
 		}
 	}
 
 	logger.Debug().Msg("sending DATA cmd")
-	w, err := s.client.Data()
+	w, err := c.Data()
 	if err != nil {
 		logger.Error().Err(err).Msg("failed to issue DATA cmd")
 		return err
 	}
-	defer w.Close()
 
-	logger.Debug().Bytes("data", raw).Msg("writing data")
-	if _, err := w.Write(raw); err != nil {
+	// The message is streamed to the server
+	write := msg.WriteTo
+	if t.sevenBit {
+		write = msg.WriteTo7Bit
+	}
+
+	if _, err := io.WriteString(w, t.signature); err != nil {
 		logger.Error().Err(err).Msg("failed to write DATA")
+		(w.Close())
+		return err
+	}
+
+	n, err := write(w)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to write DATA")
+		(w.Close())
+		return err
+	}
+	logger.Debug().Int64("size", n).Msg("data written")
+
+	// Closing the data writer ends the data and reads the server final reply
+	if err := w.Close(); err != nil {
+		logger.Error().Err(err).Msg("message rejected after DATA")
 		return err
 	}
 	return nil
@@ -179,3 +393,29 @@ func buildRcptLists(msg *converter.Message) [][]string {
 
 	return rcpts
 }
+
+// splitRcptLists splits the given recipient lists into one list per recipient
+func splitRcptLists(lists [][]string) [][]string {
+	var rcpts [][]string
+	for _, list := range lists {
+		for _, rcpt := range list {
+			rcpts = append(rcpts, []string{rcpt})
+		}
+	}
+	return rcpts
+}
+
+// verp encodes the recipient address into the sender one, following the
+// usual convention: bounces+bob=example.com@example.org for the sender
+// bounces@example.org and the recipient bob@example.com.
+func verp(sender, rcpt string) string {
+	at := strings.LastIndex(sender, "@")
+	if at < 0 || rcpt == "" {
+		return sender
+	}
+
+	if i := strings.LastIndex(rcpt, "@"); i >= 0 {
+		rcpt = rcpt[:i] + "=" + rcpt[i+1:]
+	}
+	return sender[:at] + "+" + rcpt + sender[at:]
+}
@@ -5,9 +5,11 @@ import (
 	"errors"
 	"io"
 	"net"
+	"net/textproto"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/eexit/http2smtp/internal/converter"
 	"github.com/eexit/http2smtp/internal/ctx"
@@ -15,60 +17,70 @@ import (
 )
 
 func TestNew(t *testing.T) {
-	t.Run("constructor fails to dial to SMTP server", func(t *testing.T) {
-		defer func() {
-			if r := recover(); r == nil {
-				t.Error("expected to panic")
-			}
-		}()
+	newMsg := func() *converter.Message {
+		return converter.NewMessage("from@example.com", []string{"to@example.com"}, nil, nil, strings.NewReader("Subject: test\r\n\r\nbody\r\n"))
+	}
+
+	t.Run("constructor doesn't dial to SMTP server", func(t *testing.T) {
+		s := New("::", zerolog.New(io.Discard), WithRetry(RetryPolicy{Attempts: 1}))
 
-		New("::", zerolog.New(io.Discard))
+		if _, err := s.Send(context.Background(), newMsg()); err == nil {
+			t.Fatal("SMTP.Send() error = nil, want a dial error")
+		}
+
+		// The next dial is delayed
+		_, err := s.Send(context.Background(), newMsg())
+		var unavailableErr *UnavailableError
+		if !errors.As(err, &unavailableErr) {
+			t.Errorf("SMTP.Send() error = %v, want an *UnavailableError", err)
+		}
 	})
 
-	t.Run("new client dials ok", func(t *testing.T) {
+	t.Run("client reconnects once SMTP server is available", func(t *testing.T) {
 		ln := newLocalListener(t)
-		defer ln.Close()
+		addr := ln.Addr().String()
+		ln.Close()
 
-		go New(ln.Addr().String(), zerolog.New(io.Discard))
+		s := New(addr, zerolog.New(io.Discard), WithReconnectBackoff(Backoff{}), WithRetry(RetryPolicy{Attempts: 1}))
 
-		conn, err := ln.Accept()
+		if _, err := s.Send(context.Background(), newMsg()); err == nil {
+			t.Fatal("SMTP.Send() error = nil, want a dial error")
+		}
+
+		ln, err := net.Listen("tcp", addr)
 		if err != nil {
-			t.Fatalf("failed to accept connection: %v", err)
+			t.Skipf("failed to listen again on %s: %v", addr, err)
 		}
-		defer conn.Close()
+		defer ln.Close()
+		go serveSMTP(ln, nil, false, false)
 
-		send := smtpSender{conn}.send
-		send("220 127.0.0.1 ESMTP service ready")
+		got, err := s.Send(context.Background(), newMsg())
+		if err != nil {
+			t.Fatalf("SMTP.Send() error = %v", err)
+		}
+		if got != 1 {
+			t.Errorf("SMTP.Send() = %v, want %v", got, 1)
+		}
 	})
 
 	t.Run("SMTP server returns a bad handshake", func(t *testing.T) {
 		ln := newLocalListener(t)
 		defer ln.Close()
 
-		errc := make(chan error)
-
 		go func() {
-			defer func() {
-				if r := recover(); r == nil {
-					errc <- errors.New("expected to panic")
-					return
-				}
-				errc <- nil
-			}()
-			New(ln.Addr().String(), zerolog.New(io.Discard))
-		}()
-
-		conn, err := ln.Accept()
-		if err != nil {
-			t.Fatalf("failed to accept connection: %v", err)
-		}
-		defer conn.Close()
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
 
-		send := smtpSender{conn}.send
-		send("502 127.0.0.1 ESMTP service ready")
+			send := smtpSender{conn}.send
+			send("502 127.0.0.1 ESMTP service ready")
+		}()
 
-		if err := <-errc; err != nil {
-			t.Error(err)
+		s := New(ln.Addr().String(), zerolog.New(io.Discard), WithRetry(RetryPolicy{Attempts: 1}))
+		if _, err := s.Send(context.Background(), newMsg()); err == nil {
+			t.Error("SMTP.Send() error = nil, want a handshake error")
 		}
 	})
 }
@@ -96,11 +108,15 @@ func TestSMTP_Send(t *testing.T) {
 			wantErr:  true,
 		},
 		{
-			name:       "error when reading raw message",
-			smtpClient: &fakeSMTP{},
+			name: "error when reading raw message",
+			smtpClient: &fakeSMTP{
+				mail: strCmdOK,
+				rcpt: strCmdOK,
+				data: dataOK,
+			},
 			args: args{
 				ctx: context.Background(),
-				msg: converter.NewMessage("", nil, nil, nil, &failingReader{}),
+				msg: converter.NewMessage("from@example.com", []string{"to@example.com"}, nil, nil, &failingReader{}),
 			},
 			accepted: 0,
 			wantErr:  true,
@@ -128,7 +144,7 @@ func TestSMTP_Send(t *testing.T) {
 				msg: converter.NewMessage("from@example.com", []string{"to@example.com"}, nil, nil, strings.NewReader("")),
 			},
 			accepted: 0,
-			wantErr:  false,
+			wantErr:  true,
 		},
 		{
 			name: "mail command failed",
@@ -182,7 +198,23 @@ func TestSMTP_Send(t *testing.T) {
 			},
 			args: args{
 				ctx: context.Background(),
-				msg: converter.NewMessage("from@example.com", []string{"to@example.com"}, nil, nil, strings.NewReader("")),
+				msg: converter.NewMessage("from@example.com", []string{"to@example.com"}, nil, nil, strings.NewReader("foo")),
+			},
+			accepted: 0,
+			wantErr:  true,
+		},
+		{
+			name: "message rejected after data",
+			smtpClient: &fakeSMTP{
+				mail: strCmdOK,
+				rcpt: strCmdOK,
+				data: func() (io.WriteCloser, error) {
+					return &fakeWriteCloser{closeErr: &textproto.Error{Code: 554, Msg: "message rejected"}}, nil
+				},
+			},
+			args: args{
+				ctx: context.Background(),
+				msg: converter.NewMessage("from@example.com", []string{"to@example.com"}, nil, nil, strings.NewReader("foo")),
 			},
 			accepted: 0,
 			wantErr:  true,
@@ -261,7 +293,7 @@ func TestSMTP_Send(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			s := &smtpClient{
-				client: tt.smtpClient,
+				pool:   newFakePool(tt.smtpClient),
 				logger: zerolog.Nop(),
 			}
 			got, err := s.Send(tt.args.ctx, tt.args.msg)
@@ -276,14 +308,164 @@ func TestSMTP_Send(t *testing.T) {
 	}
 }
 
+func TestSMTP_Send_interrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The context is canceled once the first transaction is executed
+	s := &smtpClient{
+		pool: newFakePool(&fakeSMTP{
+			mail: strCmdOK,
+			rcpt: strCmdOK,
+			data: func() (io.WriteCloser, error) {
+				cancel()
+				return &fakeWriteCloser{}, nil
+			},
+		}),
+		logger: zerolog.Nop(),
+	}
+
+	msg := converter.NewMessage("from@example.com", []string{"to@example.com"}, nil, []string{"bcc@example.com"}, strings.NewReader(""))
+	got, err := s.Send(ctx, msg)
+	if got != 1 {
+		t.Errorf("SMTP.Send() = %v, want 1", got)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("SMTP.Send() error = %v, want %v", err, context.Canceled)
+	}
+	var deliveryErr *DeliveryError
+	if !errors.As(err, &deliveryErr) || !reflect.DeepEqual(deliveryErr.Delivered, []string{"to@example.com"}) {
+		t.Errorf("SMTP.Send() error = %#v, want a DeliveryError for to@example.com", err)
+	}
+}
+
+func TestSMTP_Send_envelopeSender(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []Option
+		msg   *converter.Message
+		wants []string
+	}{
+		{
+			name:  "from address",
+			msg:   converter.NewMessage("from@example.com", []string{"to@example.com"}, nil, []string{"bcc@example.com"}, strings.NewReader("")),
+			wants: []string{"from@example.com", "from@example.com"},
+		},
+		{
+			name:  "bounce address",
+			opts:  []Option{WithBounceAddress("bounces@example.org")},
+			msg:   converter.NewMessage("from@example.com", []string{"to@example.com"}, nil, nil, strings.NewReader("")),
+			wants: []string{"bounces@example.org"},
+		},
+		{
+			name:  "VERP bounce address",
+			opts:  []Option{WithBounceAddress("bounces@example.org"), WithVERP(true)},
+			msg:   converter.NewMessage("from@example.com", []string{"to1@example.com", "to2@example.com"}, []string{"cc@example.com"}, []string{"bcc@example.com"}, strings.NewReader("")),
+			wants: []string{"bounces+to1=example.com@example.org", "bounces+to2=example.com@example.org", "bounces+cc=example.com@example.org", "bounces+bcc=example.com@example.org"},
+		},
+		{
+			name:  "VERP from address",
+			opts:  []Option{WithVERP(true)},
+			msg:   converter.NewMessage("from@example.com", []string{"to@example.com"}, nil, nil, strings.NewReader("")),
+			wants: []string{"from+to=example.com@example.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			s := &smtpClient{
+				pool: newFakePool(&fakeSMTP{
+					mail: func(from string) error {
+						got = append(got, from)
+						return nil
+					},
+					rcpt: strCmdOK,
+					data: dataOK,
+				}),
+				logger: zerolog.Nop(),
+			}
+			for _, opt := range tt.opts {
+				opt(s)
+			}
+
+			if _, err := s.Send(context.Background(), tt.msg); err != nil {
+				t.Fatalf("SMTP.Send() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.wants) {
+				t.Errorf("SMTP.Send() MAIL FROM = %#v, want %#v", got, tt.wants)
+			}
+		})
+	}
+}
+
+func TestSMTP_Send_size(t *testing.T) {
+	const raw = "From: from@example.com\r\n\r\nCafé!\r\n"
+
+	tests := []struct {
+		name       string
+		extensions []string
+		wantKind   converter.ErrorKind
+		wantErr    bool
+	}{
+		{name: "no size advertised", extensions: []string{"8BITMIME"}},
+		{name: "no size limit", extensions: []string{"8BITMIME", "SIZE 0"}},
+		{name: "message within size limit", extensions: []string{"8BITMIME", "SIZE 34"}},
+		{name: "message over size limit", extensions: []string{"8BITMIME", "SIZE 33"}, wantKind: converter.KindTooLarge, wantErr: true},
+		// The quoted-printable encoded message is 83 bytes long
+		{name: "7-bit message within size limit", extensions: []string{"SIZE 83"}},
+		{name: "7-bit message over size limit", extensions: []string{"SIZE 82"}, wantKind: converter.KindTooLarge, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &smtpClient{
+				pool: newFakePool(&fakeSMTP{
+					extensions: tt.extensions,
+					mail:       strCmdOK,
+					rcpt:       strCmdOK,
+					data:       dataOK,
+				}),
+				logger: zerolog.Nop(),
+			}
+
+			msg := converter.NewMessage("from@example.com", []string{"to@example.com"}, nil, nil, strings.NewReader(raw))
+
+			_, err := s.Send(context.Background(), msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SMTP.Send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && converter.KindOf(err) != tt.wantKind {
+				t.Errorf("SMTP.Send() error kind = %v, want %v", converter.KindOf(err), tt.wantKind)
+			}
+		})
+	}
+}
+
+func Test_verp(t *testing.T) {
+	tests := []struct {
+		sender, rcpt, want string
+	}{
+		{"bounces@example.org", "bob@example.com", "bounces+bob=example.com@example.org"},
+		{"bounces@example.org", "bob", "bounces+bob@example.org"},
+		{"bounces@example.org", "", "bounces@example.org"},
+		{"", "bob@example.com", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sender+" "+tt.rcpt, func(t *testing.T) {
+			if got := verp(tt.sender, tt.rcpt); got != tt.want {
+				t.Errorf("verp() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestClose(t *testing.T) {
 	t.Run("SMTP close ok", func(t *testing.T) {
 		s := &smtpClient{
-			client: &fakeSMTP{
+			pool: newFakePool(&fakeSMTP{
 				close: func() error {
 					return nil
 				},
-			},
+			}),
 		}
 		if err := s.Close(); err != nil {
 			t.Errorf("SMTP.Close() = %v, want nil", err)
@@ -293,11 +475,11 @@ func TestClose(t *testing.T) {
 	t.Run("SMTP close error", func(t *testing.T) {
 		wantErr := errors.New("closing error")
 		s := &smtpClient{
-			client: &fakeSMTP{
+			pool: newFakePool(&fakeSMTP{
 				close: func() error {
 					return wantErr
 				},
-			},
+			}),
 		}
 		if err := s.Close(); err == nil {
 			t.Errorf("SMTP.Close() = nil, want %v", wantErr)
@@ -392,10 +574,24 @@ var (
 )
 
 type fakeSMTP struct {
-	mail  func(string) error
-	rcpt  func(string) error
-	data  func() (io.WriteCloser, error)
-	close func() error
+	// extensions lists the extensions supported by the server, followed
+	// by their parameter if any (e.g. "SIZE 1024")
+	extensions []string
+	mail       func(string) error
+	rcpt       func(string) error
+	data       func() (io.WriteCloser, error)
+	reset      func() error
+	quit       func() error
+	close      func() error
+}
+
+func (f *fakeSMTP) Extension(ext string) (bool, string) {
+	for _, e := range f.extensions {
+		if name, param, _ := strings.Cut(e, " "); name == ext {
+			return true, param
+		}
+	}
+	return false, ""
 }
 
 func (f *fakeSMTP) Mail(s string) error {
@@ -419,27 +615,62 @@ func (f *fakeSMTP) Data() (io.WriteCloser, error) {
 	return f.data()
 }
 
+func (f *fakeSMTP) Reset() error {
+	if f.reset == nil {
+		return nil
+	}
+	return f.reset()
+}
+
+func (f *fakeSMTP) Quit() error {
+	if f.quit == nil {
+		return nil
+	}
+	return f.quit()
+}
+
 func (f *fakeSMTP) Close() error {
 	if f.close == nil {
-		panic("not implemented")
+		return nil
 	}
 	return f.close()
 }
 
+// newFakePool returns a pool holding the given connection idle
+func newFakePool(c goSMTP) *pool {
+	p := newPool(PoolConfig{MaxActive: 1, MaxIdle: 1}, Backoff{}, func(context.Context) (goSMTP, error) {
+		return nil, errors.New("no connection left")
+	})
+	p.idle = append(p.idle, &poolConn{goSMTP: c, lastUsed: time.Now()})
+	return p
+}
+
 type failingReader struct{}
 
 func (*failingReader) Read(p []byte) (n int, err error) {
 	return 0, errors.New("read error")
 }
 
+func (*failingReader) ReadAt(p []byte, off int64) (n int, err error) {
+	return 0, errors.New("read error")
+}
+
+func (*failingReader) Size() int64 {
+	return 1
+}
+
 type fakeWriteCloser struct {
 	err error
+	// closeErr is the final reply error returned once the data is written
+	closeErr error
+	buf      strings.Builder
 }
 
 func (f *fakeWriteCloser) Write(p []byte) (int, error) {
+	(f.buf.Write(p))
 	return len(p), f.err
 }
 
-func (*fakeWriteCloser) Close() error {
-	return nil
+func (f *fakeWriteCloser) Close() error {
+	return f.closeErr
 }
@@ -0,0 +1,171 @@
+package smtp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+)
+
+// TLSMode is how the connection to the SMTP server is secured
+type TLSMode string
+
+const (
+	// TLSNone is a plain connection
+	TLSNone TLSMode = "none"
+	// TLSOpportunistic upgrades the connection with STARTTLS when the server supports it
+	TLSOpportunistic TLSMode = "starttls"
+	// TLSRequired upgrades the connection with STARTTLS and fails when the
+	// server does not support it
+	TLSRequired TLSMode = "starttls-required"
+	// TLSImplicit establishes the connection over TLS (SMTPS, usually on port 465)
+	TLSImplicit TLSMode = "implicit"
+)
+
+// ParseTLSMode returns the TLS mode of the given name
+func ParseTLSMode(name string) (TLSMode, error) {
+	switch mode := TLSMode(name); mode {
+	case TLSNone, TLSOpportunistic, TLSRequired, TLSImplicit:
+		return mode, nil
+	case "":
+		return TLSNone, nil
+	}
+	return "", fmt.Errorf("unknown TLS mode %#v", name)
+}
+
+// TLSFiles holds the paths of the PEM encoded files used to secure the connection
+type TLSFiles struct {
+	// CAFile is the bundle of the CA certificates trusted in addition to the system ones
+	CAFile string
+	// CertFile and KeyFile are the client certificate and key, if any
+	CertFile string
+	KeyFile  string
+}
+
+// WithTLS secures the connection to the SMTP server with the given mode and
+// config. The server name defaults to the SMTP server host.
+func WithTLS(mode TLSMode, config *tls.Config) Option {
+	return func(s *smtpClient) {
+		s.tlsMode = mode
+		s.tlsConfig = config
+	}
+}
+
+// NewTLSConfig returns a new TLS config loading the given files. Skipping the
+// certificate verification is only meant to relay to self-signed test servers.
+func NewTLSConfig(files TLSFiles, insecureSkipVerify bool) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+
+	if files.CAFile != "" {
+		pem, err := os.ReadFile(files.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", files.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if files.CertFile != "" || files.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// dial connects to the SMTP server, secures the connection and authenticates
+// as configured. The dial and the commands follow the given context and the
+// I/O timeout.
+func (s *smtpClient) dial(ctx context.Context) (*deadlineConn, error) {
+	host, _, err := net.SplitHostPort(s.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.tlsConfig != nil {
+		config = s.tlsConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+
+	dialer := &net.Dialer{Timeout: s.timeout}
+
+	var conn net.Conn
+	if s.tlsMode == TLSImplicit {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: config}).DialContext(ctx, "tcp", s.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", s.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := newDeadlineConn(conn, s.timeout)
+	release := c.bind(ctx)
+	defer release()
+
+	if err := s.connect(c, host, config); err != nil {
+		(conn.Close())
+		return nil, err
+	}
+
+	if s.credentials != nil {
+		if err := s.authenticate(c, host); err != nil {
+			(c.Close())
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// connect reads the server greeting and secures the connection as configured
+func (s *smtpClient) connect(c *deadlineConn, host string, config *tls.Config) error {
+	if err := c.deadline(); err != nil {
+		return err
+	}
+
+	client, err := smtp.NewClient(c.conn, host)
+	if err != nil {
+		return err
+	}
+	c.Client = client
+
+	if s.tlsMode != TLSOpportunistic && s.tlsMode != TLSRequired {
+		return nil
+	}
+
+	if ok, _ := c.Extension("STARTTLS"); !ok {
+		if s.tlsMode == TLSRequired {
+			return errors.New("smtp server does not support STARTTLS")
+		}
+		s.logger.Warn().Msg("smtp server does not support STARTTLS, connection is not encrypted")
+		return nil
+	}
+
+	if err := c.deadline(); err != nil {
+		return err
+	}
+	if err := client.StartTLS(config); err != nil {
+		return fmt.Errorf("failed to start TLS: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package smtp
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestParseTLSMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    TLSMode
+		wantErr bool
+	}{
+		{name: "", want: TLSNone},
+		{name: "none", want: TLSNone},
+		{name: "starttls", want: TLSOpportunistic},
+		{name: "starttls-required", want: TLSRequired},
+		{name: "implicit", want: TLSImplicit},
+		{name: "ssl", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTLSMode(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTLSMode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseTLSMode() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	cert := newTestCertificate(t)
+	writePEM(t, filepath.Join(dir, "cert.pem"), "CERTIFICATE", cert.Certificate[0])
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, "key.pem"), "PRIVATE KEY", key)
+
+	tests := []struct {
+		name     string
+		files    TLSFiles
+		insecure bool
+		wantErr  bool
+	}{
+		{name: "default config"},
+		{name: "insecure config", insecure: true},
+		{name: "CA bundle", files: TLSFiles{CAFile: filepath.Join(dir, "cert.pem")}},
+		{name: "missing CA bundle", files: TLSFiles{CAFile: filepath.Join(dir, "ghost.pem")}, wantErr: true},
+		{name: "invalid CA bundle", files: TLSFiles{CAFile: filepath.Join(dir, "key.pem")}, wantErr: true},
+		{name: "client certificate", files: TLSFiles{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")}},
+		{name: "client certificate without key", files: TLSFiles{CertFile: filepath.Join(dir, "cert.pem")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewTLSConfig(tt.files, tt.insecure)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewTLSConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got.InsecureSkipVerify != tt.insecure {
+				t.Errorf("NewTLSConfig() InsecureSkipVerify = %v, want %v", got.InsecureSkipVerify, tt.insecure)
+			}
+			if (got.RootCAs != nil) != (tt.files.CAFile != "") {
+				t.Errorf("NewTLSConfig() RootCAs = %v, want CAs %v", got.RootCAs, tt.files.CAFile != "")
+			}
+			if (len(got.Certificates) > 0) != (tt.files.CertFile != "") {
+				t.Errorf("NewTLSConfig() Certificates = %v, want certificate %v", got.Certificates, tt.files.CertFile != "")
+			}
+		})
+	}
+}
+
+func Test_smtpClient_dial(t *testing.T) {
+	cert := newTestCertificate(t)
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	clientConfig := &tls.Config{RootCAs: roots}
+
+	tests := []struct {
+		name     string
+		mode     TLSMode
+		config   *tls.Config
+		implicit bool
+		starttls bool
+		wantTLS  bool
+		wantErr  bool
+	}{
+		{name: "plain connection", mode: TLSNone, starttls: true},
+		{name: "opportunistic STARTTLS", mode: TLSOpportunistic, config: clientConfig, starttls: true, wantTLS: true},
+		{name: "opportunistic STARTTLS not supported", mode: TLSOpportunistic, config: clientConfig},
+		{name: "required STARTTLS", mode: TLSRequired, config: clientConfig, starttls: true, wantTLS: true},
+		{name: "required STARTTLS not supported", mode: TLSRequired, config: clientConfig, wantErr: true},
+		{name: "untrusted certificate", mode: TLSRequired, starttls: true, wantErr: true},
+		{name: "insecure certificate", mode: TLSRequired, config: &tls.Config{InsecureSkipVerify: true}, starttls: true, wantTLS: true},
+		{name: "implicit TLS", mode: TLSImplicit, config: clientConfig, implicit: true, wantTLS: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln := newLocalListener(t)
+			defer ln.Close()
+
+			go serveSMTP(ln, serverConfig, tt.implicit, tt.starttls)
+
+			s := &smtpClient{addr: ln.Addr().String(), logger: zerolog.Nop(), tlsMode: tt.mode, tlsConfig: tt.config}
+
+			client, err := s.dial(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("smtpClient.dial() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			defer client.Close()
+
+			if _, ok := client.TLSConnectionState(); ok != tt.wantTLS {
+				t.Errorf("smtpClient.dial() TLS = %v, want %v", ok, tt.wantTLS)
+			}
+			if err := client.Noop(); err != nil {
+				t.Errorf("smtpClient.dial() connection is not usable: %v", err)
+			}
+		})
+	}
+}
+
+// serveSMTP serves a single SMTP connection, supporting STARTTLS if asked and
+// advertising the given extensions. AUTH PLAIN accepts the user:pass credentials.
+func serveSMTP(ln net.Listener, config *tls.Config, implicit, starttls bool, extensions ...string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if implicit {
+		conn = tls.Server(conn, config)
+	}
+
+	r := bufio.NewReader(conn)
+	send := smtpSender{conn}.send
+	send("220 127.0.0.1 ESMTP service ready")
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+		case "EHLO":
+			lines := append([]string{"127.0.0.1"}, extensions...)
+			if _, secured := conn.(*tls.Conn); starttls && !secured {
+				lines = append(lines, "STARTTLS")
+			}
+			for i, l := range lines {
+				if i < len(lines)-1 {
+					send("250-" + l)
+				} else {
+					send("250 " + l)
+				}
+			}
+		case "STARTTLS":
+			send("220 ready to start TLS")
+			tlsConn := tls.Server(conn, config)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, r, send = tlsConn, bufio.NewReader(tlsConn), smtpSender{tlsConn}.send
+		case "AUTH":
+			if line == "AUTH PLAIN "+base64.StdEncoding.EncodeToString([]byte("\x00user\x00pass"))+"\r\n" {
+				send("235 authenticated")
+			} else {
+				send("535 authentication failed")
+			}
+		case "DATA":
+			send("354 go ahead")
+			for line != ".\r\n" {
+				if line, err = r.ReadString('\n'); err != nil {
+					return
+				}
+			}
+			send("250 queued")
+		case "QUIT":
+			send("221 bye")
+			return
+		default:
+			send("250 ok")
+		}
+	}
+}
+
+// newTestCertificate returns a new self-signed certificate for 127.0.0.1
+func newTestCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func writePEM(t *testing.T, filename, blockType string, der []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,133 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+type transcriptKey struct{}
+
+// Transcript records the SMTP commands issued while sending a message and
+// the server error replies, for troubleshooting. The message content is not
+// recorded.
+type Transcript struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+// WithTranscript returns a context recording the sendings made with it into
+// the given transcript
+func WithTranscript(ctx context.Context, t *Transcript) context.Context {
+	return context.WithValue(ctx, transcriptKey{}, t)
+}
+
+// transcriptFrom returns the transcript of the context, if any
+func transcriptFrom(ctx context.Context) *Transcript {
+	t, _ := ctx.Value(transcriptKey{}).(*Transcript)
+	return t
+}
+
+// String returns the recorded lines
+func (t *Transcript) String() string {
+	if t == nil {
+		return ""
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.lines) == 0 {
+		return ""
+	}
+	return strings.Join(t.lines, "\r\n") + "\r\n"
+}
+
+// command records a line sent by the client
+func (t *Transcript) command(format string, args ...interface{}) {
+	t.add("C: " + fmt.Sprintf(format, args...))
+}
+
+// reply records the server reply to the last command when it failed. The
+// positive replies are not exposed by the SMTP client, so nothing is recorded
+// when err is nil.
+func (t *Transcript) reply(err error) {
+	var replyErr *textproto.Error
+	switch {
+	case err == nil:
+	case errors.As(err, &replyErr):
+		t.add(fmt.Sprintf("S: %d %s", replyErr.Code, replyErr.Msg))
+	default:
+		t.Note("%s", err)
+	}
+}
+
+// Note records an event which is not part of the SMTP conversation
+func (t *Transcript) Note(format string, args ...interface{}) {
+	t.add("* " + fmt.Sprintf(format, args...))
+}
+
+func (t *Transcript) add(line string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lines = append(t.lines, line)
+}
+
+// recordingSMTP records the transaction commands into a transcript
+type recordingSMTP struct {
+	goSMTP
+	transcript *Transcript
+}
+
+func (r *recordingSMTP) Mail(from string) error {
+	r.transcript.command("MAIL FROM:<%s>", from)
+	err := r.goSMTP.Mail(from)
+	r.transcript.reply(err)
+	return err
+}
+
+func (r *recordingSMTP) Rcpt(to string) error {
+	r.transcript.command("RCPT TO:<%s>", to)
+	err := r.goSMTP.Rcpt(to)
+	r.transcript.reply(err)
+	return err
+}
+
+func (r *recordingSMTP) Data() (io.WriteCloser, error) {
+	r.transcript.command("DATA")
+	w, err := r.goSMTP.Data()
+	r.transcript.reply(err)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingWriter{WriteCloser: w, transcript: r.transcript}, nil
+}
+
+// recordingWriter counts the message bytes and records the final reply
+type recordingWriter struct {
+	io.WriteCloser
+	transcript *Transcript
+	n          int
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.n += n
+	return n, err
+}
+
+func (w *recordingWriter) Close() error {
+	w.transcript.command("<message, %d bytes>", w.n)
+	w.transcript.command(".")
+	err := w.WriteCloser.Close()
+	w.transcript.reply(err)
+	return err
+}
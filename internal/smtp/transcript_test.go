@@ -0,0 +1,92 @@
+package smtp
+
+import (
+	"context"
+	"io"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/eexit/http2smtp/internal/converter"
+	"github.com/rs/zerolog"
+)
+
+func TestSMTP_Send_transcript(t *testing.T) {
+	tests := []struct {
+		name   string
+		client *fakeSMTP
+		want   string
+	}{
+		{
+			name: "message sent",
+			client: &fakeSMTP{
+				mail: strCmdOK,
+				rcpt: strCmdOK,
+				data: dataOK,
+			},
+			want: "* connected to localhost:25\r\n" +
+				"C: MAIL FROM:<from@example.com>\r\n" +
+				"C: RCPT TO:<to@example.com>\r\n" +
+				"C: DATA\r\n" +
+				"C: <message, 19 bytes>\r\n" +
+				"C: .\r\n",
+		},
+		{
+			name: "recipient rejected",
+			client: &fakeSMTP{
+				mail: strCmdOK,
+				rcpt: func(string) error {
+					return &textproto.Error{Code: 550, Msg: "5.1.1 mailbox unavailable"}
+				},
+				data: dataOK,
+			},
+			want: "* connected to localhost:25\r\n" +
+				"C: MAIL FROM:<from@example.com>\r\n" +
+				"C: RCPT TO:<to@example.com>\r\n" +
+				"S: 550 5.1.1 mailbox unavailable\r\n",
+		},
+		{
+			name: "message rejected after data",
+			client: &fakeSMTP{
+				mail: strCmdOK,
+				rcpt: strCmdOK,
+				data: func() (io.WriteCloser, error) {
+					return &fakeWriteCloser{closeErr: &textproto.Error{Code: 554, Msg: "5.7.1 spam detected"}}, nil
+				},
+			},
+			want: "* connected to localhost:25\r\n" +
+				"C: MAIL FROM:<from@example.com>\r\n" +
+				"C: RCPT TO:<to@example.com>\r\n" +
+				"C: DATA\r\n" +
+				"C: <message, 19 bytes>\r\n" +
+				"C: .\r\n" +
+				"S: 554 5.7.1 spam detected\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &smtpClient{
+				addr:   "localhost:25",
+				pool:   newFakePool(tt.client),
+				logger: zerolog.Nop(),
+			}
+
+			transcript := &Transcript{}
+			msg := converter.NewMessage("from@example.com", []string{"to@example.com"}, nil, nil, strings.NewReader("Subject: test\r\n\r\nok"))
+			(s.Send(WithTranscript(context.Background(), transcript), msg))
+
+			if got := transcript.String(); got != tt.want {
+				t.Errorf("Transcript.String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranscript_nil(t *testing.T) {
+	var transcript *Transcript
+	transcript.Note("not recorded")
+
+	if got := transcript.String(); got != "" {
+		t.Errorf("Transcript.String() = %q, want an empty transcript", got)
+	}
+}